@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"car_project/pkg/model"
+	"car_project/pkg/token"
+
+	"github.com/gorilla/mux"
+)
+
+// accountUsable refuses to start or continue a session for accounts an
+// admin disabled or asked to reset their password
+func accountUsable(w http.ResponseWriter, user *model.User) bool {
+	if user.Disabled {
+		http.Error(w, "Account is disabled", http.StatusForbidden)
+		return false
+	}
+	if user.PasswordResetRequired {
+		http.Error(w, "A password reset is required, check your email for a reset link", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// adminUser is an admin's view of a user account
+type adminUser struct {
+	ID                    int        `json:"id"`
+	Username              string     `json:"username"`
+	DisplayName           string     `json:"display_name"`
+	Email                 string     `json:"email"`
+	Role                  model.Role `json:"role"`
+	Verified              bool       `json:"verified"`
+	TOTPEnabled           bool       `json:"totp_enabled"`
+	Disabled              bool       `json:"disabled"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	CreatedAt             *time.Time `json:"created_at,omitempty"`
+}
+
+func newAdminUser(user *model.User) adminUser {
+	return adminUser{
+		ID:                    user.ID,
+		Username:              user.Username,
+		DisplayName:           user.DisplayName,
+		Email:                 user.Email,
+		Role:                  user.Role,
+		Verified:              user.Verified,
+		TOTPEnabled:           user.TOTPEnabled,
+		Disabled:              user.Disabled,
+		PasswordResetRequired: user.PasswordResetRequired,
+		CreatedAt:             user.CreatedAt,
+	}
+}
+
+// pageParams reads the page and limit query parameters, defaulting to the
+// first page of 10
+func pageParams(r *http.Request) (int, int) {
+	query := r.URL.Query()
+	page, _ := strconv.Atoi(query.Get("page"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+	return page, limit
+}
+
+// targetUser reads the user ID from the route. Admins may not act on their
+// own account, so that they cannot lock themselves out.
+func targetUser(w http.ResponseWriter, r *http.Request) (Identity, int, bool) {
+	admin, _ := IdentityFromContext(r.Context())
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return admin, 0, false
+	}
+	if userID == admin.UserID {
+		http.Error(w, "Admins cannot change their own account here", http.StatusForbidden)
+		return admin, 0, false
+	}
+	return admin, userID, true
+}
+
+// GetUsers pages through users, optionally searching by name or email with ?q=
+func (s *Server) GetUsers(w http.ResponseWriter, r *http.Request) {
+	page, limit := pageParams(r)
+	users, err := s.Users.ListUsers(r.Context(), page, limit, r.URL.Query().Get("q"))
+	if err != nil {
+		dbError(w, r, err, "Error fetching users")
+		return
+	}
+
+	views := make([]adminUser, 0, len(users))
+	for i := range users {
+		views = append(views, newAdminUser(&users[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+// GetUser returns one user account
+func (s *Server) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newAdminUser(user))
+}
+
+// DisableUser stops a user from logging in or calling the API. Their
+// sessions end at once: refresh tokens are revoked with the flag and access
+// tokens already issued are revoked here.
+func (s *Server) DisableUser(w http.ResponseWriter, r *http.Request) {
+	s.setUserDisabled(w, r, true)
+}
+
+// EnableUser lets a disabled user log in again
+func (s *Server) EnableUser(w http.ResponseWriter, r *http.Request) {
+	s.setUserDisabled(w, r, false)
+}
+
+func (s *Server) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	admin, userID, ok := targetUser(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := s.Admin.SetUserDisabled(r.Context(), admin.UserID, userID, disabled)
+	if err != nil {
+		dbError(w, r, err, "Error updating user")
+		return
+	}
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if disabled {
+		if err := s.Revocations.RevokeUser(r.Context(), userID, token.AccessTokenTTL); err != nil {
+			log.Printf("could not revoke access tokens of disabled user %d: %v", userID, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetUserRole changes a user's role. Requests are authorized with the role
+// the account has now, so it applies to tokens already issued.
+func (s *Server) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role model.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !req.Role.Valid() {
+		http.Error(w, "Role must be viewer, editor or admin", http.StatusBadRequest)
+		return
+	}
+
+	admin, userID, ok := targetUser(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := s.Admin.SetUserRole(r.Context(), admin.UserID, userID, req.Role)
+	if err != nil {
+		dbError(w, r, err, "Error updating user")
+		return
+	}
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForcePasswordReset logs a user out everywhere, refuses their logins until
+// they choose a new password, and mails them a reset link
+func (s *Server) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	admin, userID, ok := targetUser(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := s.Admin.RequirePasswordReset(r.Context(), admin.UserID, userID)
+	if err != nil {
+		dbError(w, r, err, "Error updating user")
+		return
+	}
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.Revocations.RevokeUser(r.Context(), userID, token.AccessTokenTTL); err != nil {
+		log.Printf("could not revoke access tokens of user %d: %v", userID, err)
+	}
+
+	user, err := s.Users.GetUserByID(r.Context(), userID)
+	if err == nil {
+		err = s.sendPasswordResetEmail(r.Context(), user)
+	}
+	if err != nil {
+		log.Printf("could not send password reset email to user %d: %v", userID, err)
+		http.Error(w, "Password reset required, but the reset email could not be sent", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAuditLog pages through the admin actions, newest first, optionally
+// limited to one user with ?user_id=
+func (s *Server) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	page, limit := pageParams(r)
+
+	var targetUserID int
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		var err error
+		if targetUserID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid user_id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := s.Admin.GetAuditLog(r.Context(), page, limit, targetUserID)
+	if err != nil {
+		dbError(w, r, err, "Error fetching audit log")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
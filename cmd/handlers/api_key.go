@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"car_project/pkg/model"
+	"car_project/pkg/token"
+
+	"github.com/gorilla/mux"
+)
+
+// apiKeyPrefix starts every API key, which tells them apart from JWTs
+const apiKeyPrefix = "ck_"
+
+// authenticateAPIKey identifies the caller from an API key, refusing it on
+// routes that don't accept API keys. RequireScope checks the key's scopes.
+func (s *Server) authenticateAPIKey(w http.ResponseWriter, r *http.Request, key string, next http.Handler) {
+	apiKey, err := s.APIKeys.UseAPIKey(r.Context(), token.HashOpaqueToken(key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		dbError(w, r, err, "Error checking API key")
+		return
+	}
+
+	user, err := s.Users.GetUserByID(r.Context(), apiKey.UserID)
+	if err != nil {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+	if !accountUsable(w, user) {
+		return
+	}
+
+	// Keys lose access to the organization's data with their owner
+	orgRole, err := s.Orgs.GetOrgRole(r.Context(), apiKey.OrgID, user.ID)
+	if err != nil {
+		dbError(w, r, err, "Error checking API key")
+		return
+	}
+	if orgRole == "" {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	// Keys act with the owner's current role, but never as an admin
+	id := Identity{UserID: user.ID, Username: user.Username, Role: user.Role, OrgID: apiKey.OrgID, APIKeyID: apiKey.ID, Scopes: apiKey.Scopes}
+	if id.Role == model.RoleAdmin {
+		id.Role = model.RoleEditor
+	}
+
+	if routeScope(r) == "" {
+		http.Error(w, "Forbidden: this endpoint is not available to API keys", http.StatusForbidden)
+		return
+	}
+
+	next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+}
+
+// RequireScope wraps a handler so that API keys may call it only if they
+// hold the given scope; other callers pass through. API keys are refused on
+// routes not registered with it. It must run behind Authenticate.
+func RequireScope(scope string, next http.HandlerFunc) http.Handler {
+	return scopedHandler{scope: scope, next: next}
+}
+
+// scopedHandler is a route handler wrapped by RequireScope
+type scopedHandler struct {
+	scope string
+	next  http.HandlerFunc
+}
+
+func (h scopedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFromContext(r.Context())
+	if !id.HasScope(h.scope) {
+		http.Error(w, fmt.Sprintf("Forbidden: this API key lacks the %s scope", h.scope), http.StatusForbidden)
+		return
+	}
+	h.next(w, r)
+}
+
+// routeScope returns the scope the matched route requires of API keys, or
+// "" if it wasn't registered with RequireScope
+func routeScope(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	if h, ok := route.GetHandler().(scopedHandler); ok {
+		return h.scope
+	}
+	return ""
+}
+
+// CreateAPIKey creates a named key for the caller, limited to the requested
+// scopes. The key itself is returned only in this response.
+func (s *Server) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !validScope(scope) {
+			http.Error(w, fmt.Sprintf("Unknown scope %q, expected one of %s", scope, strings.Join(model.AllScopes, ", ")), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Keys work on the organization the caller is in when creating them
+	id, _ := IdentityFromContext(r.Context())
+	if id.OrgID == 0 {
+		http.Error(w, noOrganization, http.StatusForbidden)
+		return
+	}
+
+	secret, _, err := token.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, "Error generating API key", http.StatusInternalServerError)
+		return
+	}
+	plain := apiKeyPrefix + secret
+
+	created, err := s.APIKeys.CreateAPIKey(r.Context(), model.APIKey{
+		UserID:  id.UserID,
+		OrgID:   id.OrgID,
+		Name:    req.Name,
+		Prefix:  plain[:len(apiKeyPrefix)+8],
+		KeyHash: token.HashOpaqueToken(plain),
+		Scopes:  req.Scopes,
+	})
+	if err != nil {
+		dbError(w, r, err, "Error storing API key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		*model.APIKey
+		Key string `json:"key"`
+	}{created, plain})
+}
+
+// GetAPIKeys lists the caller's API keys without their secrets
+func (s *Server) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFromContext(r.Context())
+
+	keys, err := s.APIKeys.GetAPIKeysByUser(r.Context(), id.UserID)
+	if err != nil {
+		dbError(w, r, err, "Error fetching API keys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey revokes one of the caller's API keys
+func (s *Server) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	id, _ := IdentityFromContext(r.Context())
+	found, err := s.APIKeys.RevokeAPIKey(r.Context(), id.UserID, keyID)
+	if err != nil {
+		dbError(w, r, err, "Error revoking API key")
+		return
+	}
+	if !found {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validScope(scope string) bool {
+	for _, s := range model.AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"time"
+)
+
+// backgroundTimeout bounds work a handler leaves running after it responds
+const backgroundTimeout = time.Minute
+
+// Background work runs on backgroundWorkers goroutines. At most
+// backgroundQueueSize jobs wait for them; more are dropped rather than
+// piling up goroutines and outgoing mail under a flood of requests.
+const (
+	backgroundWorkers   = 4
+	backgroundQueueSize = 100
+)
+
+// jobQueue runs work that outlives the request that asked for it
+type jobQueue struct {
+	jobs chan func(ctx context.Context)
+}
+
+// newJobQueue starts the workers of a queue. They run for the life of the
+// process.
+func newJobQueue(workers, size int) *jobQueue {
+	q := &jobQueue{jobs: make(chan func(ctx context.Context), size)}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *jobQueue) work() {
+	for job := range q.jobs {
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundTimeout)
+		job(ctx)
+		cancel()
+	}
+}
+
+// enqueue schedules job without waiting. It returns false if the queue is
+// full and the job was dropped.
+func (q *jobQueue) enqueue(job func(ctx context.Context)) bool {
+	select {
+	case q.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"car_project/pkg/db"
+)
+
+// constraintError responds to a write the database refused. Values that
+// are out of range or refer to nothing get 422, writes that clash with
+// other rows get 409. The body names the offending field, if known:
+//
+//	{"error": "stars must be between 1 and 5", "fields": {"stars": "must be between 1 and 5"}}
+func constraintError(w http.ResponseWriter, ce *db.ConstraintError) {
+	status := http.StatusUnprocessableEntity
+	if ce.Kind == db.Unique || ce.Kind == db.Referenced {
+		status = http.StatusConflict
+	}
+
+	body := struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields,omitempty"`
+	}{Error: ce.Error()}
+	if ce.Field != "" {
+		body.Fields = map[string]string{ce.Field: ce.Message}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"car_project/pkg/model"
+
+	"github.com/gorilla/mux"
+)
+
+// carAccess checks that the caller holds at least the given role on a car
+// and returns the organization the car belongs to. Inside their own
+// organization callers act with their role; grants on the car can raise it,
+// and are the only way to reach cars of other organizations. Cars the
+// caller can't see at all are reported as not found.
+func (s *Server) carAccess(w http.ResponseWriter, r *http.Request, carID int, need model.Role) (int, bool) {
+	id, _ := IdentityFromContext(r.Context())
+	carOrgID, grant, found, err := s.Cars.CarAccess(r.Context(), id.OrgID, id.UserID, carID)
+	if err != nil {
+		dbError(w, r, err, "Error checking car access")
+		return 0, false
+	}
+	if !found {
+		http.Error(w, "Car not found", http.StatusNotFound)
+		return 0, false
+	}
+
+	role := grant
+	if carOrgID == id.OrgID {
+		role = role.Max(id.Role)
+	}
+	if !role.AtLeast(need) {
+		http.Error(w, fmt.Sprintf("Forbidden: this action requires the %s role or higher on this car", need), http.StatusForbidden)
+		return 0, false
+	}
+	return carOrgID, true
+}
+
+// historyAccess is carAccess for the car a history record belongs to
+func (s *Server) historyAccess(w http.ResponseWriter, r *http.Request, historyID int, need model.Role) (int, bool) {
+	carID, err := s.History.GetCarHistoryCarID(r.Context(), historyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Car history not found", http.StatusNotFound)
+		return 0, false
+	}
+	if err != nil {
+		dbError(w, r, err, "Failed to get car history")
+		return 0, false
+	}
+
+	return s.carAccess(w, r, carID, need)
+}
+
+// grantManager checks that the caller may manage the grants on a car: an
+// owner of the car's organization, or an admin working in it
+func (s *Server) grantManager(w http.ResponseWriter, r *http.Request) (int, bool) {
+	carID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid car ID", http.StatusBadRequest)
+		return 0, false
+	}
+
+	id, _ := IdentityFromContext(r.Context())
+	carOrgID, _, found, err := s.Cars.CarAccess(r.Context(), id.OrgID, id.UserID, carID)
+	if err != nil {
+		dbError(w, r, err, "Error checking car access")
+		return 0, false
+	}
+	if !found || carOrgID != id.OrgID {
+		http.Error(w, "Car not found", http.StatusNotFound)
+		return 0, false
+	}
+
+	if id.Role != model.RoleAdmin {
+		orgRole, err := s.Orgs.GetOrgRole(r.Context(), id.OrgID, id.UserID)
+		if err != nil {
+			dbError(w, r, err, "Error checking membership")
+			return 0, false
+		}
+		if orgRole != model.OrgRoleOwner {
+			http.Error(w, "Forbidden: only organization owners may share cars", http.StatusForbidden)
+			return 0, false
+		}
+	}
+	return carID, true
+}
+
+// GrantCarAccess gives a user viewer or editor access to one car and its
+// history, replacing any grant they already had on it
+func (s *Server) GrantCarAccess(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string     `json:"username"`
+		Role     model.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role != model.RoleViewer && req.Role != model.RoleEditor {
+		http.Error(w, "Role must be viewer or editor", http.StatusBadRequest)
+		return
+	}
+
+	carID, ok := s.grantManager(w, r)
+	if !ok {
+		return
+	}
+
+	grantee, err := s.Users.GetUserByUsername(r.Context(), req.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusBadRequest)
+			return
+		}
+		dbError(w, r, err, "Error reading user")
+		return
+	}
+
+	id, _ := IdentityFromContext(r.Context())
+	grant, err := s.Cars.SaveCarGrant(r.Context(), model.CarGrant{
+		CarID:     carID,
+		UserID:    grantee.ID,
+		Username:  grantee.Username,
+		Role:      req.Role,
+		GrantedBy: id.UserID,
+	})
+	if err != nil {
+		dbError(w, r, err, "Error storing grant")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(grant)
+}
+
+// GetCarGrants lists who a car is shared with
+func (s *Server) GetCarGrants(w http.ResponseWriter, r *http.Request) {
+	carID, ok := s.grantManager(w, r)
+	if !ok {
+		return
+	}
+
+	grants, err := s.Cars.GetCarGrants(r.Context(), carID)
+	if err != nil {
+		dbError(w, r, err, "Error fetching grants")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grants)
+}
+
+// RevokeCarGrant stops sharing a car with a user
+func (s *Server) RevokeCarGrant(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["userID"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	carID, ok := s.grantManager(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := s.Cars.DeleteCarGrant(r.Context(), carID, userID)
+	if err != nil {
+		dbError(w, r, err, "Error revoking grant")
+		return
+	}
+	if !found {
+		http.Error(w, "Grant not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSharedCars lists the cars other organizations shared with the caller
+func (s *Server) GetSharedCars(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFromContext(r.Context())
+	cars, err := s.Cars.GetSharedCars(r.Context(), id.UserID)
+	if err != nil {
+		dbError(w, r, err, "Error fetching shared cars")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cars)
+}
@@ -2,504 +2,833 @@ package handlers
 
 import (
 	"car_project/pkg/db"
+	"car_project/pkg/lockout"
 	"car_project/pkg/model"
-	"context"
+	"car_project/pkg/password"
+	"car_project/pkg/token"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/mail"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func Authenticate(next http.Handler) http.Handler {
+// Authenticate identifies the caller from a bearer JWT or an API key. API
+// keys may be passed in the X-API-Key header or as a bearer token.
+func (s *Server) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey := r.Header.Get("X-API-Key")
 		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
+		if apiKey == "" && authHeader == "" {
 			http.Error(w, "Authorization required", http.StatusUnauthorized)
 			return
 		}
 
-		bearerToken := strings.Split(authHeader, " ")
-		if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
-			http.Error(w, "Invalid token format", http.StatusUnauthorized)
-			return
+		if apiKey == "" {
+			bearerToken := strings.Split(authHeader, " ")
+			if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
+				http.Error(w, "Invalid token format", http.StatusUnauthorized)
+				return
+			}
+			if strings.HasPrefix(bearerToken[1], apiKeyPrefix) {
+				apiKey = bearerToken[1]
+			} else {
+				s.authenticateJWT(w, r, bearerToken[1], next)
+				return
+			}
 		}
 
-		token, err := jwt.Parse(bearerToken[1], func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte("YourSecretKey"), nil // Use your actual secret key
-		})
+		s.authenticateAPIKey(w, r, apiKey, next)
+	})
+}
 
-		if err != nil {
+func (s *Server) authenticateJWT(w http.ResponseWriter, r *http.Request, tokenString string, next http.Handler) {
+	claims, err := token.ParseAccessToken(tokenString)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	// JSON numbers decode as float64
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	id := Identity{UserID: int(userID), Role: model.RoleViewer}
+	id.Username, _ = claims["username"].(string)
+	id.TokenID, _ = claims["jti"].(string)
+	id.SessionID, _ = claims["sid"].(string)
+	if exp, ok := claims["exp"].(float64); ok {
+		id.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+
+	// Tokens issued before revocation existed carry no jti and can't be revoked one by one
+	var issuedAt time.Time
+	if iat, ok := claims["iat"].(float64); ok {
+		issuedAt = token.IssuedAt(iat)
+	}
+	if s.Revocations.IsRevoked(id.TokenID, id.UserID, issuedAt) {
+		http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+		return
+	}
+
+	// Revocations reach other instances only when they next sync, so the
+	// account itself decides whether its tokens are still good
+	user, err := s.Users.GetUserByID(r.Context(), id.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
+		dbError(w, r, err, "Error checking token")
+		return
+	}
+	if !accountUsable(w, user) {
+		return
+	}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// Inject user ID into the context for downstream handlers to use
-			type contextKey string
-
-			const (
-				userIDKey contextKey = "userID"
-			)
-			ctx := context.WithValue(r.Context(), userIDKey, claims["userID"])
-			r = r.WithContext(ctx)
-			next.ServeHTTP(w, r)
-		} else {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+	// The role is the account's current one, so a change by an admin applies
+	// at once; the claim only shows what it was when the token was issued
+	if user.Role.Valid() {
+		id.Role = user.Role
+	}
+	id.MFA, _ = claims["mfa"].(bool)
+	// Data is scoped to the organization the account is active in and still
+	// a member of, not the org claim, so a removed member loses access at once
+	id.OrgID = user.ActiveOrgID
+
+	// A password alone is not enough to act as an admin
+	if RequireAdminMFA && id.Role == model.RoleAdmin && !id.MFA {
+		id.Role = model.RoleViewer
+	}
+
+	// Inject the caller into the context for downstream handlers to use
+	next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+}
+
+// RequireRole wraps a handler so that only users holding at least the given
+// role may call it. It must run behind Authenticate.
+func RequireRole(min model.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := IdentityFromContext(r.Context())
+		if !id.Role.AtLeast(min) {
+			http.Error(w, fmt.Sprintf("Forbidden: this action requires the %s role or higher", min), http.StatusForbidden)
 			return
 		}
-	})
+		next(w, r)
+	}
 }
 
-func RegisterUser(w http.ResponseWriter, r *http.Request) {
+func (s *Server) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	var user model.User
-	_ = json.NewDecoder(r.Body).Decode(&user)
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// The username is the email address the verification link is sent to
+	address, err := mail.ParseAddress(user.Username)
+	if err != nil || address.Address != user.Username {
+		http.Error(w, "Username must be a valid email address", http.StatusBadRequest)
+		return
+	}
+
+	// New accounts always start with the lowest role and unverified, whatever the body says
+	user.Role = model.RoleViewer
+	user.Verified = false
+	user.Email = user.Username
+
+	if err := password.Check(user.Password, user.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Hash the password before storing it
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-	if err != nil {
+	if err := user.CreateUser(user.Password); err != nil {
 		http.Error(w, "Error while hashing password", http.StatusInternalServerError)
 		return
 	}
-	user.Password = string(hashedPassword)
 
-	// Store the user in the database (pseudo-code)
-	err = db.CreateUser(user)
+	err = s.Users.CreateUser(r.Context(), user)
 	if err != nil {
-		http.Error(w, "Error storing user in database", http.StatusInternalServerError)
+		dbError(w, r, err, "Error storing user in database")
 		return
 	}
 
+	// The account can't be used until the link in this email is opened;
+	// if sending fails the user can ask for another one
+	created, err := s.Users.GetUserByUsername(r.Context(), user.Username)
+	if err == nil {
+		err = s.sendVerificationEmail(r.Context(), created)
+	}
+	if err != nil {
+		log.Printf("could not send verification email to %s: %v", user.Username, err)
+	}
+
 	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte("User registered successfully"))
+	w.Write([]byte("User registered successfully, check your email to verify your account"))
 }
 
-func LoginUser(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LoginUser(w http.ResponseWriter, r *http.Request) {
 	var credentials model.User
 	_ = json.NewDecoder(r.Body).Decode(&credentials)
 
+	// Refuse early while the account or the client is backing off
+	accountKey := lockout.AccountKey(credentials.Username)
+	ipKey := lockout.IPKey(clientIP(r))
+	if s.loginLocked(w, r, accountKey, ipKey) {
+		return
+	}
+
 	// Retrieve user from the database
-	user, err := db.GetUserByUsername(credentials.Username)
-	if err != nil {
-		http.Error(w, "User not found", http.StatusUnauthorized)
+	user, err := s.Users.GetUserByUsername(r.Context(), credentials.Username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		dbError(w, r, err, "Error reading user")
 		return
 	}
 
-	// Compare hashed password
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(credentials.Password))
+	// Compare hashed password; unknown users are checked against a dummy hash
+	// so that they take as long as a wrong password
+	hash := dummyPasswordHash
+	if user != nil {
+		hash = user.Password
+	}
+	match, err := password.Verify(credentials.Password, hash)
 	if err != nil {
-		http.Error(w, "Invalid login credentials", http.StatusUnauthorized)
+		log.Printf("could not check password: %v", err)
+	}
+	if !match || user == nil {
+		s.recordLoginFailure(r.Context(), accountKey, lockout.AccountPolicy)
+		s.recordLoginFailure(r.Context(), ipKey, lockout.IPPolicy)
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
 
-	// Create a JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"username": user.Username,
-		"userID":   user.ID,
-		"exp":      time.Now().Add(time.Hour * 72).Unix(),
-	})
+	if err := s.Logins.ClearLoginFailures(r.Context(), accountKey); err != nil {
+		log.Printf("could not clear login failures for user %d: %v", user.ID, err)
+	}
+
+	// Legacy bcrypt hashes are upgraded while the plain password is at hand
+	if user.NeedsRehash() {
+		rehashed := *user
+		if err := rehashed.CreateUser(credentials.Password); err != nil {
+			log.Printf("could not rehash password of user %d: %v", user.ID, err)
+		} else if err := s.Users.UpdateUserPassword(r.Context(), user.ID, rehashed.Password); err != nil {
+			log.Printf("could not store rehashed password of user %d: %v", user.ID, err)
+		}
+	}
+
+	if !user.Verified {
+		http.Error(w, "Email address not verified", http.StatusForbidden)
+		return
+	}
+
+	if !accountUsable(w, user) {
+		return
+	}
+
+	// With 2FA enabled the password only earns a challenge for /user/login/2fa
+	if user.TOTPEnabled {
+		writeMFAChallenge(w, user)
+		return
+	}
 
-	tokenString, err := token.SignedString([]byte("YourSecretKey"))
+	s.issueTokens(w, r, user, false)
+}
+
+// tokenResponse is returned by LoginUser and RefreshToken
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// issueTokens starts a new session for the user and writes its access token
+// and refresh token. mfa records whether a second factor was presented.
+func (s *Server) issueTokens(w http.ResponseWriter, r *http.Request, user *model.User, mfa bool) {
+	refreshToken, hash, err := token.NewRefreshToken()
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+	familyID, err := token.NewFamilyID()
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+	accessToken, err := token.NewAccessToken(user, familyID, mfa)
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 
-	w.Write([]byte(tokenString))
+	err = s.Sessions.CreateRefreshToken(r.Context(), model.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hash,
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(token.RefreshTokenTTL),
+		MFA:       mfa,
+	})
+	if err != nil {
+		dbError(w, r, err, "Error storing refresh token")
+		return
+	}
+
+	writeTokens(w, accessToken, refreshToken)
 }
 
-func Activate(w http.ResponseWriter, r *http.Request) {
-	// Extract the expired token from the request header or request body
-	expiredToken := r.Header.Get("Expired-Token")
-	if expiredToken == "" {
-		http.Error(w, "Expired token not provided", http.StatusBadRequest)
+func writeTokens(w http.ResponseWriter, accessToken, refreshToken string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(token.AccessTokenTTL.Seconds()),
+	})
+}
+
+// RefreshToken exchanges a refresh token for a new access token and rotates it.
+// Presenting a token that was already rotated revokes its whole family.
+func (s *Server) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.RefreshToken == "" {
+		http.Error(w, "Refresh token not provided", http.StatusBadRequest)
 		return
 	}
 
-	// Parse the expired token
-	token, err := jwt.Parse(expiredToken, func(token *jwt.Token) (interface{}, error) {
-		// Check the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte("YourSecretKey"), nil // Use your actual secret key
-	})
+	current, err := s.Sessions.GetRefreshTokenByHash(r.Context(), token.HashRefreshToken(req.RefreshToken))
 	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		dbError(w, r, err, "Error reading refresh token")
 		return
 	}
 
-	// Extract claims from the expired token
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+	if current.RevokedAt != nil {
+		// A rotated token is being replayed: assume it leaked and kill the chain
+		if err := s.Sessions.RevokeRefreshTokenFamily(r.Context(), current.FamilyID); err != nil {
+			dbError(w, r, err, "Error revoking refresh tokens")
+			return
+		}
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if time.Now().After(current.ExpiresAt) {
+		http.Error(w, "Refresh token expired", http.StatusUnauthorized)
 		return
 	}
 
-	// Extract user ID from claims
-	userID, ok := claims["userID"].(int)
-	if !ok {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+	user, err := s.Users.GetUserByID(r.Context(), current.UserID)
+	if err != nil {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if !accountUsable(w, user) {
 		return
 	}
 
-	// Generate a new JWT token
-	newToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID": userID,
-		"exp":    time.Now().Add(time.Hour * 72).Unix(),
+	refreshToken, hash, err := token.NewRefreshToken()
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+	err = s.Sessions.RotateRefreshToken(r.Context(), current.ID, model.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hash,
+		FamilyID:  current.FamilyID,
+		ExpiresAt: time.Now().Add(token.RefreshTokenTTL),
+		MFA:       current.MFA,
 	})
+	if err != nil {
+		if errors.Is(err, db.ErrRefreshTokenReused) {
+			s.Sessions.RevokeRefreshTokenFamily(r.Context(), current.FamilyID)
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		dbError(w, r, err, "Error rotating refresh token")
+		return
+	}
 
-	// Sign the token with the secret key
-	tokenString, err := newToken.SignedString([]byte("YourSecretKey"))
+	accessToken, err := token.NewAccessToken(user, current.FamilyID, current.MFA)
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 
-	// Return the new JWT token
-	w.Write([]byte(tokenString))
+	writeTokens(w, accessToken, refreshToken)
 }
 
-func CreateCar(w http.ResponseWriter, r *http.Request) {
-		var c model.Car
-		_ = json.NewDecoder(r.Body).Decode(&c)
-		err := db.CreateCar(c)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusCreated)
+func (s *Server) CreateCar(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := tenant(w, r)
+	if !ok {
+		return
+	}
+	var c model.Car
+	_ = json.NewDecoder(r.Body).Decode(&c)
+	err := s.Cars.CreateCar(r.Context(), orgID, c)
+	if err != nil {
+		dbError(w, r, err, "Error creating car")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
 }
 
-func GetAllCars(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query()
-		page, _ := strconv.Atoi(query.Get("page"))
-		limit, _ := strconv.Atoi(query.Get("limit"))
-		sortBy := query.Get("sortBy")
-		filterBy := query.Get("filterBy")
+func (s *Server) GetAllCars(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := tenant(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	sortBy, err := db.CarFields.ParseSort(query.Get("sortBy"))
+	if err != nil {
+		http.Error(w, "Invalid sortBy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	filterBy, err := db.CarFields.ParseFilter(query.Get("filterBy"))
+	if err != nil {
+		http.Error(w, "Invalid filterBy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	list, err := parseListPage(r, db.CarFields, sortBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		if page == 0 {
-			page = 1
-		}
-		if limit == 0 {
-			limit = 10
-		}
+	cars, err := s.Cars.GetCarWithPagination(r.Context(), orgID, list.fetch(), sortBy, filterBy)
+	if err != nil {
+		dbError(w, r, err, "Error fetching cars")
+		return
+	}
+	writeList(w, r, list, cars, func() (int, error) {
+		return s.Cars.CountCars(r.Context(), orgID, filterBy)
+	})
+}
 
-		cars, err := db.GetCarWithPagination(page, limit, sortBy, filterBy)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		json.NewEncoder(w).Encode(cars)
+func (s *Server) GetCar(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, _ := strconv.Atoi(params["id"])
+	orgID, ok := s.carAccess(w, r, id, model.RoleViewer)
+	if !ok {
+		return
 	}
+	car, err := s.Cars.GetCarByID(r.Context(), orgID, id)
+	if err != nil {
+		dbError(w, r, err, "Error fetching car")
+		return
+	}
+	if car == nil {
+		http.Error(w, "Car not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(car)
+}
 
-func GetCar(w http.ResponseWriter, r *http.Request) {
-		params := mux.Vars(r)
-		id, _ := strconv.Atoi(params["id"])
-		car, err := db.GetCarByID(id)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		json.NewEncoder(w).Encode(car)
+func (s *Server) UpdateCar(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars((r))
+	id, _ := strconv.Atoi(params["id"])
+	orgID, ok := s.carAccess(w, r, id, model.RoleEditor)
+	if !ok {
+		return
 	}
+	var c model.Car
+	_ = json.NewDecoder(r.Body).Decode(&c)
+	found, err := s.Cars.UpdateCarByID(r.Context(), orgID, id, c)
+	if err != nil {
+		dbError(w, r, err, "Error updating car")
+		return
+	}
+	if !found {
+		http.Error(w, "Car not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-func UpdateCar(w http.ResponseWriter, r *http.Request) {
-		params := mux.Vars((r))
-		id, _ := strconv.Atoi(params["id"])
-		var c model.Car
-		_ = json.NewDecoder(r.Body).Decode(&c)
-		err := db.UpdateCarByID(id, c)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
+func (s *Server) DeleteCar(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := tenant(w, r)
+	if !ok {
+		return
+	}
+	params := mux.Vars(r)
+	id, _ := strconv.Atoi(params["id"])
+	found, err := s.Cars.DeleteCarByID(r.Context(), orgID, id)
+	if err != nil {
+		dbError(w, r, err, "Error deleting car")
+		return
+	}
+	if !found {
+		http.Error(w, "Car not found", http.StatusNotFound)
+		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-func DeleteCar(w http.ResponseWriter, r *http.Request) {
-		params := mux.Vars(r)
-		id, _ := strconv.Atoi(params["id"])
-		err := db.DeleteCarByID(id)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
+// errCarNotFound ends a unit of work that found its car deleted
+var errCarNotFound = errors.New("car not found")
+
+func (s *Server) CreateCarHistory(w http.ResponseWriter, r *http.Request) {
+	var carHistory model.CarHistory
+	err := json.NewDecoder(r.Body).Decode(&carHistory)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
 	}
 
-func CreateCarHistory(w http.ResponseWriter, r *http.Request) {
-		var carHistory model.CarHistory
-		err := json.NewDecoder(r.Body).Decode(&carHistory)
-		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
+	// Validate input fields
+	if carHistory.CarID <= 0 {
+		http.Error(w, "Invalid CarID", http.StatusBadRequest)
+		return
+	}
 
-		// Validate input fields
-		if carHistory.CarID <= 0 {
-			http.Error(w, "Invalid CarID", http.StatusBadRequest)
-			return
-		}
+	// Check that the car exists and the caller may edit it
+	orgID, ok := s.carAccess(w, r, carHistory.CarID, model.RoleEditor)
+	if !ok {
+		return
+	}
+	if carHistory.Type != "accident" && carHistory.Type != "service" {
+		http.Error(w, "Invalid Type", http.StatusBadRequest)
+		return
+	}
+
+	// Additional input validation logic can be added here
 
-		// Check if the car with the provided ID exists
-		car, err := db.GetCarByID(carHistory.CarID)
+	carHistory.Date = time.Now() // Set current time as the date
+	err = s.Tx.Transact(r.Context(), func(tx db.Stores) error {
+		car, err := tx.Cars.GetCarByID(r.Context(), orgID, carHistory.CarID)
 		if err != nil {
-			http.Error(w, "Failed to get car by ID", http.StatusInternalServerError)
-			return
+			return err
 		}
 		if car == nil {
-			http.Error(w, "CarID does not exist", http.StatusBadRequest)
-			return
-		}
-		if carHistory.Type != "accident" && carHistory.Type != "service" {
-			http.Error(w, "Invalid Type", http.StatusBadRequest)
-			return
+			return errCarNotFound
 		}
+		return tx.History.CreateCarHistory(r.Context(), orgID, carHistory)
+	})
+	if errors.Is(err, errCarNotFound) {
+		http.Error(w, "Car not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		dbError(w, r, err, "Failed to create car history")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
 
-		// Additional input validation logic can be added here
+func (s *Server) GetAllCarHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
 
-		carHistory.Date = time.Now() // Set current time as the date
-		err = db.CreateCarHistory(carHistory)
-		if err != nil {
-			http.Error(w, "Failed to create car history", http.StatusInternalServerError)
+	// History of one car may come from a car shared by another organization
+	var orgID, carID int
+	var ok bool
+	if v := query.Get("car_id"); v != "" {
+		var err error
+		if carID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid car_id", http.StatusBadRequest)
 			return
 		}
+		orgID, ok = s.carAccess(w, r, carID, model.RoleViewer)
+	} else {
+		orgID, ok = tenant(w, r)
+	}
+	if !ok {
+		return
+	}
 
-		w.WriteHeader(http.StatusCreated)
+	sortBy, err := db.CarHistoryFields.ParseSort(query.Get("sortBy"))
+	if err != nil {
+		http.Error(w, "Invalid sortBy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	filterBy, err := db.CarHistoryFields.ParseFilter(query.Get("filterBy"))
+	if err != nil {
+		http.Error(w, "Invalid filterBy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	list, err := parseListPage(r, db.CarHistoryFields, sortBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-func GetAllCarHistory(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query()
-		page, _ := strconv.Atoi(query.Get("page"))
-		limit, _ := strconv.Atoi(query.Get("limit"))
-		sortBy := query.Get("sortBy")
-		filterBy := query.Get("filterBy")
+	carHistory, err := s.History.GetCarAllHistory(r.Context(), orgID, carID, list.fetch(), sortBy, filterBy)
+	if err != nil {
+		dbError(w, r, err, "Failed to get car history")
+		return
+	}
 
-		if page == 0 {
-			page = 1
-		}
-		if limit == 0 {
-			limit = 10
-		}
+	writeList(w, r, list, carHistory, func() (int, error) {
+		return s.History.CountCarHistory(r.Context(), orgID, carID, filterBy)
+	})
+}
 
-		carHistory, err := db.GetCarAllHistory(page, limit, sortBy, filterBy)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+// GetCarHistoryByID retrieves a car history record by ID
+func (s *Server) GetCarHistoryByID(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		http.Error(w, "Invalid car history ID", http.StatusBadRequest)
+		return
+	}
+	orgID, ok := s.historyAccess(w, r, id, model.RoleViewer)
+	if !ok {
+		return
+	}
 
-		json.NewEncoder(w).Encode(carHistory)
+	carHistory, err := s.History.GetCarHistoryByID(r.Context(), orgID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Car history not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		dbError(w, r, err, "Failed to get car history")
+		return
 	}
 
-// GetCarHistoryByID retrieves a car history record by ID
-func GetCarHistoryByID(w http.ResponseWriter, r *http.Request) {
-		params := mux.Vars(r)
-		id, err := strconv.Atoi(params["id"])
-		if err != nil {
-			http.Error(w, "Invalid car history ID", http.StatusBadRequest)
-			return
-		}
+	json.NewEncoder(w).Encode(carHistory)
+}
 
-		carHistory, err := db.GetCarHistoryByID(id)
-		if err != nil {
-			http.Error(w, "Failed to get car history", http.StatusInternalServerError)
-			return
-		}
+// UpdateCarHistory updates an existing car history record
+func (s *Server) UpdateCarHistory(w http.ResponseWriter, r *http.Request) {
+	var carHistory model.CarHistory
+	err := json.NewDecoder(r.Body).Decode(&carHistory)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
 
-		json.NewEncoder(w).Encode(carHistory)
+	// The caller needs edit access to the record's car, and to the car it
+	// is moved to if that changes
+	orgID, ok := s.historyAccess(w, r, carHistory.ID, model.RoleEditor)
+	if !ok {
+		return
+	}
+	targetOrgID, ok := s.carAccess(w, r, carHistory.CarID, model.RoleEditor)
+	if !ok {
+		return
+	}
+	if targetOrgID != orgID {
+		http.Error(w, "Car history can't be moved to another organization's car", http.StatusBadRequest)
+		return
 	}
 
+	found, err := s.History.UpdateCarHistory(r.Context(), orgID, carHistory)
+	if err != nil {
+		dbError(w, r, err, "Failed to update car history")
+		return
+	}
+	if !found {
+		http.Error(w, "Car history not found", http.StatusNotFound)
+		return
+	}
 
-// UpdateCarHistory updates an existing car history record
-func UpdateCarHistory(w http.ResponseWriter, r *http.Request) {
-		var carHistory model.CarHistory
-		err := json.NewDecoder(r.Body).Decode(&carHistory)
-		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
+	w.WriteHeader(http.StatusOK)
+}
 
-		err = db.UpdateCarHistory(carHistory)
-		if err != nil {
-			http.Error(w, "Failed to update car history", http.StatusInternalServerError)
-			return
-		}
+// DeleteCarHistory deletes a car history record by ID
+func (s *Server) DeleteCarHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid car history ID", http.StatusBadRequest)
+		return
+	}
+	orgID, ok := s.historyAccess(w, r, id, model.RoleEditor)
+	if !ok {
+		return
+	}
 
-		w.WriteHeader(http.StatusOK)
+	found, err := s.History.DeleteCarHistory(r.Context(), orgID, id)
+	if err != nil {
+		dbError(w, r, err, "Failed to delete car history")
+		return
+	}
+	if !found {
+		http.Error(w, "Car history not found", http.StatusNotFound)
+		return
 	}
 
+	w.WriteHeader(http.StatusOK)
+}
 
-// DeleteCarHistory deletes a car history record by ID
-func DeleteCarHistory(w http.ResponseWriter, r *http.Request) {
-		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+func (s *Server) CreateRating(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := tenant(w, r)
+	if !ok {
+		return
+	}
+	var rating model.Rating
+	err := json.NewDecoder(r.Body).Decode(&rating)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The rating belongs to the caller unless an admin names another user
+	userID, ok := ratingOwner(r, rating.UserID)
+	if !ok {
+		http.Error(w, "Forbidden: only admins may act on another user's rating", http.StatusForbidden)
+		return
+	}
+	rating.UserID = userID
+
+	// The car can't be deleted between the check and the insert
+	err = s.Tx.Transact(r.Context(), func(tx db.Stores) error {
+		exists, err := tx.Cars.CarExists(r.Context(), orgID, rating.CarID)
 		if err != nil {
-			http.Error(w, "Invalid car history ID", http.StatusBadRequest)
-			return
+			return err
+		}
+		if !exists {
+			return errCarNotFound
 		}
+		return tx.Ratings.CreateRating(r.Context(), rating)
+	})
+	if errors.Is(err, errCarNotFound) {
+		http.Error(w, "Car ID does not exist", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		dbError(w, r, err, "Error inserting rating into database")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
 
-		err = db.DeleteCarHistory(id)
+func (s *Server) GetRating(w http.ResponseWriter, r *http.Request) {
+	// Parse the car_id from the URL path parameters
+	vars := mux.Vars(r)
+	carID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid car_id", http.StatusBadRequest)
+		return
+	}
+
+	// Ratings are visible to whoever may see the car, grants included
+	if _, ok := s.carAccess(w, r, carID, model.RoleViewer); !ok {
+		return
+	}
+
+	// Query the database for all ratings of the specified car
+	ratings, err := s.Ratings.GetRatingsByCar(r.Context(), carID)
+	if err != nil {
+		dbError(w, r, err, "Error fetching ratings from database")
+		return
+	}
+
+	// Return the ratings as JSON
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ratings)
+}
+
+func (s *Server) UpdateRating(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := tenant(w, r)
+	if !ok {
+		return
+	}
+	carID := r.URL.Query().Get("car_id")
+
+	var updatedRating model.Rating
+	err := json.NewDecoder(r.Body).Decode(&updatedRating)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Convert carID to an integer
+	carIDInt, err := strconv.Atoi(carID)
+	if err != nil {
+		http.Error(w, "Invalid car_id", http.StatusBadRequest)
+		return
+	}
+
+	// Act as the caller unless an admin names another user
+	requestedUserID := 0
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		requestedUserID, err = strconv.Atoi(userID)
 		if err != nil {
-			http.Error(w, "Failed to delete car history", http.StatusInternalServerError)
+			http.Error(w, "Invalid user_id", http.StatusBadRequest)
 			return
 		}
+	}
+	userIDInt, ok := ratingOwner(r, requestedUserID)
+	if !ok {
+		http.Error(w, "Forbidden: only admins may act on another user's rating", http.StatusForbidden)
+		return
+	}
 
-		w.WriteHeader(http.StatusOK)
-}
+	// Update the rating in the database
+	found, err := s.Ratings.UpdateRating(r.Context(), orgID, carIDInt, userIDInt, updatedRating)
+	if err != nil {
+		dbError(w, r, err, "Error updating rating in database")
+		return
+	}
+	if !found {
+		http.Error(w, "Rating not found", http.StatusNotFound)
+		return
+	}
 
-func CreateRating(w http.ResponseWriter, r *http.Request) {
-    var rating model.Rating
-    err := json.NewDecoder(r.Body).Decode(&rating)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return
-    }
-
-    // Validate car_id
-    exists, err := db.CarExists(rating.CarID)
-    if err != nil {
-        http.Error(w, "Error checking car ID", http.StatusInternalServerError)
-        return
-    }
-    if !exists {
-        http.Error(w, "Car ID does not exist", http.StatusBadRequest)
-        return
-    }
-
-    // Validate user_id
-    if rating.UserID <= 0 {
-        http.Error(w, "Invalid user_id", http.StatusBadRequest)
-        return
-    }
-
-    // Insert the rating into the database
-    // (Assuming you have a ratings table)
-    insertQuery := "INSERT INTO ratings (car_id, stars, user_id, comment) VALUES ($1, $2, $3, $4)"
-    _, err = db.DB.Exec(insertQuery, rating.CarID, rating.Stars, rating.UserID, rating.Comment)
-    if err != nil {
-        http.Error(w, "Error inserting rating into database", http.StatusInternalServerError)
-        return
-    }
-
-    w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusNoContent)
 }
 
+func (s *Server) DeleteRating(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := tenant(w, r)
+	if !ok {
+		return
+	}
+	carID := r.URL.Query().Get("car_id")
 
-func GetRating(w http.ResponseWriter, r *http.Request) {
-    // Parse the car_id from the URL path parameters
-    vars := mux.Vars(r)
-    carID, err := strconv.Atoi(vars["id"])
-    if err != nil {
-        http.Error(w, "Invalid car_id", http.StatusBadRequest)
-        return
-    }
-
-    // Validate car_id
-    exists, err := db.CarExists(carID)
-    if err != nil {
-        http.Error(w, "Error checking car ID", http.StatusInternalServerError)
-        return
-    }
-    if !exists {
-        http.Error(w, "Car ID does not exist", http.StatusBadRequest)
-        return
-    }
-
-    // Query the database for all ratings of the specified car
-    query := "SELECT car_id, stars, user_id, comment FROM ratings WHERE car_id = $1"
-    rows, err := db.DB.Query(query, carID)
-    if err != nil {
-        http.Error(w, "Error fetching ratings from database", http.StatusInternalServerError)
-        return
-    }
-    defer rows.Close()
-
-    // Slice to hold all ratings for the car
-    var ratings []model.Rating
-
-    // Iterate through the rows and populate the ratings slice
-    for rows.Next() {
-        var rating model.Rating
-        if err := rows.Scan(&rating.CarID, &rating.Stars, &rating.UserID, &rating.Comment); err != nil {
-            http.Error(w, "Error scanning ratings from database", http.StatusInternalServerError)
-            return
-        }
-        ratings = append(ratings, rating)
-    }
-    if err := rows.Err(); err != nil {
-        http.Error(w, "Error iterating through ratings", http.StatusInternalServerError)
-        return
-    }
-
-    // Return the ratings as JSON
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(ratings)
-}
+	// Convert carID to an integer
+	carIDInt, err := strconv.Atoi(carID)
+	if err != nil {
+		http.Error(w, "Invalid car_id", http.StatusBadRequest)
+		return
+	}
 
-func UpdateRating(w http.ResponseWriter, r *http.Request) {
-    carID := r.URL.Query().Get("car_id")
-    userID := r.URL.Query().Get("user_id")
-
-    var updatedRating model.Rating
-    err := json.NewDecoder(r.Body).Decode(&updatedRating)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return
-    }
-
-    // Convert carID and userID to integers
-    carIDInt, err := strconv.Atoi(carID)
-    if err != nil {
-        http.Error(w, "Invalid car_id", http.StatusBadRequest)
-        return
-    }
-    userIDInt, err := strconv.Atoi(userID)
-    if err != nil {
-        http.Error(w, "Invalid user_id", http.StatusBadRequest)
-        return
-    }
-
-    // Update the rating in the database
-    err = db.UpdateRating(carIDInt, userIDInt, updatedRating)
-    if err != nil {
-        http.Error(w, "Error updating rating in database", http.StatusInternalServerError)
-        return
-    }
-
-    w.WriteHeader(http.StatusNoContent)
-}
+	// Act as the caller unless an admin names another user
+	requestedUserID := 0
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		requestedUserID, err = strconv.Atoi(userID)
+		if err != nil {
+			http.Error(w, "Invalid user_id", http.StatusBadRequest)
+			return
+		}
+	}
+	userIDInt, ok := ratingOwner(r, requestedUserID)
+	if !ok {
+		http.Error(w, "Forbidden: only admins may act on another user's rating", http.StatusForbidden)
+		return
+	}
 
+	// Delete the rating from the database
+	found, err := s.Ratings.DeleteRating(r.Context(), orgID, carIDInt, userIDInt)
+	if err != nil {
+		dbError(w, r, err, "Error deleting rating from database")
+		return
+	}
+	if !found {
+		http.Error(w, "Rating not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
 
-func DeleteRating(w http.ResponseWriter, r *http.Request) {
-    carID := r.URL.Query().Get("car_id")
-    userID := r.URL.Query().Get("user_id")
-
-    // Convert carID and userID to integers
-    carIDInt, err := strconv.Atoi(carID)
-    if err != nil {
-        http.Error(w, "Invalid car_id", http.StatusBadRequest)
-        return
-    }
-    userIDInt, err := strconv.Atoi(userID)
-    if err != nil {
-        http.Error(w, "Invalid user_id", http.StatusBadRequest)
-        return
-    }
-
-    // Delete the rating from the database
-    err = db.DeleteRating(carIDInt, userIDInt)
-    if err != nil {
-        http.Error(w, "Error deleting rating from database", http.StatusInternalServerError)
-        return
-    }
-
-    w.WriteHeader(http.StatusNoContent)
+// JWKS serves the public signing keys so other services can verify tokens
+func (s *Server) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(token.Keys.JWKS())
 }
@@ -0,0 +1,476 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"car_project/pkg/db"
+	"car_project/pkg/db/memory"
+	"car_project/pkg/model"
+	"car_project/pkg/token"
+	"car_project/pkg/totp"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	key, err := token.GenerateEdDSAKey("test")
+	if err != nil {
+		panic(err)
+	}
+	if token.Keys, err = token.NewKeySet(key); err != nil {
+		panic(err)
+	}
+	m.Run()
+}
+
+// testServer serves the routes under test from a memory store
+type testServer struct {
+	*Server
+	store  *memory.Store
+	router *mux.Router
+}
+
+func newTestServer(onCarDelete db.CarDeletePolicy) *testServer {
+	store := memory.New(onCarDelete)
+	srv := NewServer(store)
+
+	r := mux.NewRouter()
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(srv.Authenticate)
+	api.Handle("/cars", RequireScope(model.ScopeCarsWrite, RequireRole(model.RoleEditor, srv.CreateCar))).Methods("POST")
+	api.Handle("/cars/{id}", RequireScope(model.ScopeCarsRead, srv.GetCar)).Methods("GET")
+	api.Handle("/cars/{id}", RequireScope(model.ScopeCarsWrite, RequireRole(model.RoleEditor, srv.DeleteCar))).Methods("DELETE")
+	api.Handle("/cars/{id}/ratings", RequireScope(model.ScopeRatingsRead, srv.GetRating)).Methods("GET")
+	api.Handle("/ratings", RequireScope(model.ScopeRatingsWrite, srv.CreateRating)).Methods("POST")
+	api.HandleFunc("/cars/{id}/grants", srv.GetCarGrants).Methods("GET")
+	api.HandleFunc("/user/2fa/confirm", srv.ConfirmTOTP).Methods("POST")
+	r.Handle("/user/me", srv.Authenticate(http.HandlerFunc(srv.GetMe))).Methods("GET")
+	r.Handle("/user/logout/all", srv.Authenticate(http.HandlerFunc(srv.LogoutAll))).Methods("POST")
+	r.HandleFunc("/user/password/forgot", srv.ForgotPassword).Methods("POST")
+	r.HandleFunc("/user/login", srv.LoginUser).Methods("POST")
+	r.HandleFunc("/user/oidc/login", srv.OIDCLogin).Methods("GET")
+	r.HandleFunc("/user/oidc/callback", srv.OIDCCallback).Methods("GET")
+	r.HandleFunc("/user/token/refresh", srv.RefreshToken).Methods("POST")
+	r.Handle("/user/me/password", srv.Authenticate(http.HandlerFunc(srv.ChangePassword))).Methods("PUT")
+
+	return &testServer{Server: srv, store: store, router: r}
+}
+
+// addUser creates a verified user with the given role, in an organization
+// of their own if org is set, and returns them
+func (ts *testServer) addUser(t *testing.T, username string, role model.Role, org bool) *model.User {
+	t.Helper()
+	ctx := context.Background()
+	if err := ts.store.CreateUser(ctx, model.User{Username: username, Password: "x", Role: role, Verified: true}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := ts.store.GetUserByUsername(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if org {
+		if _, err := ts.store.CreateOrganization(ctx, username+"'s", user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if user, err = ts.store.GetUserByID(ctx, user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+func accessToken(t *testing.T, user *model.User) string {
+	t.Helper()
+	tok, err := token.NewAccessToken(user, "session", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+// do sends a request as the holder of tok, if any, and returns the response
+func (ts *testServer) do(method, path, tok, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if tok != "" {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *testServer) expect(t *testing.T, method, path, tok, body string, status int) *httptest.ResponseRecorder {
+	t.Helper()
+	w := ts.do(method, path, tok, body)
+	if w.Code != status {
+		t.Fatalf("%s %s = %d %q, want %d", method, path, w.Code, w.Body.String(), status)
+	}
+	return w
+}
+
+// addCar creates a car through the API as user and returns its path
+func (ts *testServer) addCar(t *testing.T, user *model.User) string {
+	t.Helper()
+	ts.expect(t, "POST", "/api/cars", accessToken(t, user), `{"brand":"Kia","model":"Rio","year":2020,"color":"red"}`, http.StatusCreated)
+	cars, err := ts.store.GetAllCars(context.Background(), user.ActiveOrgID)
+	if err != nil || len(cars) == 0 {
+		t.Fatalf("car was not created: %v", err)
+	}
+	return "/api/cars/" + strconv.Itoa(cars[len(cars)-1].ID)
+}
+
+func TestAuthenticate(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	user := ts.addUser(t, "a@example.com", model.RoleViewer, false)
+	tok := accessToken(t, user)
+
+	ts.expect(t, "GET", "/user/me", "", "", http.StatusUnauthorized)
+	ts.expect(t, "GET", "/user/me", "not-a-token", "", http.StatusUnauthorized)
+	ts.expect(t, "GET", "/user/me", tok, "", http.StatusOK)
+
+	// Tokens for other purposes are signed with the same keys but refused
+	challenge, err := token.NewMFAChallengeToken(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.expect(t, "GET", "/user/me", challenge, "", http.StatusUnauthorized)
+
+	// Flags set by an admin apply to tokens already issued
+	ctx := context.Background()
+	if _, err := ts.store.RequirePasswordReset(ctx, 0, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	ts.expect(t, "GET", "/user/me", tok, "", http.StatusForbidden)
+
+	other := ts.addUser(t, "b@example.com", model.RoleViewer, false)
+	otherTok := accessToken(t, other)
+	if _, err := ts.store.SetUserDisabled(ctx, 0, other.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	ts.expect(t, "GET", "/user/me", otherTok, "", http.StatusForbidden)
+	if _, err := ts.store.SetUserDisabled(ctx, 0, other.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	ts.expect(t, "GET", "/user/me", otherTok, "", http.StatusOK)
+
+	// Logging out everywhere revokes the token it was made with
+	ts.expect(t, "POST", "/user/logout/all", otherTok, "", http.StatusNoContent)
+	ts.expect(t, "GET", "/user/me", otherTok, "", http.StatusUnauthorized)
+}
+
+func TestAuthenticateUsesCurrentRole(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	user := ts.addUser(t, "a@example.com", model.RoleEditor, true)
+	tok := accessToken(t, user)
+	car := `{"brand":"Kia","model":"Rio","year":2020,"color":"red"}`
+	ts.expect(t, "POST", "/api/cars", tok, car, http.StatusCreated)
+
+	// A demotion applies to the token already issued, whatever it claims
+	ctx := context.Background()
+	if _, err := ts.store.SetUserRole(ctx, 0, user.ID, model.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	ts.expect(t, "POST", "/api/cars", tok, car, http.StatusForbidden)
+
+	// And so does a promotion
+	viewer := ts.addUser(t, "b@example.com", model.RoleViewer, true)
+	viewerTok := accessToken(t, viewer)
+	if _, err := ts.store.SetUserRole(ctx, 0, viewer.ID, model.RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	ts.expect(t, "POST", "/api/cars", viewerTok, car, http.StatusCreated)
+}
+
+func TestAuthenticateScopesToMembership(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	owner := ts.addUser(t, "owner@example.com", model.RoleEditor, true)
+	outsider := ts.addUser(t, "outsider@example.com", model.RoleEditor, true)
+	car := ts.addCar(t, owner)
+
+	// A token naming an organization the user isn't a member of acts in
+	// the one they are active in
+	claimed := *outsider
+	claimed.ActiveOrgID = owner.ActiveOrgID
+	ts.expect(t, "GET", car, accessToken(t, &claimed), "", http.StatusNotFound)
+
+	// Or, with none, in no organization at all
+	loner := ts.addUser(t, "loner@example.com", model.RoleEditor, false)
+	claimed = *loner
+	claimed.ActiveOrgID = owner.ActiveOrgID
+	ts.expect(t, "GET", car, accessToken(t, &claimed), "", http.StatusNotFound)
+}
+
+func TestCarAccess(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	owner := ts.addUser(t, "owner@example.com", model.RoleEditor, true)
+	outsider := ts.addUser(t, "outsider@example.com", model.RoleEditor, true)
+	car := ts.addCar(t, owner)
+
+	ts.expect(t, "GET", car, accessToken(t, owner), "", http.StatusOK)
+	ts.expect(t, "GET", car+"/ratings", accessToken(t, owner), "", http.StatusOK)
+
+	// Cars of other organizations don't exist for the caller
+	ts.expect(t, "GET", car, accessToken(t, outsider), "", http.StatusNotFound)
+	ts.expect(t, "GET", car+"/ratings", accessToken(t, outsider), "", http.StatusNotFound)
+	ts.expect(t, "DELETE", car, accessToken(t, outsider), "", http.StatusNotFound)
+
+	// Until the car is shared with them
+	carID, _ := strconv.Atoi(strings.TrimPrefix(car, "/api/cars/"))
+	grant := model.CarGrant{CarID: carID, UserID: outsider.ID, Role: model.RoleViewer, GrantedBy: owner.ID}
+	if _, err := ts.store.SaveCarGrant(context.Background(), grant); err != nil {
+		t.Fatal(err)
+	}
+	ts.expect(t, "GET", car, accessToken(t, outsider), "", http.StatusOK)
+	ts.expect(t, "GET", car+"/ratings", accessToken(t, outsider), "", http.StatusOK)
+	ts.expect(t, "DELETE", car, accessToken(t, outsider), "", http.StatusNotFound)
+
+	// Viewers can't create cars at all
+	viewer := ts.addUser(t, "viewer@example.com", model.RoleViewer, true)
+	ts.expect(t, "POST", "/api/cars", accessToken(t, viewer), `{"brand":"Kia","model":"Rio","year":2020,"color":"red"}`, http.StatusForbidden)
+}
+
+func TestRatingConstraints(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	user := ts.addUser(t, "a@example.com", model.RoleEditor, true)
+	tok := accessToken(t, user)
+	car := ts.addCar(t, user)
+	carID := strings.TrimPrefix(car, "/api/cars/")
+
+	ts.expect(t, "POST", "/api/ratings", tok, `{"car_id":`+carID+`,"stars":6}`, http.StatusUnprocessableEntity)
+	ts.expect(t, "POST", "/api/ratings", tok, `{"car_id":`+carID+`,"stars":4}`, http.StatusCreated)
+	w := ts.expect(t, "POST", "/api/ratings", tok, `{"car_id":`+carID+`,"stars":5}`, http.StatusConflict)
+	if !strings.Contains(w.Body.String(), "already rated") {
+		t.Errorf("duplicate rating reported as %q", w.Body.String())
+	}
+	ts.expect(t, "POST", "/api/ratings", tok, `{"car_id":999999,"stars":4}`, http.StatusBadRequest)
+
+	w = ts.expect(t, "GET", car+"/ratings", tok, "", http.StatusOK)
+	if !strings.Contains(w.Body.String(), `"stars":4`) {
+		t.Errorf("ratings = %s, want the one with 4 stars", w.Body.String())
+	}
+}
+
+func TestDeleteCar(t *testing.T) {
+	tests := []struct {
+		name        string
+		onCarDelete db.CarDeletePolicy
+		status      int
+	}{
+		{"cascade", db.CarDeleteCascade, http.StatusNoContent},
+		{"restrict", db.CarDeleteRestrict, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(tt.onCarDelete)
+			user := ts.addUser(t, "a@example.com", model.RoleEditor, true)
+			tok := accessToken(t, user)
+			car := ts.addCar(t, user)
+
+			// A car nothing refers to can always be deleted
+			ts.expect(t, "DELETE", car, tok, "", http.StatusNoContent)
+			ts.expect(t, "GET", car, tok, "", http.StatusNotFound)
+
+			car = ts.addCar(t, user)
+			ts.expect(t, "POST", "/api/ratings", tok, `{"car_id":`+strings.TrimPrefix(car, "/api/cars/")+`,"stars":4}`, http.StatusCreated)
+			ts.expect(t, "DELETE", car, tok, "", tt.status)
+		})
+	}
+}
+
+func TestForgotPasswordIsRateLimited(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	ts.addUser(t, "a@example.com", model.RoleViewer, false)
+
+	for i := 0; i < 4; i++ {
+		ts.expect(t, "POST", "/user/password/forgot", "", `{"username":"a@example.com"}`, http.StatusAccepted)
+	}
+	w := ts.expect(t, "POST", "/user/password/forgot", "", `{"username":"a@example.com"}`, http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	// Other accounts are limited separately, up to the client's limit
+	ts.expect(t, "POST", "/user/password/forgot", "", `{"username":"b@example.com"}`, http.StatusAccepted)
+}
+
+func TestConfirmTOTPRefusesReplayedCode(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	user := ts.addUser(t, "a@example.com", model.RoleViewer, false)
+	tok := accessToken(t, user)
+	ctx := context.Background()
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.SetTOTPSecret(ctx, user.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	// The code of a step already used, as if seen over the user's shoulder
+	step := totp.Step(time.Now())
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.store.UseTOTPStep(ctx, user.ID, step); err != nil {
+		t.Fatal(err)
+	}
+	ts.expect(t, "POST", "/api/user/2fa/confirm", tok, `{"code":"`+code+`"}`, http.StatusUnauthorized)
+	if user, _ := ts.store.GetUserByID(ctx, user.ID); user.TOTPEnabled {
+		t.Error("two-factor authentication was enabled with a replayed code")
+	}
+
+	// The next code enables it, once
+	code, err = totp.Code(secret, step+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.expect(t, "POST", "/api/user/2fa/confirm", tok, `{"code":"`+code+`"}`, http.StatusOK)
+	ts.expect(t, "POST", "/api/user/2fa/confirm", tok, `{"code":"`+code+`"}`, http.StatusConflict)
+}
+
+func TestLoginRehashesLegacyPasswords(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	user := ts.addUser(t, "a@example.com", model.RoleViewer, false)
+	ctx := context.Background()
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.UpdateUserPassword(ctx, user.ID, string(legacy)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed login leaves the hash alone
+	ts.expect(t, "POST", "/user/login", "", `{"username":"a@example.com","password":"wrong horse"}`, http.StatusUnauthorized)
+	if stored, _ := ts.store.GetUserByID(ctx, user.ID); stored.Password != string(legacy) {
+		t.Fatal("a failed login replaced the password hash")
+	}
+
+	ts.expect(t, "POST", "/user/login", "", `{"username":"a@example.com","password":"correct horse"}`, http.StatusOK)
+	stored, err := ts.store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.NeedsRehash() {
+		t.Errorf("password hash after login = %q, want it rehashed with argon2id", stored.Password)
+	}
+	if ok, _ := stored.Authenticate("correct horse"); !ok {
+		t.Error("the rehashed password doesn't verify")
+	}
+	ts.expect(t, "POST", "/user/login", "", `{"username":"a@example.com","password":"correct horse"}`, http.StatusOK)
+}
+
+// tokens decodes the tokens of a login or refresh response
+func tokens(t *testing.T, w *httptest.ResponseRecorder) tokenResponse {
+	t.Helper()
+	var resp tokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.RefreshToken == "" {
+		t.Fatalf("no tokens in %q: %v", w.Body.String(), err)
+	}
+	return resp
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	user := ts.addUser(t, "a@example.com", model.RoleViewer, false)
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.UpdateUserPassword(context.Background(), user.ID, string(hash)); err != nil {
+		t.Fatal(err)
+	}
+	login := tokens(t, ts.expect(t, "POST", "/user/login", "", `{"username":"a@example.com","password":"correct horse"}`, http.StatusOK))
+	other := tokens(t, ts.expect(t, "POST", "/user/login", "", `{"username":"a@example.com","password":"correct horse"}`, http.StatusOK))
+
+	refreshed := tokens(t, ts.expect(t, "POST", "/user/token/refresh", "", `{"refresh_token":"`+login.RefreshToken+`"}`, http.StatusOK))
+	if refreshed.RefreshToken == login.RefreshToken {
+		t.Fatal("refresh returned the same refresh token")
+	}
+	ts.expect(t, "GET", "/user/me", refreshed.AccessToken, "", http.StatusOK)
+
+	// Replaying the rotated token revokes the whole family, so whoever holds
+	// the newer token is signed out too
+	ts.expect(t, "POST", "/user/token/refresh", "", `{"refresh_token":"`+login.RefreshToken+`"}`, http.StatusUnauthorized)
+	ts.expect(t, "POST", "/user/token/refresh", "", `{"refresh_token":"`+refreshed.RefreshToken+`"}`, http.StatusUnauthorized)
+
+	// The user's other sessions are left alone
+	ts.expect(t, "POST", "/user/token/refresh", "", `{"refresh_token":"`+other.RefreshToken+`"}`, http.StatusOK)
+}
+
+func TestChangePasswordSignsOutOtherSessions(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	user := ts.addUser(t, "a@example.com", model.RoleViewer, false)
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.UpdateUserPassword(context.Background(), user.ID, string(hash)); err != nil {
+		t.Fatal(err)
+	}
+	login := `{"username":"a@example.com","password":"correct horse"}`
+	here := tokens(t, ts.expect(t, "POST", "/user/login", "", login, http.StatusOK))
+	elsewhere := tokens(t, ts.expect(t, "POST", "/user/login", "", login, http.StatusOK))
+
+	w := ts.expect(t, "PUT", "/user/me/password", here.AccessToken, `{"old_password":"correct horse","new_password":"battery staple 42"}`, http.StatusOK)
+	var resp tokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.AccessToken == "" {
+		t.Fatalf("no access token in %q: %v", w.Body.String(), err)
+	}
+
+	// Every access token issued before the change stops working
+	ts.expect(t, "GET", "/user/me", here.AccessToken, "", http.StatusUnauthorized)
+	ts.expect(t, "GET", "/user/me", elsewhere.AccessToken, "", http.StatusUnauthorized)
+	ts.expect(t, "GET", "/user/me", resp.AccessToken, "", http.StatusOK)
+
+	// The session that changed the password goes on, the other one ends
+	ts.expect(t, "POST", "/user/token/refresh", "", `{"refresh_token":"`+elsewhere.RefreshToken+`"}`, http.StatusUnauthorized)
+	ts.expect(t, "POST", "/user/token/refresh", "", `{"refresh_token":"`+here.RefreshToken+`"}`, http.StatusOK)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	owner := ts.addUser(t, "a@example.com", model.RoleEditor, true)
+	car := ts.addCar(t, owner)
+	secret, _, err := token.NewOpaqueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := apiKeyPrefix + secret
+	_, err = ts.store.CreateAPIKey(context.Background(), model.APIKey{
+		UserID: owner.ID, OrgID: owner.ActiveOrgID, Name: "reader", KeyHash: token.HashOpaqueToken(key), Scopes: []string{model.ScopeCarsRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", car, "", http.StatusOK},
+		{"POST", "/api/cars", `{"brand":"Kia","model":"Ceed","year":2021,"color":"blue"}`, http.StatusForbidden},
+		{"DELETE", car, "", http.StatusForbidden},
+		{"GET", car + "/ratings", "", http.StatusForbidden},
+		// Routes registered without a scope are closed to API keys
+		{"GET", car + "/grants", "", http.StatusForbidden},
+		{"GET", "/user/me", "", http.StatusForbidden},
+	} {
+		if w := ts.do(tt.method, tt.path, key, tt.body); w.Code != tt.status {
+			t.Errorf("%s %s with a cars:read key = %d %q, want %d", tt.method, tt.path, w.Code, w.Body.String(), tt.status)
+		}
+	}
+
+	// Sessions are not limited by scopes
+	tok := accessToken(t, owner)
+	ts.expect(t, "GET", car+"/ratings", tok, "", http.StatusOK)
+	ts.expect(t, "GET", car+"/grants", tok, "", http.StatusOK)
+}
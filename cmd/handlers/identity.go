@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"car_project/pkg/model"
+)
+
+// Identity is the authenticated caller of an /api request
+type Identity struct {
+	UserID   int
+	Username string
+	Role     model.Role
+	MFA      bool // Whether the session was opened with a second factor
+	OrgID    int  // Organization whose data the caller works on; 0 if none
+
+	TokenID   string    // jti of the access token
+	SessionID string    // Refresh token family the access token belongs to
+	ExpiresAt time.Time // Expiry of the access token
+
+	APIKeyID int      // Set when the caller authenticated with an API key
+	Scopes   []string // Scopes of the API key; nil means unrestricted
+}
+
+// HasScope reports whether the caller may use the given scope. Callers
+// with a JWT session are not limited by scopes.
+func (id Identity) HasScope(scope string) bool {
+	if id.Scopes == nil {
+		return true
+	}
+	for _, s := range id.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey string
+
+const identityKey contextKey = "identity"
+
+// IdentityFromContext returns the caller that Authenticate stored in the
+// request context. ok is false for requests that did not pass Authenticate.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey).(Identity)
+	return id, ok
+}
+
+// WithIdentity returns a copy of ctx carrying the given caller
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey, id)
+}
+
+// ratingOwner returns the user a rating request acts as. That is always the
+// caller, except that admins may name another user explicitly. A requested
+// ID of 0 means "the caller". ok is false if the caller may not act as requested.
+func ratingOwner(r *http.Request, requested int) (userID int, ok bool) {
+	id, ok := IdentityFromContext(r.Context())
+	if !ok {
+		return 0, false
+	}
+	if requested == 0 || requested == id.UserID {
+		return id.UserID, true
+	}
+	if id.Role != model.RoleAdmin {
+		return 0, false
+	}
+	return requested, true
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"car_project/pkg/lockout"
+	"car_project/pkg/password"
+
+	"github.com/gorilla/mux"
+)
+
+// dummyPasswordHash is compared against when a login names an unknown user
+var dummyPasswordHash, _ = password.Hash("not a real password")
+
+// clientIP returns the address of the peer that sent the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginLocked refuses the request with 429 while any of the keys is
+// backing off, and reports whether it did
+func (s *Server) loginLocked(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	blockedUntil, err := s.Logins.LoginBlockedUntil(r.Context(), keys...)
+	if err != nil {
+		dbError(w, r, err, "Error checking login attempts")
+		return true
+	}
+	if wait := time.Until(blockedUntil); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+		return true
+	}
+	return false
+}
+
+// recordLoginFailure counts a failed login against key and, once the policy
+// asks for it, refuses further attempts for a while
+func (s *Server) recordLoginFailure(ctx context.Context, key string, policy lockout.Policy) {
+	if _, _, err := s.Logins.RecordLoginFailure(ctx, key, policy.Window, policy.Backoff); err != nil {
+		log.Printf("could not record login failure for %s: %v", key, err)
+	}
+}
+
+// GetLockouts lists accounts and client IPs with recent failed logins
+func (s *Server) GetLockouts(w http.ResponseWriter, r *http.Request) {
+	failures, err := s.Logins.GetLoginFailures(r.Context())
+	if err != nil {
+		dbError(w, r, err, "Error fetching login failures")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(failures)
+}
+
+// ClearLockout forgets the failed logins of one record, lifting its lock
+func (s *Server) ClearLockout(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid lockout ID", http.StatusBadRequest)
+		return
+	}
+
+	found, err := s.Logins.ClearLoginFailuresByID(r.Context(), id)
+	if err != nil {
+		dbError(w, r, err, "Error clearing lockout")
+		return
+	}
+	if !found {
+		http.Error(w, "Lockout not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
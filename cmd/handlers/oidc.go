@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"car_project/pkg/db"
+	"car_project/pkg/model"
+	"car_project/pkg/oidc"
+	"car_project/pkg/password"
+)
+
+// oidcLoginTTL is how long a user has to complete login at the provider
+const oidcLoginTTL = 10 * time.Minute
+
+// OIDCLogin starts single sign-on by redirecting to the identity provider
+// with an authorization code request protected by state, nonce and PKCE
+func (s *Server) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, ok := s.startOIDCLogin(w, r, 0)
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// LinkOIDCIdentity starts linking the identity provider to the caller's
+// account. It returns the URL to send the browser to; the callback then
+// links the provider subject instead of logging in. Accounts that could
+// not be linked automatically at first SSO login are linked this way.
+func (s *Server) LinkOIDCIdentity(w http.ResponseWriter, r *http.Request) {
+	id, ok := IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
+	authURL, ok := s.startOIDCLogin(w, r, id.UserID)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"auth_url": authURL})
+}
+
+// startOIDCLogin records a new authorization request and returns the
+// provider URL for it, writing an error if that fails
+func (s *Server) startOIDCLogin(w http.ResponseWriter, r *http.Request, linkUserID int) (string, bool) {
+	if oidc.Default == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return "", false
+	}
+
+	state, err := oidc.RandomString(24)
+	if err != nil {
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		return "", false
+	}
+	nonce, err := oidc.RandomString(24)
+	if err != nil {
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		return "", false
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		return "", false
+	}
+
+	authURL, err := oidc.Default.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		log.Printf("could not reach identity provider: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return "", false
+	}
+	if err := s.Identities.CreateOIDCLogin(r.Context(), state, nonce, verifier, linkUserID, time.Now().Add(oidcLoginTTL)); err != nil {
+		dbError(w, r, err, "Error starting login")
+		return "", false
+	}
+	return authURL, true
+}
+
+// OIDCCallback finishes single sign-on: it redeems the code, maps the
+// provider subject to a local user, provisioning one on first login, and
+// responds with the project's own tokens like LoginUser does
+func (s *Server) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if oidc.Default == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	if errCode := q.Get("error"); errCode != "" {
+		http.Error(w, "Identity provider refused the login: "+errCode, http.StatusUnauthorized)
+		return
+	}
+
+	nonce, verifier, linkUserID, err := s.Identities.ConsumeOIDCLogin(r.Context(), q.Get("state"))
+	if err != nil {
+		if errors.Is(err, db.ErrOIDCLoginUnusable) {
+			http.Error(w, "Login request is invalid or expired, please start again", http.StatusBadRequest)
+			return
+		}
+		dbError(w, r, err, "Error finishing login")
+		return
+	}
+
+	claims, err := oidc.Default.Exchange(r.Context(), q.Get("code"), verifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		http.Error(w, "Could not verify login with the identity provider", http.StatusUnauthorized)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		http.Error(w, "Could not verify login with the identity provider", http.StatusUnauthorized)
+		return
+	}
+
+	if linkUserID != 0 {
+		s.linkOIDCIdentity(w, r, linkUserID, claims)
+		return
+	}
+
+	user, err := s.findOrProvisionUser(r.Context(), claims)
+	if err != nil {
+		if errors.Is(err, errIdentityConflict) {
+			http.Error(w, "An account with this email already exists, log in with its password and link your identity provider from there", http.StatusConflict)
+			return
+		}
+		dbError(w, r, err, "Error provisioning user")
+		return
+	}
+
+	if !accountUsable(w, user) {
+		return
+	}
+
+	// A provider login without a second factor does not replace the user's own
+	mfa := hasMFA(claims.AMR)
+	if user.TOTPEnabled && !mfa {
+		writeMFAChallenge(w, user)
+		return
+	}
+	s.issueTokens(w, r, user, mfa)
+}
+
+// linkOIDCIdentity finishes a LinkOIDCIdentity request by linking the
+// provider subject to the user who started it
+func (s *Server) linkOIDCIdentity(w http.ResponseWriter, r *http.Request, userID int, claims *oidc.Claims) {
+	linked, err := s.Identities.GetUserByIdentity(r.Context(), claims.Issuer, claims.Subject)
+	if err == nil {
+		if linked.ID == userID {
+			w.Write([]byte("Identity provider already linked to your account"))
+			return
+		}
+		http.Error(w, "This identity is already linked to another account", http.StatusConflict)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		dbError(w, r, err, "Error reading user")
+		return
+	}
+
+	if err := s.Identities.LinkIdentity(r.Context(), userID, claims.Issuer, claims.Subject, claims.Email); err != nil {
+		dbError(w, r, err, "Error linking identity")
+		return
+	}
+	w.Write([]byte("Identity provider linked to your account"))
+}
+
+var errIdentityConflict = errors.New("username taken by an account that can't be linked automatically")
+
+// findOrProvisionUser returns the local user for a provider subject. A new
+// subject is linked to the local account with the same email only if the
+// provider vouches for the address, the account's own address is verified
+// and it has no second factor the provider could bypass. Other accounts
+// must be linked with LinkOIDCIdentity after a local login. Without a local
+// account a new one is created.
+func (s *Server) findOrProvisionUser(ctx context.Context, claims *oidc.Claims) (*model.User, error) {
+	user, err := s.Identities.GetUserByIdentity(ctx, claims.Issuer, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	username := claims.Email
+	if username == "" {
+		username = claims.Subject + "@" + claims.Issuer
+	}
+
+	existing, err := s.Users.GetUserByUsername(ctx, username)
+	if err == nil {
+		if claims.Email == "" || !claims.EmailVerified || !existing.Verified || existing.TOTPEnabled {
+			return nil, errIdentityConflict
+		}
+		if err := s.Identities.LinkIdentity(ctx, existing.ID, claims.Issuer, claims.Subject, claims.Email); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// SSO users have no password of their own until they reset one
+	random, err := oidc.RandomString(32)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := password.Hash(random)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Identities.CreateUserWithIdentity(ctx, model.User{
+		Username:    username,
+		Password:    hashedPassword,
+		Role:        model.RoleViewer,
+		Verified:    true,
+		DisplayName: claims.Name,
+		Email:       claims.Email,
+	}, claims.Issuer, claims.Subject, claims.Email)
+}
+
+// hasMFA reports whether the provider says the user presented a second factor
+func hasMFA(amr []string) bool {
+	for _, m := range amr {
+		if m == "mfa" || m == "otp" || m == "hwk" {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCRedirectURL is the callback URL registered at the provider by default
+func OIDCRedirectURL() string {
+	return PublicURL + "/user/oidc/callback"
+}
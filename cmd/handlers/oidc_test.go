@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"car_project/pkg/db"
+	"car_project/pkg/model"
+	"car_project/pkg/oidc"
+	"car_project/pkg/oidc/mock"
+)
+
+// useMockProvider points single sign-on at a mock provider that logs
+// everyone in as user, for the duration of the test
+func useMockProvider(t *testing.T, user mock.User) *mock.Provider {
+	t.Helper()
+	var provider *mock.Provider
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	var err error
+	if provider, err = mock.New(server.URL, "cars", "secret", user); err != nil {
+		t.Fatal(err)
+	}
+	previous := oidc.Default
+	oidc.Default = oidc.New(oidc.Config{
+		Issuer:       server.URL,
+		ClientID:     "cars",
+		ClientSecret: "secret",
+		RedirectURL:  "http://cars.test/user/oidc/callback",
+	})
+	t.Cleanup(func() { oidc.Default = previous })
+	return provider
+}
+
+// authorize starts a login at the test server and has the provider approve
+// it, returning the query the provider redirects back with. edit may change
+// the authorization request on its way to the provider.
+func (ts *testServer) authorize(t *testing.T, edit func(url.Values)) url.Values {
+	t.Helper()
+	w := ts.expect(t, "GET", "/user/oidc/login", "", "", http.StatusFound)
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := authURL.Query()
+	for _, param := range []string{"state", "nonce", "code_challenge"} {
+		if q.Get(param) == "" {
+			t.Fatalf("authorization request %s has no %s", authURL, param)
+		}
+	}
+	if edit != nil {
+		edit(q)
+		authURL.RawQuery = q.Encode()
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || err != nil {
+		t.Fatalf("provider answered %d, redirecting to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	return callback.Query()
+}
+
+func callbackPath(state, code string) string {
+	return "/user/oidc/callback?" + url.Values{"state": {state}, "code": {code}}.Encode()
+}
+
+func TestOIDCLogin(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	provider := useMockProvider(t, mock.User{Subject: "sub-1", Email: "new@example.com", EmailVerified: true, Name: "New"})
+
+	cb := ts.authorize(t, nil)
+	resp := tokens(t, ts.expect(t, "GET", callbackPath(cb.Get("state"), cb.Get("code")), "", "", http.StatusOK))
+	ts.expect(t, "GET", "/user/me", resp.AccessToken, "", http.StatusOK)
+
+	// The new account is provisioned once and found by its subject after
+	user, err := ts.store.GetUserByIdentity(context.Background(), provider.Issuer, "sub-1")
+	if err != nil || user.Username != "new@example.com" || user.Role != model.RoleViewer {
+		t.Fatalf("provisioned user = %+v, %v", user, err)
+	}
+	cb = ts.authorize(t, nil)
+	ts.expect(t, "GET", callbackPath(cb.Get("state"), cb.Get("code")), "", "", http.StatusOK)
+	if again, _ := ts.store.GetUserByIdentity(context.Background(), provider.Issuer, "sub-1"); again.ID != user.ID {
+		t.Errorf("second login mapped to user %d, want %d", again.ID, user.ID)
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	useMockProvider(t, mock.User{Subject: "sub-1", Email: "new@example.com", EmailVerified: true})
+
+	// A state that was never issued
+	cb := ts.authorize(t, nil)
+	ts.expect(t, "GET", callbackPath("forged", cb.Get("code")), "", "", http.StatusBadRequest)
+
+	// States are single-use
+	ts.expect(t, "GET", callbackPath(cb.Get("state"), cb.Get("code")), "", "", http.StatusOK)
+	ts.expect(t, "GET", callbackPath(cb.Get("state"), cb.Get("code")), "", "", http.StatusBadRequest)
+}
+
+func TestOIDCCallbackChecksPKCE(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	useMockProvider(t, mock.User{Subject: "sub-1", Email: "new@example.com", EmailVerified: true})
+
+	// A code issued for another login, as an attacker would inject their own,
+	// fails because its challenge isn't the one of this login's verifier
+	victim := ts.authorize(t, nil)
+	attacker := ts.authorize(t, nil)
+	ts.expect(t, "GET", callbackPath(victim.Get("state"), attacker.Get("code")), "", "", http.StatusUnauthorized)
+}
+
+func TestOIDCCallbackChecksNonce(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	useMockProvider(t, mock.User{Subject: "sub-1", Email: "new@example.com", EmailVerified: true})
+
+	cb := ts.authorize(t, func(q url.Values) { q.Set("nonce", "forged") })
+	ts.expect(t, "GET", callbackPath(cb.Get("state"), cb.Get("code")), "", "", http.StatusUnauthorized)
+	if _, err := ts.store.GetUserByUsername(context.Background(), "new@example.com"); err == nil {
+		t.Error("a login with the wrong nonce provisioned a user")
+	}
+}
+
+func TestOIDCLinksAccountsByVerifiedEmail(t *testing.T) {
+	ts := newTestServer(db.CarDeleteCascade)
+	provider := useMockProvider(t, mock.User{Subject: "sub-1", Email: "a@example.com"})
+	existing := ts.addUser(t, "a@example.com", model.RoleEditor, false)
+
+	// Without the provider vouching for the address, the account is left
+	// for its owner to link
+	cb := ts.authorize(t, nil)
+	ts.expect(t, "GET", callbackPath(cb.Get("state"), cb.Get("code")), "", "", http.StatusConflict)
+	if _, err := ts.store.GetUserByIdentity(context.Background(), provider.Issuer, "sub-1"); err == nil {
+		t.Fatal("an unverified email was linked")
+	}
+
+	provider.User.EmailVerified = true
+	cb = ts.authorize(t, nil)
+	resp := tokens(t, ts.expect(t, "GET", callbackPath(cb.Get("state"), cb.Get("code")), "", "", http.StatusOK))
+	user, err := ts.store.GetUserByIdentity(context.Background(), provider.Issuer, "sub-1")
+	if err != nil || user.ID != existing.ID {
+		t.Fatalf("identity linked to %+v, %v, want user %d", user, err, existing.ID)
+	}
+	ts.expect(t, "GET", "/user/me", resp.AccessToken, "", http.StatusOK)
+}
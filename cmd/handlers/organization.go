@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"car_project/pkg/db"
+	mailer "car_project/pkg/mail"
+	"car_project/pkg/model"
+	"car_project/pkg/token"
+
+	"github.com/gorilla/mux"
+)
+
+const noOrganization = "No active organization: create one or accept an invitation first"
+
+// tenant returns the organization whose data the caller works on. Every
+// query on cars, history and ratings is scoped to it, so rows of other
+// organizations look like they don't exist.
+func tenant(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, _ := IdentityFromContext(r.Context())
+	if id.OrgID == 0 {
+		http.Error(w, noOrganization, http.StatusForbidden)
+		return 0, false
+	}
+	return id.OrgID, true
+}
+
+// CreateOrganization creates an organization owned by the caller. It becomes
+// the caller's active organization if they had none; refresh the access
+// token to start using it.
+func (s *Server) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	id, _ := IdentityFromContext(r.Context())
+	org, err := s.Orgs.CreateOrganization(r.Context(), req.Name, id.UserID)
+	if err != nil {
+		dbError(w, r, err, "Error creating organization")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(org)
+}
+
+// GetOrganizations lists the organizations the caller is a member of
+func (s *Server) GetOrganizations(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFromContext(r.Context())
+	orgs, err := s.Orgs.GetUserOrganizations(r.Context(), id.UserID)
+	if err != nil {
+		dbError(w, r, err, "Error fetching organizations")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orgs)
+}
+
+// SwitchOrganization makes another of the caller's organizations the active
+// one. Tokens carry the organization, so the current session is replaced
+// with a new one.
+func (s *Server) SwitchOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid organization ID", http.StatusBadRequest)
+		return
+	}
+
+	id, _ := IdentityFromContext(r.Context())
+	found, err := s.Orgs.SetActiveOrganization(r.Context(), id.UserID, orgID)
+	if err != nil {
+		dbError(w, r, err, "Error switching organization")
+		return
+	}
+	if !found {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+
+	user, err := s.Users.GetUserByID(r.Context(), id.UserID)
+	if err != nil {
+		dbError(w, r, err, "Error reading user")
+		return
+	}
+	if err := s.endSession(r.Context(), id); err != nil {
+		dbError(w, r, err, "Error revoking session")
+		return
+	}
+
+	s.issueTokens(w, r, user, id.MFA)
+}
+
+// InviteToOrganization mails an invitation to join the organization. Only
+// its owners may invite.
+func (s *Server) InviteToOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid organization ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	address, err := mail.ParseAddress(req.Email)
+	if err != nil || address.Address != req.Email {
+		http.Error(w, "Email must be a valid email address", http.StatusBadRequest)
+		return
+	}
+
+	id, _ := IdentityFromContext(r.Context())
+	role, err := s.Orgs.GetOrgRole(r.Context(), orgID, id.UserID)
+	if err != nil {
+		dbError(w, r, err, "Error checking membership")
+		return
+	}
+	switch role {
+	case "":
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	case model.OrgRoleMember:
+		http.Error(w, "Forbidden: only owners may invite to an organization", http.StatusForbidden)
+		return
+	}
+
+	plain, hash, err := token.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, "Error generating invitation", http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(token.OrgInvitationTTL)
+	_, err = s.Orgs.CreateOrgInvitation(r.Context(), model.OrgInvitation{
+		OrgID:     orgID,
+		Email:     req.Email,
+		TokenHash: hash,
+		InvitedBy: id.UserID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		dbError(w, r, err, "Error storing invitation")
+		return
+	}
+
+	err = mailer.Send(mailer.Message{
+		To:      req.Email,
+		Subject: "You have been invited to an organization",
+		Body: fmt.Sprintf("%s invited you to join their organization. Sign in with this address and send the code below to %s/api/orgs/invitations/accept before %s.\n\n%s\n",
+			id.Username, PublicURL, expiresAt.Format("2006-01-02 15:04 MST"), plain),
+	})
+	if err != nil {
+		log.Printf("could not send invitation to organization %d: %v", orgID, err)
+		http.Error(w, "Invitation created, but the email could not be sent", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// AcceptInvitation makes the caller a member of the organization they were
+// invited to. The invitation must be addressed to the caller's email.
+func (s *Server) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invitation token not provided", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	orgID, err := s.Orgs.AcceptOrgInvitation(r.Context(), token.HashOpaqueToken(req.Token), user.ID, user.ContactEmail())
+	if err != nil {
+		if errors.Is(err, db.ErrInvitationUnusable) {
+			http.Error(w, "Invitation is invalid, expired, already used or meant for another address", http.StatusBadRequest)
+			return
+		}
+		dbError(w, r, err, "Error accepting invitation")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		OrgID int `json:"org_id"`
+	}{orgID})
+}
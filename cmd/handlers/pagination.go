@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"car_project/pkg/query"
+)
+
+const defaultPageLimit = 10
+
+// listPage is the part of a sorted list a request asks for. Lists are paged
+// by an opaque cursor, or by page number for clients written before cursors.
+type listPage struct {
+	query.Page
+	schema query.Schema
+	sort   query.Sort
+	number int  // Page number starting at 1, or 0 when paging by cursor
+	total  bool // Whether to count the whole list
+}
+
+// parseListPage reads the cursor or page, limit and total parameters of a
+// list sorted by sort
+func parseListPage(r *http.Request, schema query.Schema, sort query.Sort) (listPage, error) {
+	params := r.URL.Query()
+	list := listPage{schema: schema, sort: sort}
+
+	// Unparseable numbers fall back to the defaults, as they always have
+	list.Limit, _ = strconv.Atoi(params.Get("limit"))
+	if list.Limit < 0 {
+		return list, errors.New("limit must be positive")
+	}
+	if list.Limit == 0 {
+		list.Limit = defaultPageLimit
+	}
+
+	if v := params.Get("total"); v != "" {
+		total, err := strconv.ParseBool(v)
+		if err != nil {
+			return list, errors.New("total must be true or false")
+		}
+		list.total = total
+	}
+
+	cursor := params.Get("cursor")
+	if params.Has("page") {
+		if cursor != "" {
+			return list, errors.New("use either page or cursor, not both")
+		}
+		list.number, _ = strconv.Atoi(params.Get("page"))
+		if list.number < 0 {
+			return list, errors.New("page must be positive")
+		}
+		if list.number == 0 {
+			list.number = 1
+		}
+		list.Offset = (list.number - 1) * list.Limit
+		return list, nil
+	}
+	if cursor != "" {
+		after, err := schema.ParseCursor(sort, cursor)
+		if err != nil {
+			return list, err
+		}
+		list.After = after
+	}
+	return list, nil
+}
+
+// fetch returns the page with one more item, which tells whether another
+// page follows
+func (list listPage) fetch() query.Page {
+	page := list.Page
+	page.Limit++
+	return page
+}
+
+// writeList writes items, fetched for list.fetch(), as a JSON array. A Link
+// header points to the neighbouring pages, and X-Total-Count holds the size
+// of the whole list if the client asked for it.
+func writeList[T any](w http.ResponseWriter, r *http.Request, list listPage, items []T, count func() (int, error)) {
+	more := len(items) > list.Limit
+	if more {
+		items = items[:list.Limit]
+	}
+	if items == nil {
+		items = []T{}
+	}
+
+	total := -1
+	if list.total {
+		var err error
+		if total, err = count(); err != nil {
+			dbError(w, r, err, "Error counting items")
+			return
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	}
+
+	var links []string
+	link := func(rel string, set map[string]string) {
+		params := r.URL.Query()
+		params.Del("cursor")
+		params.Del("page")
+		for k, v := range set {
+			params.Set(k, v)
+		}
+		target := PublicURL + r.URL.Path
+		if len(params) > 0 {
+			target += "?" + params.Encode()
+		}
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, target, rel))
+	}
+	if list.number == 0 {
+		link("first", nil)
+		if more {
+			link("next", map[string]string{"cursor": list.schema.Cursor(list.sort, items[len(items)-1])})
+		}
+	} else {
+		link("first", map[string]string{"page": "1"})
+		if list.number > 1 {
+			link("prev", map[string]string{"page": strconv.Itoa(list.number - 1)})
+		}
+		if more {
+			link("next", map[string]string{"page": strconv.Itoa(list.number + 1)})
+		}
+		if total >= 0 {
+			last := (total + list.Limit - 1) / list.Limit
+			if last < 1 {
+				last = 1
+			}
+			link("last", map[string]string{"page": strconv.Itoa(last)})
+		}
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"car_project/pkg/db"
+	"car_project/pkg/lockout"
+	"car_project/pkg/mail"
+	"car_project/pkg/model"
+	"car_project/pkg/password"
+	"car_project/pkg/token"
+)
+
+// ForgotPassword mails a password reset link. It always responds the same
+// way, and does its work in the background so that the response time does
+// not reveal whether the account exists either. Requests are rate limited
+// per username and per client like failed logins, so that nobody can flood
+// an inbox with reset emails.
+func (s *Server) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	accountKey := lockout.ResetAccountKey(req.Username)
+	ipKey := lockout.ResetIPKey(clientIP(r))
+	if s.loginLocked(w, r, accountKey, ipKey) {
+		return
+	}
+	// Every request counts, whether or not the account exists
+	s.recordLoginFailure(r.Context(), accountKey, lockout.ResetAccountPolicy)
+	s.recordLoginFailure(r.Context(), ipKey, lockout.ResetIPPolicy)
+
+	username := req.Username
+	queued := s.background.enqueue(func(ctx context.Context) {
+		user, err := s.Users.GetUserByUsername(ctx, username)
+		if err != nil {
+			return
+		}
+		if err := s.sendPasswordResetEmail(ctx, user); err != nil {
+			log.Printf("could not send password reset email to user %d: %v", user.ID, err)
+		}
+	})
+	if !queued {
+		log.Printf("background queue full, dropped password reset request")
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("If the account exists, a password reset link has been sent"))
+}
+
+func (s *Server) sendPasswordResetEmail(ctx context.Context, user *model.User) error {
+	plain, hash, err := token.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(token.PasswordResetTTL)
+	if err := s.Sessions.CreatePasswordReset(ctx, user.ID, hash, expiresAt); err != nil {
+		return err
+	}
+
+	link := PublicURL + "/user/password/reset?token=" + url.QueryEscape(plain)
+	return mail.Send(mail.Message{
+		To:      user.ContactEmail(),
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account. If it was you, open the link below before %s. Otherwise you can ignore this email.\n\n%s\n",
+			expiresAt.Format("2006-01-02 15:04 MST"), link),
+	})
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword and
+// logs the user out of every session
+func (s *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Clients may post back the reset link as-is with the token in the query
+	if req.Token == "" {
+		req.Token = r.URL.Query().Get("token")
+	}
+	if req.Token == "" {
+		http.Error(w, "Reset token not provided", http.StatusBadRequest)
+		return
+	}
+
+	// The policy needs the username, which only the token's row knows
+	tokenHash := token.HashOpaqueToken(req.Token)
+	owner, err := s.Sessions.GetPasswordResetUser(r.Context(), tokenHash)
+	if err != nil {
+		if errors.Is(err, db.ErrPasswordResetUnusable) {
+			http.Error(w, "Reset link is invalid, expired or already used", http.StatusBadRequest)
+			return
+		}
+		dbError(w, r, err, "Error resetting password")
+		return
+	}
+	if err := password.Check(req.Password, owner.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var user model.User
+	if err := user.CreateUser(req.Password); err != nil {
+		http.Error(w, "Error while hashing password", http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := s.Sessions.ResetPassword(r.Context(), tokenHash, user.Password)
+	if err != nil {
+		if errors.Is(err, db.ErrPasswordResetUnusable) {
+			http.Error(w, "Reset link is invalid, expired or already used", http.StatusBadRequest)
+			return
+		}
+		dbError(w, r, err, "Error resetting password")
+		return
+	}
+
+	// Refresh tokens were revoked with the password change; access tokens
+	// still in flight are cut off here
+	if err := s.Revocations.RevokeUser(r.Context(), userID, token.AccessTokenTTL); err != nil {
+		log.Printf("could not revoke access tokens of user %d: %v", userID, err)
+	}
+
+	w.Write([]byte("Password reset successfully"))
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"car_project/pkg/lockout"
+	"car_project/pkg/model"
+	"car_project/pkg/password"
+	"car_project/pkg/token"
+)
+
+// profile is the caller's own view of their account
+type profile struct {
+	ID           int        `json:"id"`
+	Username     string     `json:"username"`
+	DisplayName  string     `json:"display_name"`
+	Email        string     `json:"email"`
+	PendingEmail string     `json:"pending_email,omitempty"` // Waiting for the link mailed to it to be opened
+	Role         model.Role `json:"role"`
+	Verified     bool       `json:"verified"`
+	TOTPEnabled  bool       `json:"totp_enabled"`
+	ActiveOrgID  int        `json:"active_org_id"`
+}
+
+func newProfile(user *model.User) profile {
+	return profile{
+		ID:           user.ID,
+		Username:     user.Username,
+		DisplayName:  user.DisplayName,
+		Email:        user.ContactEmail(),
+		PendingEmail: user.PendingEmail,
+		Role:         user.Role,
+		Verified:     user.Verified,
+		TOTPEnabled:  user.TOTPEnabled,
+		ActiveOrgID:  user.ActiveOrgID,
+	}
+}
+
+// GetMe returns the caller's profile
+func (s *Server) GetMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newProfile(user))
+}
+
+// UpdateMe changes the caller's display name and email. Fields left out of
+// the body keep their current value. A new email needs the current password
+// and only replaces the old one once the link mailed to it is opened; the
+// old address is told about the change.
+func (s *Server) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DisplayName *string `json:"display_name"`
+		Email       *string `json:"email"`
+		Password    string  `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if req.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*req.DisplayName)
+		if len(user.DisplayName) > 100 {
+			http.Error(w, "Display name must be at most 100 characters", http.StatusBadRequest)
+			return
+		}
+	}
+	changeEmail := req.Email != nil && *req.Email != user.ContactEmail()
+	if changeEmail {
+		address, err := mail.ParseAddress(*req.Email)
+		if err != nil || address.Address != *req.Email {
+			http.Error(w, "Email must be a valid email address", http.StatusBadRequest)
+			return
+		}
+		if !s.checkPassword(w, r, user, req.Password) {
+			return
+		}
+	}
+
+	if err := s.Users.UpdateUserProfile(r.Context(), user.ID, user.DisplayName); err != nil {
+		dbError(w, r, err, "Error updating profile")
+		return
+	}
+
+	if changeEmail {
+		user.PendingEmail = *req.Email
+		if err := s.Users.SetPendingEmail(r.Context(), user.ID, user.PendingEmail); err != nil {
+			dbError(w, r, err, "Error updating profile")
+			return
+		}
+		if err := s.sendEmailChangeEmails(r.Context(), user); err != nil {
+			log.Printf("could not send email change verification to user %d: %v", user.ID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newProfile(user))
+}
+
+// checkPassword re-checks the caller's password before a sensitive change.
+// Failures count against the account and client like failed logins do, so
+// a stolen access token can't be used to guess the password.
+func (s *Server) checkPassword(w http.ResponseWriter, r *http.Request, user *model.User, plain string) bool {
+	accountKey := lockout.AccountKey(user.Username)
+	ipKey := lockout.IPKey(clientIP(r))
+	if s.loginLocked(w, r, accountKey, ipKey) {
+		return false
+	}
+
+	if ok, _ := user.Authenticate(plain); !ok {
+		s.recordLoginFailure(r.Context(), accountKey, lockout.AccountPolicy)
+		s.recordLoginFailure(r.Context(), ipKey, lockout.IPPolicy)
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return false
+	}
+
+	if err := s.Logins.ClearLoginFailures(r.Context(), accountKey); err != nil {
+		log.Printf("could not clear login failures for user %d: %v", user.ID, err)
+	}
+	return true
+}
+
+// ChangePassword sets a new password after re-checking the current one and
+// signs out every other session. Access tokens issued so far stop working,
+// including the caller's, which is replaced by the one returned; the
+// caller's refresh token stays valid.
+func (s *Server) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !s.checkPassword(w, r, user, req.OldPassword) {
+		return
+	}
+	if err := password.Check(req.NewPassword, user.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := user.CreateUser(req.NewPassword); err != nil {
+		http.Error(w, "Error while hashing password", http.StatusInternalServerError)
+		return
+	}
+	if err := s.Users.UpdateUserPassword(r.Context(), user.ID, user.Password); err != nil {
+		dbError(w, r, err, "Error updating password")
+		return
+	}
+
+	// API keys can't reach this route, so the caller has a session
+	id, _ := IdentityFromContext(r.Context())
+	if err := s.Sessions.RevokeUserRefreshTokensExcept(r.Context(), user.ID, id.SessionID); err != nil {
+		dbError(w, r, err, "Error revoking sessions")
+		return
+	}
+	if err := s.Revocations.RevokeUser(r.Context(), user.ID, token.AccessTokenTTL); err != nil {
+		dbError(w, r, err, "Error revoking sessions")
+		return
+	}
+	accessToken, err := token.NewAccessToken(user, id.SessionID, id.MFA)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+	writeTokens(w, accessToken, "")
+}
+
+// DeleteMe deletes the caller's account after re-checking their password.
+// Their ratings are kept anonymously unless ?ratings=delete is given.
+func (s *Server) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	keepRatings := true
+	switch r.URL.Query().Get("ratings") {
+	case "", "anonymize":
+	case "delete":
+		keepRatings = false
+	default:
+		http.Error(w, "ratings must be anonymize or delete", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !s.checkPassword(w, r, user, req.Password) {
+		return
+	}
+
+	if err := s.Users.DeleteUser(r.Context(), user.ID, keepRatings); err != nil {
+		dbError(w, r, err, "Error deleting account")
+		return
+	}
+	if err := s.Revocations.RevokeUser(r.Context(), user.ID, token.AccessTokenTTL); err != nil {
+		log.Printf("could not revoke access tokens of deleted user %d: %v", user.ID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"car_project/pkg/db"
+	"car_project/pkg/revocation"
+)
+
+// Server serves the HTTP API. Handlers read and write through its stores,
+// so they can run against any implementation, such as the in-memory one
+// in tests.
+type Server struct {
+	Cars    db.CarStore
+	History db.CarHistoryStore
+	Ratings db.RatingStore
+	Users   db.UserStore
+
+	Sessions   db.SessionStore
+	Logins     db.LoginFailureStore
+	MFA        db.MFAStore
+	APIKeys    db.APIKeyStore
+	Identities db.IdentityStore
+	Orgs       db.OrgStore
+	Admin      db.AdminStore
+
+	// Revocations answers whether an access token was revoked
+	Revocations *revocation.Store
+
+	// Tx runs reads and writes that must happen together on the same stores
+	Tx db.Transactor
+
+	background *jobQueue
+}
+
+// NewServer returns a server using store for everything. Its revocations
+// are not synced with other instances until s.Revocations.Run is called.
+func NewServer(store db.Store) *Server {
+	return &Server{
+		Cars:        store,
+		History:     store,
+		Ratings:     store,
+		Users:       store,
+		Sessions:    store,
+		Logins:      store,
+		MFA:         store,
+		APIKeys:     store,
+		Identities:  store,
+		Orgs:        store,
+		Admin:       store,
+		Revocations: revocation.New(store),
+		Tx:          store,
+		background:  newJobQueue(backgroundWorkers, backgroundQueueSize),
+	}
+}
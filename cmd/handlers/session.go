@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"car_project/pkg/token"
+)
+
+// Logout ends the caller's current session: its access token stops working
+// immediately and its refresh token can no longer be exchanged
+func (s *Server) Logout(w http.ResponseWriter, r *http.Request) {
+	id, ok := IdentityFromContext(r.Context())
+	if !ok || id.APIKeyID != 0 {
+		http.Error(w, "Only sessions can log out, revoke API keys instead", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.endSession(r.Context(), id); err != nil {
+		dbError(w, r, err, "Error revoking session")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// endSession revokes the caller's access token and the refresh token
+// family it was issued with
+func (s *Server) endSession(ctx context.Context, id Identity) error {
+	if id.TokenID != "" {
+		if err := s.Revocations.RevokeToken(ctx, id.TokenID, id.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	if id.SessionID != "" {
+		return s.Sessions.RevokeRefreshTokenFamily(ctx, id.SessionID)
+	}
+	return nil
+}
+
+// LogoutAll ends every session of the caller on every device
+func (s *Server) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	id, ok := IdentityFromContext(r.Context())
+	if !ok || id.APIKeyID != 0 {
+		http.Error(w, "Only sessions can log out, revoke API keys instead", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.revokeAllSessions(r.Context(), id.UserID); err != nil {
+		dbError(w, r, err, "Error revoking sessions")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeAllSessions invalidates every access and refresh token of the user
+func (s *Server) revokeAllSessions(ctx context.Context, userID int) error {
+	if err := s.Sessions.RevokeUserRefreshTokens(ctx, userID); err != nil {
+		return err
+	}
+	return s.Revocations.RevokeUser(ctx, userID, token.AccessTokenTTL)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"car_project/pkg/db"
+
+	"github.com/gorilla/mux"
+)
+
+// QueryTimeout gives every request a deadline that its database calls are
+// cancelled at. Routes are looked up as "METHOD /path/template", for
+// example "GET /api/cars"; other routes get def. Zero means no deadline.
+func QueryTimeout(def time.Duration, routes map[string]time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := def
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					if d, ok := routes[r.Method+" "+template]; ok {
+						timeout = d
+					}
+				}
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// dbError responds to a failed database call. Calls that ran out of time
+// get 504 and an unreachable or overloaded database 503, so that clients
+// know to retry, and a violated constraint 409 or 422, see constraintError;
+// anything else is a 500 with msg. Driver messages are only logged, never
+// sent to the client.
+func dbError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(r.Context().Err(), context.DeadlineExceeded):
+		http.Error(w, "The database did not respond in time, try again later", http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled), db.IsUnavailable(err):
+		http.Error(w, "The database is unavailable, try again later", http.StatusServiceUnavailable)
+	case db.AsConstraintError(err) != nil:
+		constraintError(w, db.AsConstraintError(err))
+	default:
+		log.Printf("%s %s: %s: %v", r.Method, r.URL.Path, msg, err)
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
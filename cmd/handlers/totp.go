@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"car_project/pkg/lockout"
+	"car_project/pkg/model"
+	"car_project/pkg/token"
+	"car_project/pkg/totp"
+)
+
+// TOTPIssuer is the account issuer shown in authenticator apps
+var TOTPIssuer = "car_project"
+
+// RequireAdminMFA makes admins who logged in without a second factor act as
+// viewers, so that one password is never enough to manage the catalog
+var RequireAdminMFA = true
+
+// recoveryCodeCount is how many one-time recovery codes a user gets
+const recoveryCodeCount = 10
+
+// mfaChallenge is returned by LoginUser instead of tokens when 2FA is on
+type mfaChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+func writeMFAChallenge(w http.ResponseWriter, user *model.User) {
+	challenge, err := token.NewMFAChallengeToken(user.ID)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mfaChallenge{MFARequired: true, MFAToken: challenge})
+}
+
+// LoginSecondFactor completes a login that LoginUser answered with a
+// challenge, given a TOTP code or one of the user's recovery codes
+func (s *Server) LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := token.ParseMFAChallengeToken(req.MFAToken)
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	// Six digits are easy to guess, so codes are throttled like passwords
+	key := mfaKey(userID)
+	if s.loginLocked(w, r, key) {
+		return
+	}
+
+	user, err := s.Users.GetUserByID(r.Context(), userID)
+	if err != nil || !user.TOTPEnabled {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	ok, err := s.checkSecondFactor(r.Context(), user, req.Code, req.RecoveryCode)
+	if err != nil {
+		dbError(w, r, err, "Error checking code")
+		return
+	}
+	if !ok {
+		s.recordLoginFailure(r.Context(), key, lockout.AccountPolicy)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.Logins.ClearLoginFailures(r.Context(), key); err != nil {
+		log.Printf("could not clear MFA failures for user %d: %v", user.ID, err)
+	}
+	if !accountUsable(w, user) {
+		return
+	}
+	s.issueTokens(w, r, user, true)
+}
+
+// checkSecondFactor accepts either a TOTP code that was not used before or
+// an unused recovery code, consuming it
+func (s *Server) checkSecondFactor(ctx context.Context, user *model.User, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return s.MFA.UseTOTPStep(ctx, user.ID, step)
+	}
+	if recoveryCode != "" {
+		return s.MFA.UseRecoveryCode(ctx, user.ID, token.HashOpaqueToken(normalizeRecoveryCode(recoveryCode)))
+	}
+	return false, nil
+}
+
+// EnrollTOTP starts 2FA enrollment for the caller and returns the secret to
+// add to an authenticator app. It takes effect once confirmed.
+func (s *Server) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		http.Error(w, "Error generating secret", http.StatusInternalServerError)
+		return
+	}
+	if err := s.MFA.SetTOTPSecret(r.Context(), user.ID, secret); err != nil {
+		dbError(w, r, err, "Error storing secret")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(TOTPIssuer, user.Username, secret),
+	})
+}
+
+// ConfirmTOTP enables 2FA once the caller proves their app produces valid
+// codes, and returns their recovery codes. They are shown only this once.
+func (s *Server) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if user.TOTPSecret == "" {
+		http.Error(w, "Start enrollment first", http.StatusBadRequest)
+		return
+	}
+
+	step, valid := totp.Validate(user.TOTPSecret, req.Code, time.Now())
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	// A code can't be used again, here or to log in
+	used, err := s.MFA.UseTOTPStep(r.Context(), user.ID, step)
+	if err != nil {
+		dbError(w, r, err, "Error enabling two-factor authentication")
+		return
+	}
+	if !used {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+	if err := s.MFA.EnableTOTP(r.Context(), user.ID, hashes); err != nil {
+		dbError(w, r, err, "Error enabling two-factor authentication")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes, given a current TOTP code
+func (s *Server) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUserWithSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+	if err := s.MFA.ReplaceRecoveryCodes(r.Context(), user.ID, hashes); err != nil {
+		dbError(w, r, err, "Error storing recovery codes")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTOTP turns 2FA off for the caller, given a TOTP or recovery code
+func (s *Server) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUserWithSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.MFA.DisableTOTP(r.Context(), user.ID); err != nil {
+		dbError(w, r, err, "Error disabling two-factor authentication")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// currentUser loads the authenticated caller, writing an error if that fails
+func (s *Server) currentUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	id, ok := IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return nil, false
+	}
+	user, err := s.Users.GetUserByID(r.Context(), id.UserID)
+	if err != nil {
+		dbError(w, r, err, "Error reading user")
+		return nil, false
+	}
+	return user, true
+}
+
+// currentUserWithSecondFactor loads the caller and checks the TOTP or
+// recovery code in the request body
+func (s *Server) currentUserWithSecondFactor(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	var req struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return nil, false
+	}
+
+	// Throttled like LoginSecondFactor, or a stolen access token could
+	// guess its way to turning 2FA off
+	key := mfaKey(user.ID)
+	if s.loginLocked(w, r, key) {
+		return nil, false
+	}
+	valid, err := s.checkSecondFactor(r.Context(), user, req.Code, req.RecoveryCode)
+	if err != nil {
+		dbError(w, r, err, "Error checking code")
+		return nil, false
+	}
+	if !valid {
+		s.recordLoginFailure(r.Context(), key, lockout.AccountPolicy)
+		http.Error(w, "Invalid code", http.StatusForbidden)
+		return nil, false
+	}
+
+	if err := s.Logins.ClearLoginFailures(r.Context(), key); err != nil {
+		log.Printf("could not clear MFA failures for user %d: %v", user.ID, err)
+	}
+	return user, true
+}
+
+// mfaKey is the lockout key second factor attempts of a user count against
+func mfaKey(userID int) string {
+	return "mfa:" + strconv.Itoa(userID)
+}
+
+// newRecoveryCodes returns fresh recovery codes and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		secret, err := totp.NewSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(secret[:5] + "-" + secret[5:10])
+		codes[i] = code
+		hashes[i] = token.HashOpaqueToken(normalizeRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes typed by the user
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"car_project/pkg/db"
+	"car_project/pkg/mail"
+	"car_project/pkg/model"
+	"car_project/pkg/token"
+)
+
+// PublicURL is the externally reachable base URL used to build links in emails
+var PublicURL = "http://localhost:8080"
+
+// sendVerificationEmail mails the user a single-use link to /user/verify
+func (s *Server) sendVerificationEmail(ctx context.Context, user *model.User) error {
+	tokenString, jti, expiresAt, err := token.NewEmailVerificationToken(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.Sessions.CreateEmailVerification(ctx, jti, user.ID, "", expiresAt); err != nil {
+		return err
+	}
+
+	link := PublicURL + "/user/verify?token=" + url.QueryEscape(tokenString)
+	return mail.Send(mail.Message{
+		To:      user.ContactEmail(),
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Open the link below to activate your account. It expires on %s.\n\n%s\n",
+			expiresAt.Format("2006-01-02 15:04 MST"), link),
+	})
+}
+
+// sendEmailChangeEmails mails a link confirming the user's pending address
+// to that address, and tells the current one about the change
+func (s *Server) sendEmailChangeEmails(ctx context.Context, user *model.User) error {
+	tokenString, jti, expiresAt, err := token.NewEmailVerificationToken(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.Sessions.CreateEmailVerification(ctx, jti, user.ID, user.PendingEmail, expiresAt); err != nil {
+		return err
+	}
+
+	link := PublicURL + "/user/verify?token=" + url.QueryEscape(tokenString)
+	err = mail.Send(mail.Message{
+		To:      user.PendingEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Open the link below to use this address for your account. It expires on %s.\n\n%s\n",
+			expiresAt.Format("2006-01-02 15:04 MST"), link),
+	})
+	if err != nil {
+		return err
+	}
+	return mail.Send(mail.Message{
+		To:      user.ContactEmail(),
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf("Someone asked to change the email address of your account to %s. It changes once the link sent there is opened.\n\n"+
+			"If this wasn't you, change your password right away.\n", user.PendingEmail),
+	})
+}
+
+// VerifyEmail consumes a verification link and activates the account
+func (s *Server) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	userID, jti, err := token.ParseEmailVerificationToken(r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, "Invalid verification link", http.StatusBadRequest)
+		return
+	}
+
+	err = s.Sessions.UseEmailVerification(r.Context(), jti, userID)
+	if err != nil {
+		if errors.Is(err, db.ErrVerificationUnusable) {
+			http.Error(w, "Verification link is invalid, expired or already used", http.StatusBadRequest)
+			return
+		}
+		dbError(w, r, err, "Error verifying email")
+		return
+	}
+
+	w.Write([]byte("Email verified successfully"))
+}
+
+// ResendVerification mails a fresh verification link. It responds the same
+// way whether or not the account exists or is already verified.
+func (s *Server) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	user, err := s.Users.GetUserByUsername(r.Context(), req.Username)
+	if err == nil && !user.Verified {
+		if err := s.sendVerificationEmail(r.Context(), user); err != nil {
+			log.Printf("could not send verification email to user %d: %v", user.ID, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("If the account exists and is not verified, a new link has been sent"))
+}
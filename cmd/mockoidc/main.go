@@ -0,0 +1,35 @@
+// Command mockoidc runs a mock OpenID Connect provider for local SSO
+// testing. Point the server at it with
+//
+//	OIDC_ISSUER=http://localhost:9090 OIDC_CLIENT_ID=car_project
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"car_project/pkg/oidc/mock"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9090", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9090", "issuer URL, as reachable by the server and the browser")
+	clientID := flag.String("client-id", "car_project", "accepted client ID")
+	clientSecret := flag.String("client-secret", "", "required client secret, empty for public clients")
+	email := flag.String("email", "sso.user@example.com", "email of the user everyone is logged in as")
+	flag.Parse()
+
+	p, err := mock.New(*issuer, *clientID, *clientSecret, mock.User{
+		Subject:       "mock|" + *email,
+		Email:         *email,
+		EmailVerified: true,
+		Name:          "SSO User",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("mock OIDC provider for %s listening on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, p))
+}
@@ -1,61 +1,209 @@
 package main
 
 import (
-    "log"
-    "net/http"
+	"log"
+	"net/http"
+	"os"
+	"strings"
 
-    "github.com/gorilla/mux"
-    "car_project/cmd/handlers"
-    "car_project/pkg/db"
+	"car_project/cmd/handlers"
+	"car_project/pkg/config"
+	"car_project/pkg/db"
+	"car_project/pkg/mail"
+	"car_project/pkg/model"
+	"car_project/pkg/oidc"
+	"car_project/pkg/password"
+	"car_project/pkg/token"
+	"github.com/gorilla/mux"
 )
 
-// Middleware to set CORS headers
-func setCORSHeaders(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        w.Header().Set("Access-Control-Allow-Origin", "*")
-        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-        if r.Method == "OPTIONS" {
-            return
-        }
-        next.ServeHTTP(w, r)
-    })
+// Middleware to set CORS headers for the allowed origins
+func setCORSHeaders(origins []string) mux.MiddlewareFunc {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if allowed["*"] {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Add("Vary", "Origin")
+				if origin := r.Header.Get("Origin"); allowed[origin] {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+			w.Header().Set("Access-Control-Expose-Headers", "Link, X-Total-Count")
+			if r.Method == "OPTIONS" {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 func main() {
-    // Initialize the database
-    db.InitDB()
-
-    // Create a new router
-    r := mux.NewRouter()
-    api := r.PathPrefix("/api").Subrouter()
-    api.Use(handlers.Authenticate)
-    
-    // Apply CORS middleware
-    r.Use(setCORSHeaders)
-
-    // Define routes
-    api.HandleFunc("/cars", handlers.CreateCar).Methods("POST")
-    api.HandleFunc("/cars", handlers.GetAllCars).Methods("GET")
-    api.HandleFunc("/cars/{id}", handlers.GetCar).Methods("GET")
-    api.HandleFunc("/cars/{id}", handlers.UpdateCar).Methods("PUT")
-    api.HandleFunc("/cars/{id}", handlers.DeleteCar).Methods("DELETE")
-
-    api.HandleFunc("/carhistory", handlers.CreateCarHistory).Methods("POST") 
-    api.HandleFunc("/carhistory", handlers.GetAllCarHistory).Methods("GET") 
-    api.HandleFunc("/carhistory/{id}", handlers.GetCarHistoryByID).Methods("GET") 
-    api.HandleFunc("/carhistory/{id}", handlers.UpdateCarHistory).Methods("PUT") 
-    api.HandleFunc("/carhistory/{id}", handlers.DeleteCarHistory).Methods("DELETE") 
-
-    api.HandleFunc("/ratings", handlers.CreateRating).Methods("POST")
-    api.HandleFunc("/cars/{id}/ratings", handlers.GetRating).Methods("GET")
-    api.HandleFunc("/ratings", handlers.UpdateRating).Methods("PUT")
-    api.HandleFunc("/ratings", handlers.DeleteRating).Methods("DELETE")
-
-    r.HandleFunc("/user/register", handlers.RegisterUser).Methods("POST")
-    r.HandleFunc("/user/login", handlers.LoginUser).Methods("POST")
-    r.HandleFunc("/user/activate", handlers.Activate).Methods("POST")
-
-    // Start the server
-    log.Fatal(http.ListenAndServe("0.0.0.0:8080", r))
+	// Load the configuration from flags, the environment and the config file
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	if cfg.PrintOnly {
+		cfg.Print(os.Stdout)
+		return
+	}
+
+	// Manage the database schema instead of serving
+	if len(cfg.Args) > 0 {
+		if cfg.Args[0] != "migrate" {
+			log.Fatalf("unknown command %q\n\n%s", cfg.Args[0], db.MigrateUsage)
+		}
+		conn, driverName := db.OpenDB(cfg.Database)
+		if err := db.Migrate(conn, driverName, cfg.Args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	log.Printf("starting with configuration:\n%s", redactedConfig(cfg))
+
+	// Initialize the database
+	conn, driverName := db.InitDB(cfg.Database)
+
+	// Load the JWT signing keys and token lifetimes
+	token.InitKeys(cfg.Tokens.Keys, cfg.Tokens.SigningKey, cfg.Tokens.Secret)
+	token.AccessTokenTTL = cfg.Tokens.AccessTTL
+	token.RefreshTokenTTL = cfg.Tokens.RefreshTTL
+	token.PasswordResetTTL = cfg.Tokens.PasswordResetTTL
+	token.OrgInvitationTTL = cfg.Tokens.InvitationTTL
+
+	// Load the password policy
+	if err := password.InitPolicy(cfg.Password.MinLength, cfg.Password.BlocklistFile); err != nil {
+		log.Fatal(err)
+	}
+
+	// Pick the mailer used for verification emails
+	mail.InitMailer(mail.Config{
+		From:         cfg.Mail.From,
+		SMTPAddr:     cfg.Mail.SMTPAddr,
+		SMTPUsername: cfg.Mail.SMTPUsername,
+		SMTPPassword: cfg.Mail.SMTPPassword,
+		Dir:          cfg.Mail.Dir,
+	})
+	handlers.PublicURL = cfg.PublicURL
+	handlers.RequireAdminMFA = cfg.AdminRequire2FA
+
+	// Enable single sign-on if an identity provider is configured
+	oidc.Init(oidc.Config{
+		Issuer:       cfg.OIDC.Issuer,
+		ClientID:     cfg.OIDC.ClientID,
+		ClientSecret: cfg.OIDC.ClientSecret,
+		RedirectURL:  cfg.OIDC.RedirectURL,
+		Scopes:       cfg.OIDC.Scopes,
+	}, handlers.OIDCRedirectURL())
+
+	// Serve the API from the SQL stores, on Postgres or SQLite
+	onCarDelete := db.CarDeleteRestrict
+	if cfg.Database.OnCarDelete == "cascade" {
+		onCarDelete = db.CarDeleteCascade
+	}
+	srv := handlers.NewServer(db.NewSQLStore(conn, driverName, onCarDelete))
+
+	// Load revoked tokens and keep them in sync with other instances
+	srv.Revocations.Run()
+
+	// Create a new router
+	r := mux.NewRouter()
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(srv.Authenticate)
+
+	// Apply CORS middleware
+	r.Use(setCORSHeaders(cfg.CORS.AllowedOrigins))
+
+	// Cancel the database work of requests that take too long
+	r.Use(handlers.QueryTimeout(cfg.Database.QueryTimeout, cfg.Database.RouteTimeouts))
+
+	// Define routes; creating and deleting cars requires the editor role,
+	// other writes to a car and its history check grants on the car as well.
+	// API keys may only call the routes registered with the scope they need.
+	api.Handle("/cars", handlers.RequireScope(model.ScopeCarsWrite, handlers.RequireRole(model.RoleEditor, srv.CreateCar))).Methods("POST")
+	api.Handle("/cars", handlers.RequireScope(model.ScopeCarsRead, srv.GetAllCars)).Methods("GET")
+	api.Handle("/cars/shared", handlers.RequireScope(model.ScopeCarsRead, srv.GetSharedCars)).Methods("GET")
+	api.Handle("/cars/{id}", handlers.RequireScope(model.ScopeCarsRead, srv.GetCar)).Methods("GET")
+	api.Handle("/cars/{id}", handlers.RequireScope(model.ScopeCarsWrite, srv.UpdateCar)).Methods("PUT")
+	api.Handle("/cars/{id}", handlers.RequireScope(model.ScopeCarsWrite, handlers.RequireRole(model.RoleEditor, srv.DeleteCar))).Methods("DELETE")
+
+	// Sharing cars is left to people
+	api.HandleFunc("/cars/{id}/grants", srv.GrantCarAccess).Methods("POST")
+	api.HandleFunc("/cars/{id}/grants", srv.GetCarGrants).Methods("GET")
+	api.HandleFunc("/cars/{id}/grants/{userID}", srv.RevokeCarGrant).Methods("DELETE")
+
+	api.Handle("/carhistory", handlers.RequireScope(model.ScopeHistoryWrite, srv.CreateCarHistory)).Methods("POST")
+	api.Handle("/carhistory", handlers.RequireScope(model.ScopeHistoryRead, srv.GetAllCarHistory)).Methods("GET")
+	api.Handle("/carhistory/{id}", handlers.RequireScope(model.ScopeHistoryRead, srv.GetCarHistoryByID)).Methods("GET")
+	api.Handle("/carhistory/{id}", handlers.RequireScope(model.ScopeHistoryWrite, srv.UpdateCarHistory)).Methods("PUT")
+	api.Handle("/carhistory/{id}", handlers.RequireScope(model.ScopeHistoryWrite, srv.DeleteCarHistory)).Methods("DELETE")
+
+	api.Handle("/ratings", handlers.RequireScope(model.ScopeRatingsWrite, srv.CreateRating)).Methods("POST")
+	api.Handle("/cars/{id}/ratings", handlers.RequireScope(model.ScopeRatingsRead, srv.GetRating)).Methods("GET")
+	api.Handle("/ratings", handlers.RequireScope(model.ScopeRatingsWrite, srv.UpdateRating)).Methods("PUT")
+	api.Handle("/ratings", handlers.RequireScope(model.ScopeRatingsWrite, srv.DeleteRating)).Methods("DELETE")
+
+	api.HandleFunc("/keys", srv.CreateAPIKey).Methods("POST")
+	api.HandleFunc("/keys", srv.GetAPIKeys).Methods("GET")
+	api.HandleFunc("/keys/{id}", srv.RevokeAPIKey).Methods("DELETE")
+
+	api.HandleFunc("/orgs", srv.CreateOrganization).Methods("POST")
+	api.HandleFunc("/orgs", srv.GetOrganizations).Methods("GET")
+	api.HandleFunc("/orgs/invitations/accept", srv.AcceptInvitation).Methods("POST")
+	api.HandleFunc("/orgs/{id}/switch", srv.SwitchOrganization).Methods("POST")
+	api.HandleFunc("/orgs/{id}/invitations", srv.InviteToOrganization).Methods("POST")
+
+	api.HandleFunc("/user/oidc/link", srv.LinkOIDCIdentity).Methods("POST")
+
+	api.HandleFunc("/user/2fa/enroll", srv.EnrollTOTP).Methods("POST")
+	api.HandleFunc("/user/2fa/confirm", srv.ConfirmTOTP).Methods("POST")
+	api.HandleFunc("/user/2fa/recovery-codes", srv.RegenerateRecoveryCodes).Methods("POST")
+	api.HandleFunc("/user/2fa/disable", srv.DisableTOTP).Methods("POST")
+
+	api.HandleFunc("/admin/lockouts", handlers.RequireRole(model.RoleAdmin, srv.GetLockouts)).Methods("GET")
+	api.HandleFunc("/admin/lockouts/{id}", handlers.RequireRole(model.RoleAdmin, srv.ClearLockout)).Methods("DELETE")
+	api.HandleFunc("/admin/users", handlers.RequireRole(model.RoleAdmin, srv.GetUsers)).Methods("GET")
+	api.HandleFunc("/admin/users/{id}", handlers.RequireRole(model.RoleAdmin, srv.GetUser)).Methods("GET")
+	api.HandleFunc("/admin/users/{id}/disable", handlers.RequireRole(model.RoleAdmin, srv.DisableUser)).Methods("POST")
+	api.HandleFunc("/admin/users/{id}/enable", handlers.RequireRole(model.RoleAdmin, srv.EnableUser)).Methods("POST")
+	api.HandleFunc("/admin/users/{id}/role", handlers.RequireRole(model.RoleAdmin, srv.SetUserRole)).Methods("PUT")
+	api.HandleFunc("/admin/users/{id}/password-reset", handlers.RequireRole(model.RoleAdmin, srv.ForcePasswordReset)).Methods("POST")
+	api.HandleFunc("/admin/audit", handlers.RequireRole(model.RoleAdmin, srv.GetAuditLog)).Methods("GET")
+
+	r.HandleFunc("/user/register", srv.RegisterUser).Methods("POST")
+	r.HandleFunc("/user/login", srv.LoginUser).Methods("POST")
+	r.HandleFunc("/user/login/2fa", srv.LoginSecondFactor).Methods("POST")
+	r.HandleFunc("/user/oidc/login", srv.OIDCLogin).Methods("GET")
+	r.HandleFunc("/user/oidc/callback", srv.OIDCCallback).Methods("GET")
+	r.HandleFunc("/user/verify", srv.VerifyEmail).Methods("GET")
+	r.HandleFunc("/user/verify/resend", srv.ResendVerification).Methods("POST")
+	r.HandleFunc("/user/token/refresh", srv.RefreshToken).Methods("POST")
+	r.Handle("/user/logout", srv.Authenticate(http.HandlerFunc(srv.Logout))).Methods("POST")
+	r.Handle("/user/logout/all", srv.Authenticate(http.HandlerFunc(srv.LogoutAll))).Methods("POST")
+	r.Handle("/user/me", srv.Authenticate(http.HandlerFunc(srv.GetMe))).Methods("GET")
+	r.Handle("/user/me", srv.Authenticate(http.HandlerFunc(srv.UpdateMe))).Methods("PUT")
+	r.Handle("/user/me", srv.Authenticate(http.HandlerFunc(srv.DeleteMe))).Methods("DELETE")
+	r.Handle("/user/me/password", srv.Authenticate(http.HandlerFunc(srv.ChangePassword))).Methods("PUT")
+	r.HandleFunc("/user/password/forgot", srv.ForgotPassword).Methods("POST")
+	r.HandleFunc("/user/password/reset", srv.ResetPassword).Methods("POST")
+
+	r.HandleFunc("/.well-known/jwks.json", srv.JWKS).Methods("GET")
+
+	// Start the server
+	log.Fatal(http.ListenAndServe(cfg.ListenAddr, r))
+}
+
+// redactedConfig renders the configuration for the startup log
+func redactedConfig(cfg *config.Config) string {
+	var b strings.Builder
+	cfg.Print(&b)
+	return b.String()
 }
@@ -0,0 +1,11 @@
+-- migrate:up
+-- Accounts that existed before verification was introduced stay usable
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verified BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ALTER COLUMN verified SET DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+    jti VARCHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
@@ -0,0 +1,9 @@
+-- migrate:up
+-- One row per username and per client IP that recently failed to log in
+CREATE TABLE IF NOT EXISTS login_failures (
+    id SERIAL PRIMARY KEY,
+    key VARCHAR(300) UNIQUE NOT NULL,
+    failures INT NOT NULL,
+    last_failure TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ
+);
@@ -0,0 +1,6 @@
+-- migrate:down
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS mfa;
+DROP TABLE IF EXISTS totp_recovery_codes;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
@@ -0,0 +1,3 @@
+-- migrate:down
+DROP TABLE IF EXISTS oidc_logins;
+DROP TABLE IF EXISTS user_identities;
@@ -0,0 +1,12 @@
+-- migrate:down
+DELETE FROM ratings WHERE user_id IS NULL;
+ALTER TABLE ratings DROP CONSTRAINT IF EXISTS ratings_car_id_user_id_key;
+ALTER TABLE ratings DROP CONSTRAINT IF EXISTS ratings_pkey;
+ALTER TABLE ratings ALTER COLUMN user_id SET NOT NULL;
+ALTER TABLE ratings ADD PRIMARY KEY (car_id, user_id);
+ALTER TABLE ratings DROP COLUMN IF EXISTS id;
+
+ALTER TABLE email_verifications DROP COLUMN IF EXISTS email;
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email;
+ALTER TABLE users DROP COLUMN IF EXISTS email;
+ALTER TABLE users DROP COLUMN IF EXISTS display_name;
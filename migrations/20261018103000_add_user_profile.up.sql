@@ -0,0 +1,18 @@
+-- migrate:up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name VARCHAR(100);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email VARCHAR(255);
+-- Usernames have been email addresses since verification was introduced
+UPDATE users SET email = username WHERE email IS NULL AND username LIKE '%@%';
+
+-- A new address only replaces email once the link mailed to it is opened
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email VARCHAR(255);
+-- The address a verification link confirms, or NULL for the account itself
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS email VARCHAR(255);
+
+-- Ratings of deleted accounts may be kept anonymously with a NULL user_id,
+-- which the (car_id, user_id) primary key does not allow
+ALTER TABLE ratings ADD COLUMN IF NOT EXISTS id SERIAL;
+ALTER TABLE ratings DROP CONSTRAINT IF EXISTS ratings_pkey;
+ALTER TABLE ratings ADD PRIMARY KEY (id);
+ALTER TABLE ratings ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE ratings ADD CONSTRAINT ratings_car_id_user_id_key UNIQUE (car_id, user_id);
@@ -0,0 +1,6 @@
+-- migrate:down
+DROP TABLE IF EXISTS admin_audit_log;
+
+ALTER TABLE users DROP COLUMN IF EXISTS created_at;
+ALTER TABLE users DROP COLUMN IF EXISTS password_reset_required;
+ALTER TABLE users DROP COLUMN IF EXISTS disabled;
@@ -0,0 +1,11 @@
+-- migrate:down
+ALTER TABLE api_keys DROP COLUMN IF EXISTS org_id;
+DROP INDEX IF EXISTS car_history_org_id_idx;
+ALTER TABLE car_history DROP COLUMN IF EXISTS org_id;
+DROP INDEX IF EXISTS car_org_id_idx;
+ALTER TABLE car DROP COLUMN IF EXISTS org_id;
+ALTER TABLE users DROP COLUMN IF EXISTS active_org_id;
+
+DROP TABLE IF EXISTS org_invitations;
+DROP TABLE IF EXISTS org_memberships;
+DROP TABLE IF EXISTS organizations;
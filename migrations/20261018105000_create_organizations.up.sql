@@ -0,0 +1,54 @@
+-- migrate:up
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Owners may invite other users; members may only use the organization's data
+CREATE TABLE IF NOT EXISTS org_memberships (
+    org_id INT NOT NULL,
+    user_id INT NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('member', 'owner')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS org_invitations (
+    id SERIAL PRIMARY KEY,
+    org_id INT NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    invited_by INT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS org_memberships_user_id_idx ON org_memberships (user_id);
+
+-- Everything that existed before tenancy belongs to one organization that
+-- all existing users are members of; admins own it
+INSERT INTO organizations (id, name) VALUES (1, 'Default') ON CONFLICT (id) DO NOTHING;
+SELECT setval(pg_get_serial_sequence('organizations', 'id'), (SELECT MAX(id) FROM organizations));
+INSERT INTO org_memberships (org_id, user_id, role)
+    SELECT 1, id, CASE WHEN role = 'admin' THEN 'owner' ELSE 'member' END FROM users
+    ON CONFLICT DO NOTHING;
+
+-- The organization a user works in, copied into their access tokens
+ALTER TABLE users ADD COLUMN IF NOT EXISTS active_org_id INT;
+UPDATE users SET active_org_id = 1 WHERE active_org_id IS NULL;
+
+ALTER TABLE car ADD COLUMN IF NOT EXISTS org_id INT;
+UPDATE car SET org_id = 1 WHERE org_id IS NULL;
+ALTER TABLE car ALTER COLUMN org_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS car_org_id_idx ON car (org_id);
+
+ALTER TABLE car_history ADD COLUMN IF NOT EXISTS org_id INT;
+UPDATE car_history SET org_id = 1 WHERE org_id IS NULL;
+ALTER TABLE car_history ALTER COLUMN org_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS car_history_org_id_idx ON car_history (org_id);
+
+-- API keys act within the organization they were created in
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS org_id INT;
+UPDATE api_keys SET org_id = 1 WHERE org_id IS NULL;
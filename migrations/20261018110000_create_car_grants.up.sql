@@ -0,0 +1,14 @@
+-- migrate:up
+-- Access to a single car and its history, for users inside or outside the
+-- car's organization
+CREATE TABLE IF NOT EXISTS car_grants (
+    id SERIAL PRIMARY KEY,
+    car_id INT NOT NULL,
+    user_id INT NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('viewer', 'editor')),
+    granted_by INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (car_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS car_grants_user_id_idx ON car_grants (user_id);
@@ -0,0 +1,12 @@
+-- migrate:down
+DROP INDEX IF EXISTS ratings_user_id_idx;
+DROP INDEX IF EXISTS car_history_car_id_idx;
+
+ALTER TABLE car_history DROP CONSTRAINT IF EXISTS car_history_type_check;
+ALTER TABLE ratings DROP CONSTRAINT IF EXISTS ratings_stars_check;
+
+ALTER TABLE car_grants DROP CONSTRAINT IF EXISTS car_grants_user_id_fkey;
+ALTER TABLE car_grants DROP CONSTRAINT IF EXISTS car_grants_car_id_fkey;
+ALTER TABLE ratings DROP CONSTRAINT IF EXISTS ratings_user_id_fkey;
+ALTER TABLE ratings DROP CONSTRAINT IF EXISTS ratings_car_id_fkey;
+ALTER TABLE car_history DROP CONSTRAINT IF EXISTS car_history_car_id_fkey;
@@ -0,0 +1,37 @@
+-- migrate:up
+-- Rows left behind by cars and users deleted before there were foreign keys
+DELETE FROM car_history WHERE car_id NOT IN (SELECT id FROM car);
+DELETE FROM ratings WHERE car_id NOT IN (SELECT id FROM car);
+UPDATE ratings SET user_id = NULL WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM car_grants WHERE car_id NOT IN (SELECT id FROM car) OR user_id NOT IN (SELECT id FROM users);
+
+-- Values the API never meant to accept. They are reported rather than
+-- guessed at, and the whole migration rolls back, until fixed by hand.
+UPDATE car_history SET type = LOWER(TRIM(type));
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM ratings WHERE stars NOT BETWEEN 1 AND 5) THEN
+        RAISE EXCEPTION 'ratings with stars outside 1 to 5 exist, fix them by hand (SELECT * FROM ratings WHERE stars NOT BETWEEN 1 AND 5) and run the migration again';
+    END IF;
+    IF EXISTS (SELECT 1 FROM car_history WHERE type NOT IN ('accident', 'service')) THEN
+        RAISE EXCEPTION 'car history of types other than accident and service exists, fix it by hand (SELECT * FROM car_history WHERE type NOT IN (''accident'', ''service'')) and run the migration again';
+    END IF;
+END $$;
+
+-- What deleting a car does to its history and ratings, and deleting a user
+-- to their ratings, is a setting (DB_ON_CAR_DELETE) or a choice of the
+-- caller, so the application deletes or detaches them first within the
+-- same transaction. The keys restrict, to catch any path that doesn't.
+-- Grants always go with the car or user.
+ALTER TABLE car_history ADD CONSTRAINT car_history_car_id_fkey FOREIGN KEY (car_id) REFERENCES car (id) ON DELETE RESTRICT;
+ALTER TABLE ratings ADD CONSTRAINT ratings_car_id_fkey FOREIGN KEY (car_id) REFERENCES car (id) ON DELETE RESTRICT;
+ALTER TABLE ratings ADD CONSTRAINT ratings_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT;
+ALTER TABLE car_grants ADD CONSTRAINT car_grants_car_id_fkey FOREIGN KEY (car_id) REFERENCES car (id) ON DELETE CASCADE;
+ALTER TABLE car_grants ADD CONSTRAINT car_grants_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+ALTER TABLE ratings ADD CONSTRAINT ratings_stars_check CHECK (stars BETWEEN 1 AND 5);
+ALTER TABLE car_history ADD CONSTRAINT car_history_type_check CHECK (type IN ('accident', 'service'));
+
+-- Deleting a car or user looks up the rows that reference it
+CREATE INDEX IF NOT EXISTS car_history_car_id_idx ON car_history (car_id);
+CREATE INDEX IF NOT EXISTS ratings_user_id_idx ON ratings (user_id);
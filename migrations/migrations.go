@@ -0,0 +1,12 @@
+// Package migrations embeds the SQL migrations in the binary, so they can
+// be applied wherever it runs. Postgres migrations live in this directory
+// and their SQLite counterparts, with the same versions, in sqlite/. The
+// SQLite ones are only embedded in builds with the sqlite tag.
+package migrations
+
+import "embed"
+
+// Postgres holds the Postgres migrations
+//
+//go:embed *.sql
+var Postgres embed.FS
@@ -0,0 +1,14 @@
+//go:build sqlite
+
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed sqlite/*.sql
+var sqlite embed.FS
+
+// SQLite holds the SQLite migrations
+var SQLite, _ = fs.Sub(sqlite, "sqlite")
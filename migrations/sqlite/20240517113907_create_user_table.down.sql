@@ -0,0 +1,2 @@
+-- migrate:down
+DROP TABLE IF EXISTS users;
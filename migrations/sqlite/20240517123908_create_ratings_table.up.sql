@@ -0,0 +1,8 @@
+-- migrate:up
+CREATE TABLE IF NOT EXISTS ratings (
+    car_id INT NOT NULL,
+    stars INT NOT NULL,
+    user_id INT NOT NULL,
+    comment TEXT,
+    PRIMARY KEY (car_id, user_id)
+);
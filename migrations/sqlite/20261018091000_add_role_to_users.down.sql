@@ -0,0 +1,2 @@
+-- migrate:down
+ALTER TABLE users DROP COLUMN role;
@@ -0,0 +1,3 @@
+-- migrate:down
+DROP TABLE IF EXISTS email_verifications;
+ALTER TABLE users DROP COLUMN verified;
@@ -0,0 +1,11 @@
+-- migrate:up
+-- Accounts that existed before verification was introduced stay usable
+ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE users SET verified = TRUE;
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+    jti VARCHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
@@ -0,0 +1,6 @@
+-- migrate:down
+ALTER TABLE refresh_tokens DROP COLUMN mfa;
+DROP TABLE IF EXISTS totp_recovery_codes;
+ALTER TABLE users DROP COLUMN totp_last_step;
+ALTER TABLE users DROP COLUMN totp_enabled;
+ALTER TABLE users DROP COLUMN totp_secret;
@@ -0,0 +1,18 @@
+-- migrate:up
+-- Access tokens revoked before they expire; rows can go once expires_at passes
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+-- Access tokens of a user issued before revoked_before are invalid
+CREATE TABLE IF NOT EXISTS user_revocations (
+    user_id INTEGER PRIMARY KEY,
+    revoked_before TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS revoked_tokens_created_at_idx ON revoked_tokens (created_at);
+CREATE INDEX IF NOT EXISTS user_revocations_created_at_idx ON user_revocations (created_at);
@@ -0,0 +1,21 @@
+-- migrate:up
+-- Links a provider subject to a local user
+CREATE TABLE IF NOT EXISTS user_identities (
+    id INTEGER PRIMARY KEY,
+    user_id INT NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    UNIQUE (issuer, subject)
+);
+
+-- Authorization requests in flight, consumed by the callback. link_user_id
+-- is set when a logged-in user links the provider to their account.
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    state VARCHAR(64) PRIMARY KEY,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    link_user_id INT,
+    expires_at TIMESTAMP NOT NULL
+);
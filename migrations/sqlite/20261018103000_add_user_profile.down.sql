@@ -0,0 +1,17 @@
+-- migrate:down
+DELETE FROM ratings WHERE user_id IS NULL;
+CREATE TABLE ratings_old (
+    car_id INT NOT NULL,
+    stars INT NOT NULL,
+    user_id INT NOT NULL,
+    comment TEXT,
+    PRIMARY KEY (car_id, user_id)
+);
+INSERT INTO ratings_old (car_id, stars, user_id, comment) SELECT car_id, stars, user_id, comment FROM ratings;
+DROP TABLE ratings;
+ALTER TABLE ratings_old RENAME TO ratings;
+
+ALTER TABLE email_verifications DROP COLUMN email;
+ALTER TABLE users DROP COLUMN pending_email;
+ALTER TABLE users DROP COLUMN email;
+ALTER TABLE users DROP COLUMN display_name;
@@ -0,0 +1,24 @@
+-- migrate:up
+ALTER TABLE users ADD COLUMN display_name VARCHAR(100);
+ALTER TABLE users ADD COLUMN email VARCHAR(255);
+-- Usernames have been email addresses since verification was introduced
+UPDATE users SET email = username WHERE email IS NULL AND username LIKE '%@%';
+
+-- A new address only replaces email once the link mailed to it is opened
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(255);
+-- The address a verification link confirms, or NULL for the account itself
+ALTER TABLE email_verifications ADD COLUMN email VARCHAR(255);
+
+-- Ratings of deleted accounts may be kept anonymously with a NULL user_id.
+-- SQLite can't change a primary key, so the table is rebuilt.
+CREATE TABLE ratings_new (
+    car_id INT NOT NULL,
+    stars INT NOT NULL,
+    user_id INT,
+    comment TEXT,
+    id INTEGER PRIMARY KEY,
+    UNIQUE (car_id, user_id)
+);
+INSERT INTO ratings_new (car_id, stars, user_id, comment) SELECT car_id, stars, user_id, comment FROM ratings;
+DROP TABLE ratings;
+ALTER TABLE ratings_new RENAME TO ratings;
@@ -0,0 +1,7 @@
+-- migrate:down
+DROP TABLE IF EXISTS admin_audit_log;
+
+DROP TRIGGER IF EXISTS users_created_at;
+ALTER TABLE users DROP COLUMN created_at;
+ALTER TABLE users DROP COLUMN password_reset_required;
+ALTER TABLE users DROP COLUMN disabled;
@@ -0,0 +1,24 @@
+-- migrate:up
+ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN password_reset_required BOOLEAN NOT NULL DEFAULT FALSE;
+-- Accounts registered before this migration have no known creation time.
+-- SQLite can't add a column defaulting to the current time, so new rows
+-- get it from a trigger.
+ALTER TABLE users ADD COLUMN created_at TIMESTAMP;
+CREATE TRIGGER IF NOT EXISTS users_created_at AFTER INSERT ON users
+    WHEN NEW.created_at IS NULL
+BEGIN
+    UPDATE users SET created_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id;
+END;
+
+-- Every change an admin makes to a user account
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id INTEGER PRIMARY KEY,
+    admin_id INT NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    target_user_id INT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS admin_audit_log_target_user_id_idx ON admin_audit_log (target_user_id);
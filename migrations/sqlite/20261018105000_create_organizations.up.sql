@@ -0,0 +1,82 @@
+-- migrate:up
+CREATE TABLE IF NOT EXISTS organizations (
+    id INTEGER PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+-- Owners may invite other users; members may only use the organization's data
+CREATE TABLE IF NOT EXISTS org_memberships (
+    org_id INT NOT NULL,
+    user_id INT NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('member', 'owner')),
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS org_invitations (
+    id INTEGER PRIMARY KEY,
+    org_id INT NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    invited_by INT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS org_memberships_user_id_idx ON org_memberships (user_id);
+
+-- Everything that existed before tenancy belongs to one organization that
+-- all existing users are members of; admins own it
+INSERT INTO organizations (id, name) VALUES (1, 'Default') ON CONFLICT (id) DO NOTHING;
+INSERT INTO org_memberships (org_id, user_id, role)
+    SELECT 1, id, CASE WHEN role = 'admin' THEN 'owner' ELSE 'member' END FROM users WHERE TRUE
+    ON CONFLICT DO NOTHING;
+
+-- The organization a user works in, copied into their access tokens
+ALTER TABLE users ADD COLUMN active_org_id INT;
+UPDATE users SET active_org_id = 1 WHERE active_org_id IS NULL;
+
+-- A NOT NULL column without a default can only be added by rebuilding
+CREATE TABLE car_new (
+    id INTEGER PRIMARY KEY,
+    brand VARCHAR(255) NOT NULL,
+    model VARCHAR(255) NOT NULL,
+    year INT NOT NULL,
+    color VARCHAR(50) NOT NULL,
+    body_style VARCHAR(100),
+    engine_size DECIMAL(10,2),
+    weight DECIMAL(10,2),
+    base_price INT,
+    fuel_capacity INT,
+    horsepower INT,
+    torque INT,
+    acceleration INT,
+    top_speed INT,
+    org_id INT NOT NULL
+);
+INSERT INTO car_new SELECT *, 1 FROM car;
+DROP TABLE car;
+ALTER TABLE car_new RENAME TO car;
+CREATE INDEX IF NOT EXISTS car_org_id_idx ON car (org_id);
+
+CREATE TABLE car_history_new (
+    id INTEGER PRIMARY KEY,
+    car_id INT NOT NULL,
+    date TIMESTAMP NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    details TEXT,
+    service_type VARCHAR(50),
+    service_cost DECIMAL(10,2),
+    service_notes TEXT,
+    org_id INT NOT NULL
+);
+INSERT INTO car_history_new SELECT *, 1 FROM car_history;
+DROP TABLE car_history;
+ALTER TABLE car_history_new RENAME TO car_history;
+CREATE INDEX IF NOT EXISTS car_history_org_id_idx ON car_history (org_id);
+
+-- API keys act within the organization they were created in
+ALTER TABLE api_keys ADD COLUMN org_id INT;
+UPDATE api_keys SET org_id = 1 WHERE org_id IS NULL;
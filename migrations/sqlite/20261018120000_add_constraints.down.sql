@@ -0,0 +1,42 @@
+-- migrate:down
+CREATE TABLE car_grants_old (
+    id INTEGER PRIMARY KEY,
+    car_id INT NOT NULL,
+    user_id INT NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('viewer', 'editor')),
+    granted_by INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    UNIQUE (car_id, user_id)
+);
+INSERT INTO car_grants_old SELECT * FROM car_grants;
+DROP TABLE car_grants;
+ALTER TABLE car_grants_old RENAME TO car_grants;
+CREATE INDEX IF NOT EXISTS car_grants_user_id_idx ON car_grants (user_id);
+
+CREATE TABLE ratings_old (
+    car_id INT NOT NULL,
+    stars INT NOT NULL,
+    user_id INT,
+    comment TEXT,
+    id INTEGER PRIMARY KEY,
+    UNIQUE (car_id, user_id)
+);
+INSERT INTO ratings_old SELECT * FROM ratings;
+DROP TABLE ratings;
+ALTER TABLE ratings_old RENAME TO ratings;
+
+CREATE TABLE car_history_old (
+    id INTEGER PRIMARY KEY,
+    car_id INT NOT NULL,
+    date TIMESTAMP NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    details TEXT,
+    service_type VARCHAR(50),
+    service_cost DECIMAL(10,2),
+    service_notes TEXT,
+    org_id INT NOT NULL
+);
+INSERT INTO car_history_old SELECT * FROM car_history;
+DROP TABLE car_history;
+ALTER TABLE car_history_old RENAME TO car_history;
+CREATE INDEX IF NOT EXISTS car_history_org_id_idx ON car_history (org_id);
@@ -0,0 +1,80 @@
+-- migrate:up
+-- Rows left behind by cars and users deleted before there were foreign keys
+DELETE FROM car_history WHERE car_id NOT IN (SELECT id FROM car);
+DELETE FROM ratings WHERE car_id NOT IN (SELECT id FROM car);
+UPDATE ratings SET user_id = NULL WHERE user_id NOT IN (SELECT id FROM users);
+DELETE FROM car_grants WHERE car_id NOT IN (SELECT id FROM car) OR user_id NOT IN (SELECT id FROM users);
+
+-- Values the API never meant to accept. They are reported rather than
+-- guessed at, and the whole migration rolls back, until fixed by hand.
+-- SQLite only raises errors from triggers, so the checks insert into a
+-- table whose trigger fails.
+UPDATE car_history SET type = LOWER(TRIM(type));
+CREATE TEMP TABLE migration_checks (problem TEXT NOT NULL);
+CREATE TEMP TRIGGER migration_checks_fail BEFORE INSERT ON migration_checks
+BEGIN
+    SELECT CASE NEW.problem
+        WHEN 'stars' THEN RAISE(ABORT, 'ratings with stars outside 1 to 5 exist, fix them by hand (SELECT * FROM ratings WHERE stars NOT BETWEEN 1 AND 5) and run the migration again')
+        WHEN 'type' THEN RAISE(ABORT, 'car history of types other than accident and service exists, fix it by hand (SELECT * FROM car_history WHERE type NOT IN (''accident'', ''service'')) and run the migration again')
+    END;
+END;
+INSERT INTO migration_checks SELECT 'stars' WHERE EXISTS (SELECT 1 FROM ratings WHERE stars NOT BETWEEN 1 AND 5);
+INSERT INTO migration_checks SELECT 'type' WHERE EXISTS (SELECT 1 FROM car_history WHERE type NOT IN ('accident', 'service'));
+DROP TABLE migration_checks;
+
+-- SQLite can't add constraints to a table, so the tables are rebuilt.
+-- What deleting a car does to its history and ratings, and deleting a user
+-- to their ratings, is a setting (DB_ON_CAR_DELETE) or a choice of the
+-- caller, so the application deletes or detaches them first within the
+-- same transaction. The keys restrict, to catch any path that doesn't.
+-- Grants always go with the car or user.
+CREATE TABLE car_history_new (
+    id INTEGER PRIMARY KEY,
+    car_id INT NOT NULL,
+    date TIMESTAMP NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    details TEXT,
+    service_type VARCHAR(50),
+    service_cost DECIMAL(10,2),
+    service_notes TEXT,
+    org_id INT NOT NULL,
+    CONSTRAINT car_history_car_id_fkey FOREIGN KEY (car_id) REFERENCES car (id) ON DELETE RESTRICT,
+    CONSTRAINT car_history_type_check CHECK (type IN ('accident', 'service'))
+);
+INSERT INTO car_history_new SELECT * FROM car_history;
+DROP TABLE car_history;
+ALTER TABLE car_history_new RENAME TO car_history;
+CREATE INDEX IF NOT EXISTS car_history_org_id_idx ON car_history (org_id);
+CREATE INDEX IF NOT EXISTS car_history_car_id_idx ON car_history (car_id);
+
+CREATE TABLE ratings_new (
+    car_id INT NOT NULL,
+    stars INT NOT NULL,
+    user_id INT,
+    comment TEXT,
+    id INTEGER PRIMARY KEY,
+    CONSTRAINT ratings_car_id_user_id_key UNIQUE (car_id, user_id),
+    CONSTRAINT ratings_car_id_fkey FOREIGN KEY (car_id) REFERENCES car (id) ON DELETE RESTRICT,
+    CONSTRAINT ratings_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT,
+    CONSTRAINT ratings_stars_check CHECK (stars BETWEEN 1 AND 5)
+);
+INSERT INTO ratings_new SELECT * FROM ratings;
+DROP TABLE ratings;
+ALTER TABLE ratings_new RENAME TO ratings;
+CREATE INDEX IF NOT EXISTS ratings_user_id_idx ON ratings (user_id);
+
+CREATE TABLE car_grants_new (
+    id INTEGER PRIMARY KEY,
+    car_id INT NOT NULL,
+    user_id INT NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('viewer', 'editor')),
+    granted_by INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    UNIQUE (car_id, user_id),
+    CONSTRAINT car_grants_car_id_fkey FOREIGN KEY (car_id) REFERENCES car (id) ON DELETE CASCADE,
+    CONSTRAINT car_grants_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO car_grants_new SELECT * FROM car_grants;
+DROP TABLE car_grants;
+ALTER TABLE car_grants_new RENAME TO car_grants;
+CREATE INDEX IF NOT EXISTS car_grants_user_id_idx ON car_grants (user_id);
@@ -0,0 +1,491 @@
+// Package config loads the server configuration. Every setting has a
+// default and can be overridden, from lowest to highest precedence, by:
+//
+//  1. a YAML (.yaml, .yml) or TOML (.toml) file named by -config or CONFIG_FILE
+//  2. environment variables
+//  3. command line flags
+//
+// Secrets such as the database password, JWT keys and the OIDC client and
+// SMTP passwords should come from the file or the environment; they have no
+// flags so they never show up in ps.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete server configuration
+type Config struct {
+	ListenAddr      string   `yaml:"listen_addr" toml:"listen_addr"`
+	PublicURL       string   `yaml:"public_url" toml:"public_url"`
+	AdminRequire2FA bool     `yaml:"admin_require_2fa" toml:"admin_require_2fa"`
+	Database        Database `yaml:"database" toml:"database"`
+	Tokens          Tokens   `yaml:"tokens" toml:"tokens"`
+	CORS            CORS     `yaml:"cors" toml:"cors"`
+	OIDC            OIDC     `yaml:"oidc" toml:"oidc"`
+	Mail            Mail     `yaml:"mail" toml:"mail"`
+	Password        Password `yaml:"password" toml:"password"`
+
+	// PrintOnly asks the caller to print the configuration and exit
+	PrintOnly bool `yaml:"-" toml:"-"`
+	// Args are the arguments left after the flags, such as a subcommand
+	Args []string `yaml:"-" toml:"-"`
+}
+
+// Database configures the connection pool. DSNs starting with sqlite://
+// name a SQLite database file, which needs a build with the sqlite tag;
+// anything else is passed to Postgres.
+type Database struct {
+	DSN             string        `yaml:"dsn" toml:"dsn"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+
+	// QueryTimeout is the deadline for the database work of a request.
+	// RouteTimeouts overrides it per route, keyed like "GET /api/cars".
+	QueryTimeout  time.Duration            `yaml:"query_timeout" toml:"query_timeout"`
+	RouteTimeouts map[string]time.Duration `yaml:"route_timeouts" toml:"route_timeouts"`
+
+	// AutoMigrate applies pending migrations at startup. Production setups
+	// can turn it off and run the migrate subcommand when deploying.
+	AutoMigrate bool `yaml:"auto_migrate" toml:"auto_migrate"`
+
+	// OnCarDelete is what deleting a car does to its history and ratings:
+	// "cascade" deletes them too, "restrict" refuses while any are left
+	OnCarDelete string `yaml:"on_car_delete" toml:"on_car_delete"`
+}
+
+// Tokens configures token lifetimes and the JWT keys, see token.InitKeys
+// for the format of Keys
+type Tokens struct {
+	AccessTTL        time.Duration `yaml:"access_ttl" toml:"access_ttl"`
+	RefreshTTL       time.Duration `yaml:"refresh_ttl" toml:"refresh_ttl"`
+	PasswordResetTTL time.Duration `yaml:"password_reset_ttl" toml:"password_reset_ttl"`
+	InvitationTTL    time.Duration `yaml:"invitation_ttl" toml:"invitation_ttl"`
+	Keys             string        `yaml:"keys" toml:"keys"`
+	SigningKey       string        `yaml:"signing_key" toml:"signing_key"`
+	Secret           string        `yaml:"secret" toml:"secret"`
+}
+
+// CORS lists the origins browsers may call the API from; "*" allows any
+type CORS struct {
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+}
+
+// OIDC configures single sign-on through an OpenID Connect provider. SSO is
+// off unless Issuer is set. RedirectURL defaults to the callback under the
+// public URL.
+type OIDC struct {
+	Issuer       string   `yaml:"issuer" toml:"issuer"`
+	ClientID     string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret string   `yaml:"client_secret" toml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url" toml:"redirect_url"`
+	Scopes       []string `yaml:"scopes" toml:"scopes"`
+}
+
+// Mail configures how emails are delivered: through the SMTP server at
+// SMTPAddr, as files written to Dir, or, with neither, to the log
+type Mail struct {
+	From         string `yaml:"from" toml:"from"`
+	SMTPAddr     string `yaml:"smtp_addr" toml:"smtp_addr"`
+	SMTPUsername string `yaml:"smtp_username" toml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password" toml:"smtp_password"`
+	Dir          string `yaml:"dir" toml:"dir"`
+}
+
+// Password configures the password policy. BlocklistFile lists passwords,
+// one per line, refused on top of the built-in list of common ones.
+type Password struct {
+	MinLength     int    `yaml:"min_length" toml:"min_length"`
+	BlocklistFile string `yaml:"blocklist_file" toml:"blocklist_file"`
+}
+
+// Defaults returns the configuration used when nothing is set. There is no
+// default DSN, the database has to be configured explicitly.
+func Defaults() Config {
+	return Config{
+		ListenAddr:      "0.0.0.0:8080",
+		PublicURL:       "http://localhost:8080",
+		AdminRequire2FA: true,
+		Database: Database{
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			QueryTimeout:    10 * time.Second,
+			AutoMigrate:     true,
+			OnCarDelete:     "cascade",
+		},
+		Tokens: Tokens{
+			AccessTTL:        15 * time.Minute,
+			RefreshTTL:       30 * 24 * time.Hour,
+			PasswordResetTTL: time.Hour,
+			InvitationTTL:    7 * 24 * time.Hour,
+		},
+		CORS:     CORS{AllowedOrigins: []string{"*"}},
+		OIDC:     OIDC{Scopes: []string{"openid", "email", "profile"}},
+		Mail:     Mail{From: "no-reply@localhost"},
+		Password: Password{MinLength: 8},
+	}
+}
+
+// setting is one value that can come from the environment or a flag
+type setting struct {
+	flag, env, usage string
+	set              func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"listen", "LISTEN_ADDR", "host:port to listen on", stringVar(func(c *Config) *string { return &c.ListenAddr })},
+	{"public-url", "APP_PUBLIC_URL", "externally reachable base URL used in emails", stringVar(func(c *Config) *string { return &c.PublicURL })},
+	{"admin-require-2fa", "ADMIN_REQUIRE_2FA", "require a second factor for admin actions", boolVar(func(c *Config) *bool { return &c.AdminRequire2FA })},
+	{"", "DATABASE_URL", "", stringVar(func(c *Config) *string { return &c.Database.DSN })},
+	{"db-max-open-conns", "DB_MAX_OPEN_CONNS", "maximum open database connections, 0 for unlimited", intVar(func(c *Config) *int { return &c.Database.MaxOpenConns })},
+	{"db-max-idle-conns", "DB_MAX_IDLE_CONNS", "maximum idle database connections", intVar(func(c *Config) *int { return &c.Database.MaxIdleConns })},
+	{"db-conn-max-lifetime", "DB_CONN_MAX_LIFETIME", "maximum lifetime of a database connection, 0 for unlimited", durationVar(func(c *Config) *time.Duration { return &c.Database.ConnMaxLifetime })},
+	{"db-query-timeout", "DB_QUERY_TIMEOUT", "deadline for the database work of a request, 0 for none", durationVar(func(c *Config) *time.Duration { return &c.Database.QueryTimeout })},
+	{"db-route-timeouts", "DB_ROUTE_TIMEOUTS", `per-route query timeouts, e.g. "GET /api/cars=30s,GET /api/carhistory=30s"`, routeTimeoutsVar(func(c *Config) *map[string]time.Duration { return &c.Database.RouteTimeouts })},
+	{"db-auto-migrate", "DB_AUTO_MIGRATE", "apply pending database migrations at startup", boolVar(func(c *Config) *bool { return &c.Database.AutoMigrate })},
+	{"db-on-car-delete", "DB_ON_CAR_DELETE", `what deleting a car does to its history and ratings, "cascade" or "restrict"`, stringVar(func(c *Config) *string { return &c.Database.OnCarDelete })},
+	{"access-token-ttl", "ACCESS_TOKEN_TTL", "lifetime of access tokens", durationVar(func(c *Config) *time.Duration { return &c.Tokens.AccessTTL })},
+	{"refresh-token-ttl", "REFRESH_TOKEN_TTL", "lifetime of refresh tokens", durationVar(func(c *Config) *time.Duration { return &c.Tokens.RefreshTTL })},
+	{"password-reset-ttl", "PASSWORD_RESET_TTL", "lifetime of password reset links", durationVar(func(c *Config) *time.Duration { return &c.Tokens.PasswordResetTTL })},
+	{"invitation-ttl", "ORG_INVITATION_TTL", "lifetime of organization invitations", durationVar(func(c *Config) *time.Duration { return &c.Tokens.InvitationTTL })},
+	{"", "JWT_KEYS", "", stringVar(func(c *Config) *string { return &c.Tokens.Keys })},
+	{"jwt-signing-key", "JWT_SIGNING_KEY", "kid of the key used to sign new tokens", stringVar(func(c *Config) *string { return &c.Tokens.SigningKey })},
+	{"", "JWT_SECRET", "", stringVar(func(c *Config) *string { return &c.Tokens.Secret })},
+	{"cors-origins", "CORS_ALLOWED_ORIGINS", "comma separated origins allowed by CORS", listVar(func(c *Config) *[]string { return &c.CORS.AllowedOrigins })},
+	{"oidc-issuer", "OIDC_ISSUER", "issuer URL of the OpenID Connect provider, empty to disable SSO", stringVar(func(c *Config) *string { return &c.OIDC.Issuer })},
+	{"oidc-client-id", "OIDC_CLIENT_ID", "client ID registered at the OpenID Connect provider", stringVar(func(c *Config) *string { return &c.OIDC.ClientID })},
+	{"", "OIDC_CLIENT_SECRET", "", stringVar(func(c *Config) *string { return &c.OIDC.ClientSecret })},
+	{"oidc-redirect-url", "OIDC_REDIRECT_URL", "callback URL registered at the provider, by default under the public URL", stringVar(func(c *Config) *string { return &c.OIDC.RedirectURL })},
+	{"oidc-scopes", "OIDC_SCOPES", "space separated scopes to request from the provider", fieldsVar(func(c *Config) *[]string { return &c.OIDC.Scopes })},
+	{"mail-from", "MAIL_FROM", "sender address of emails", stringVar(func(c *Config) *string { return &c.Mail.From })},
+	{"smtp-addr", "SMTP_ADDR", "host:port of the SMTP server to send emails through", stringVar(func(c *Config) *string { return &c.Mail.SMTPAddr })},
+	{"smtp-username", "SMTP_USERNAME", "SMTP username, empty to send without authentication", stringVar(func(c *Config) *string { return &c.Mail.SMTPUsername })},
+	{"", "SMTP_PASSWORD", "", stringVar(func(c *Config) *string { return &c.Mail.SMTPPassword })},
+	{"mail-dir", "MAIL_DIR", "directory to write emails to instead of sending them", stringVar(func(c *Config) *string { return &c.Mail.Dir })},
+	{"password-min-length", "PASSWORD_MIN_LENGTH", "minimum length of passwords in characters", intVar(func(c *Config) *int { return &c.Password.MinLength })},
+	{"password-blocklist-file", "PASSWORD_BLOCKLIST_FILE", "file of passwords to refuse, one per line", stringVar(func(c *Config) *string { return &c.Password.BlocklistFile })},
+}
+
+// Load builds the configuration from args, the environment and the
+// configuration file, then validates it
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("car_project", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file")
+	printOnly := fs.Bool("print-config", false, "print the effective configuration and exit")
+
+	// Flag values are kept aside and applied after the file and environment
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		name := s.flag
+		fs.Func(name, s.usage, func(v string) error {
+			flagValues[name] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Defaults()
+	if *path != "" {
+		if err := loadFile(&cfg, *path); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(&cfg, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flagValues[s.flag]; ok && s.flag != "" {
+			if err := s.set(&cfg, v); err != nil {
+				return nil, fmt.Errorf("-%s: %w", s.flag, err)
+			}
+		}
+	}
+	cfg.PrintOnly = *printOnly
+	cfg.Args = fs.Args()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// loadFile decodes the file over cfg, picking the format from its extension
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(strings.NewReader(string(data)))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parsing %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// Validate reports the first setting that the server could not start with
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		return fmt.Errorf("listen address %q must be host:port", c.ListenAddr)
+	}
+	if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("public URL %q must be an absolute http(s) URL", c.PublicURL)
+	}
+	c.PublicURL = strings.TrimSuffix(c.PublicURL, "/")
+
+	if c.Database.DSN == "" {
+		return errors.New("database DSN is required, set DATABASE_URL or database.dsn")
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 || c.Database.ConnMaxLifetime < 0 {
+		return errors.New("database pool settings must not be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		return errors.New("database max idle connections must not exceed max open connections")
+	}
+	if c.Database.QueryTimeout < 0 {
+		return errors.New("database query timeout must not be negative")
+	}
+	for route, timeout := range c.Database.RouteTimeouts {
+		method, path, ok := strings.Cut(route, " ")
+		if !ok || method == "" || method != strings.ToUpper(method) || !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("route timeout key %q must look like \"GET /api/cars\"", route)
+		}
+		if timeout < 0 {
+			return fmt.Errorf("route timeout for %q must not be negative", route)
+		}
+	}
+	if c.Database.OnCarDelete != "cascade" && c.Database.OnCarDelete != "restrict" {
+		return fmt.Errorf("database on_car_delete %q must be cascade or restrict", c.Database.OnCarDelete)
+	}
+
+	ttls := map[string]time.Duration{
+		"access token TTL":   c.Tokens.AccessTTL,
+		"refresh token TTL":  c.Tokens.RefreshTTL,
+		"password reset TTL": c.Tokens.PasswordResetTTL,
+		"invitation TTL":     c.Tokens.InvitationTTL,
+	}
+	for name, ttl := range ttls {
+		if ttl <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	if c.Tokens.AccessTTL >= c.Tokens.RefreshTTL {
+		return errors.New("access token TTL must be shorter than refresh token TTL")
+	}
+
+	if len(c.CORS.AllowedOrigins) == 0 {
+		return errors.New("at least one CORS origin is required, use * to allow any")
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("CORS origin %q must look like https://example.com", origin)
+		}
+	}
+
+	if c.OIDC.Issuer != "" {
+		if !isHTTPURL(c.OIDC.Issuer) {
+			return fmt.Errorf("OIDC issuer %q must be an absolute http(s) URL", c.OIDC.Issuer)
+		}
+		if c.OIDC.ClientID == "" {
+			return errors.New("OIDC client ID is required when an issuer is set")
+		}
+		if c.OIDC.RedirectURL != "" && !isHTTPURL(c.OIDC.RedirectURL) {
+			return fmt.Errorf("OIDC redirect URL %q must be an absolute http(s) URL", c.OIDC.RedirectURL)
+		}
+		if len(c.OIDC.Scopes) == 0 {
+			return errors.New("OIDC scopes must not be empty")
+		}
+	}
+
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		return fmt.Errorf("mail sender %q is not an email address", c.Mail.From)
+	}
+	if c.Mail.SMTPAddr != "" {
+		if c.Mail.Dir != "" {
+			return errors.New("set either the SMTP address or the mail directory, not both")
+		}
+		if _, _, err := net.SplitHostPort(c.Mail.SMTPAddr); err != nil {
+			return fmt.Errorf("SMTP address %q must be host:port", c.Mail.SMTPAddr)
+		}
+	}
+
+	if c.Password.MinLength < 1 {
+		return errors.New("password minimum length must be positive")
+	}
+	return nil
+}
+
+// isHTTPURL reports whether s is an absolute http or https URL
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// redacted replaces secret values when printing the configuration
+const redacted = "REDACTED"
+
+var dsnPassword = regexp.MustCompile(`(password=)('[^']*'|\S+)`)
+
+// Redacted returns a copy of c that is safe to log
+func (c Config) Redacted() Config {
+	c.Database.DSN = redactDSN(c.Database.DSN)
+	if c.Tokens.Secret != "" {
+		c.Tokens.Secret = redacted
+	}
+	if c.OIDC.ClientSecret != "" {
+		c.OIDC.ClientSecret = redacted
+	}
+	if c.Mail.SMTPPassword != "" {
+		c.Mail.SMTPPassword = redacted
+	}
+	c.CORS.AllowedOrigins = append([]string(nil), c.CORS.AllowedOrigins...)
+	c.OIDC.Scopes = append([]string(nil), c.OIDC.Scopes...)
+	return c
+}
+
+// redactDSN hides the password in URL and key=value style DSNs
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		return u.String()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
+
+// Print writes the configuration with secrets redacted as YAML
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func stringVar(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func intVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func boolVar(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func durationVar(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 15m or 720h", v)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func routeTimeoutsVar(field func(*Config) *map[string]time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		routes := map[string]time.Duration{}
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			route, value, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("%q is not ROUTE=DURATION", item)
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("%q is not a duration like 30s", value)
+			}
+			routes[strings.TrimSpace(route)] = d
+		}
+		*field(c) = routes
+		return nil
+	}
+}
+
+func listVar(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
+
+func fieldsVar(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = strings.Fields(v)
+		return nil
+	}
+}
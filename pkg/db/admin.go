@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"car_project/pkg/model"
+)
+
+// likeEscaper makes user input match literally inside a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// ListUsers pages through users ordered by ID. A non-empty search matches
+// the username, display name or email, ignoring case.
+func (s *SQLStore) ListUsers(ctx context.Context, page, limit int, search string) ([]model.User, error) {
+	query := "SELECT " + userColumns + " FROM users"
+	args := []interface{}{limit, (page - 1) * limit}
+	if search != "" {
+		match := " " + s.dialect.iLike + ` $3 ESCAPE '\'`
+		query += " WHERE username" + match + " OR display_name" + match + " OR email" + match
+		args = append(args, "%"+likeEscaper.Replace(search)+"%")
+	}
+	query += " ORDER BY id LIMIT $1 OFFSET $2"
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []model.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// recordAdminAction adds an entry to the admin audit log
+func recordAdminAction(ctx context.Context, tx execer, adminID int, action string, targetUserID int, details string) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO admin_audit_log (admin_id, action, target_user_id, details) VALUES ($1, $2, $3, $4)",
+		adminID, action, targetUserID, details)
+	return err
+}
+
+// SetUserDisabled disables or enables a user on behalf of an admin.
+// Disabling also revokes the user's refresh tokens. It returns false if
+// there is no such user.
+func (s *SQLStore) SetUserDisabled(ctx context.Context, adminID, userID int, disabled bool) (bool, error) {
+	var found bool
+	err := s.inTx(ctx, func(tx *SQLStore) error {
+		res, err := tx.conn().ExecContext(ctx, "UPDATE users SET disabled = $1 WHERE id = $2", disabled, userID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+
+		action := model.AuditUserEnabled
+		if disabled {
+			action = model.AuditUserDisabled
+			if _, err := tx.conn().ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID); err != nil {
+				return err
+			}
+		}
+		found = true
+		return recordAdminAction(ctx, tx.conn(), adminID, action, userID, "")
+	})
+	return found, err
+}
+
+// SetUserRole changes a user's role on behalf of an admin. It returns false
+// if there is no such user.
+func (s *SQLStore) SetUserRole(ctx context.Context, adminID, userID int, role model.Role) (bool, error) {
+	var found bool
+	err := s.inTx(ctx, func(tx *SQLStore) error {
+		var previous model.Role
+		err := tx.conn().QueryRowContext(ctx, "SELECT role FROM users WHERE id = $1"+tx.dialect.forUpdate, userID).Scan(&previous)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.conn().ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", role, userID); err != nil {
+			return err
+		}
+		found = true
+		details := fmt.Sprintf("%s -> %s", previous, role)
+		return recordAdminAction(ctx, tx.conn(), adminID, model.AuditUserRoleChanged, userID, details)
+	})
+	return found, err
+}
+
+// RequirePasswordReset refuses further logins of a user until they reset
+// their password, and revokes their refresh tokens, on behalf of an admin.
+// It returns false if there is no such user.
+func (s *SQLStore) RequirePasswordReset(ctx context.Context, adminID, userID int) (bool, error) {
+	var found bool
+	err := s.inTx(ctx, func(tx *SQLStore) error {
+		res, err := tx.conn().ExecContext(ctx, "UPDATE users SET password_reset_required = TRUE WHERE id = $1", userID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+
+		if _, err := tx.conn().ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID); err != nil {
+			return err
+		}
+		found = true
+		return recordAdminAction(ctx, tx.conn(), adminID, model.AuditUserPasswordReset, userID, "")
+	})
+	return found, err
+}
+
+// GetAuditLog pages through the admin audit log, newest first. A non-zero
+// targetUserID limits it to actions on that user.
+func (s *SQLStore) GetAuditLog(ctx context.Context, page, limit, targetUserID int) ([]model.AuditEntry, error) {
+	query := "SELECT id, admin_id, action, target_user_id, details, created_at FROM admin_audit_log"
+	args := []interface{}{limit, (page - 1) * limit}
+	if targetUserID != 0 {
+		query += " WHERE target_user_id = $3"
+		args = append(args, targetUserID)
+	}
+	query += " ORDER BY id DESC LIMIT $1 OFFSET $2"
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []model.AuditEntry{}
+	for rows.Next() {
+		var e model.AuditEntry
+		if err := rows.Scan(&e.ID, &e.AdminID, &e.Action, &e.TargetUserID, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
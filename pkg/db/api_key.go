@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+
+	"car_project/pkg/model"
+
+	"github.com/lib/pq"
+)
+
+const apiKeyColumns = "id, user_id, COALESCE(org_id, 0), name, prefix, key_hash, scopes, created_at, last_used_at, revoked_at"
+
+// CreateAPIKey stores a new API key and returns it with its ID and creation time
+func (s *SQLStore) CreateAPIKey(ctx context.Context, key model.APIKey) (*model.APIKey, error) {
+	err := s.conn().QueryRowContext(ctx, "INSERT INTO api_keys (user_id, org_id, name, prefix, key_hash, scopes) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at",
+		key.UserID, key.OrgID, key.Name, key.Prefix, key.KeyHash, pq.Array(key.Scopes)).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAPIKeysByUser lists a user's API keys, including revoked ones
+func (s *SQLStore) GetAPIKeysByUser(ctx context.Context, userID int) ([]model.APIKey, error) {
+	rows, err := s.conn().QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []model.APIKey{}
+	for rows.Next() {
+		var k model.APIKey
+		if err := rows.Scan(&k.ID, &k.UserID, &k.OrgID, &k.Name, &k.Prefix, &k.KeyHash, pq.Array(&k.Scopes), &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// UseAPIKey looks up an active key by hash and records that it was used
+func (s *SQLStore) UseAPIKey(ctx context.Context, hash string) (*model.APIKey, error) {
+	var k model.APIKey
+	err := s.conn().QueryRowContext(ctx, "UPDATE api_keys SET last_used_at = NOW() WHERE key_hash = $1 AND revoked_at IS NULL RETURNING "+apiKeyColumns, hash).
+		Scan(&k.ID, &k.UserID, &k.OrgID, &k.Name, &k.Prefix, &k.KeyHash, pq.Array(&k.Scopes), &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// RevokeAPIKey revokes one of the user's keys. It returns false if the user
+// has no active key with that ID.
+func (s *SQLStore) RevokeAPIKey(ctx context.Context, userID, id int) (bool, error) {
+	res, err := s.conn().ExecContext(ctx, "UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL", id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
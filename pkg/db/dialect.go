@@ -0,0 +1,27 @@
+package db
+
+// dialect is the SQL that differs between the databases SQLStore runs on.
+// Placeholders are $N in both: SQLite reads them as named parameters, and
+// the driver in sqlite.go binds them by number as Postgres does.
+type dialect struct {
+	// forUpdate locks the rows a SELECT reads until the transaction ends.
+	// SQLite transactions take the write lock on the whole database when
+	// they begin, so there is nothing more to lock.
+	forUpdate string
+	// iLike matches a LIKE pattern ignoring case. SQLite's LIKE already
+	// ignores ASCII case.
+	iLike string
+}
+
+var (
+	postgresDialect = dialect{forUpdate: " FOR UPDATE", iLike: "ILIKE"}
+	sqliteDialect   = dialect{forUpdate: "", iLike: "LIKE"}
+)
+
+// dialectFor returns the dialect of the named driver
+func dialectFor(driverName string) dialect {
+	if driverName == sqliteDriver {
+		return sqliteDialect
+	}
+	return postgresDialect
+}
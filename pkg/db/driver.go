@@ -0,0 +1,49 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"strings"
+
+	"github.com/golang-migrate/migrate/database"
+)
+
+// sqliteDriver is the name the SQLite driver is registered under. The stores
+// write what differs from Postgres in its dialect, see dialect.go.
+const sqliteDriver = "sqlite3_pg"
+
+// sqlite is what the SQLite backend adds to the package. go-sqlite3 needs
+// cgo, so sqlite.go sets it only in builds with the sqlite tag; it is nil
+// otherwise and only Postgres is available.
+var sqlite *sqliteBackend
+
+type sqliteBackend struct {
+	migrations      fs.FS
+	migrationDriver func(conn *sql.DB) (database.Driver, error)
+	// busy reports whether an error means the database was locked
+	busy func(err error) bool
+	// constraint classifies constraint violations, ok is false for other errors
+	constraint func(err error) (kind ConstraintKind, name string, ok bool)
+}
+
+// driverFor picks the database driver and data source from the DSN scheme.
+// sqlite:// DSNs name a database file, anything else goes to Postgres.
+func driverFor(dsn string) (driverName, dataSource string, err error) {
+	for _, scheme := range []string{"sqlite://", "sqlite3://"} {
+		if strings.HasPrefix(dsn, scheme) {
+			if sqlite == nil {
+				return "", "", errors.New("this binary was built without SQLite support, rebuild it with -tags sqlite")
+			}
+			path := strings.TrimPrefix(dsn, scheme)
+			sep := "?"
+			if strings.Contains(path, "?") {
+				sep = "&"
+			}
+			// Concurrent requests wait for the write lock instead of failing,
+			// and foreign keys are enforced as in Postgres
+			return sqliteDriver, "file:" + path + sep + "_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate&_foreign_keys=on", nil
+		}
+	}
+	return "postgres", dsn, nil
+}
@@ -0,0 +1,27 @@
+package db
+
+import "testing"
+
+func TestDriverFor(t *testing.T) {
+	tests := []struct {
+		dsn, driver, source string
+		sqlite              bool
+	}{
+		{"postgres://u@localhost/cars", "postgres", "postgres://u@localhost/cars", false},
+		{"sqlite:///tmp/car.db", sqliteDriver, "file:/tmp/car.db?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate&_foreign_keys=on", true},
+		{"sqlite3://car.db?cache=shared", sqliteDriver, "file:car.db?cache=shared&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate&_foreign_keys=on", true},
+	}
+	for _, tt := range tests {
+		driver, source, err := driverFor(tt.dsn)
+		if tt.sqlite && sqlite == nil {
+			// Built without the sqlite tag
+			if err == nil {
+				t.Errorf("driverFor(%q) = %q, %q, want an error without SQLite support", tt.dsn, driver, source)
+			}
+			continue
+		}
+		if err != nil || driver != tt.driver || source != tt.source {
+			t.Errorf("driverFor(%q) = %q, %q, %v, want %q, %q", tt.dsn, driver, source, err, tt.driver, tt.source)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/lib/pq"
+)
+
+// IsUnavailable reports whether err means the database could not be reached
+// or was too busy to run the call, rather than that the call itself failed
+func IsUnavailable(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Connection exceptions, too many connections, shutting down
+		return pqErr.Code.Class() == "08" || pqErr.Code == "53300" || pqErr.Code.Class() == "57" && pqErr.Code != "57014"
+	}
+
+	return sqlite != nil && sqlite.busy(err)
+}
+
+// IsSerializationFailure reports whether err means a transaction was rolled
+// back because of concurrent ones and may succeed if it is run again. On
+// SQLite that is another connection holding the lock past the busy timeout.
+func IsSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// serialization_failure, deadlock_detected
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	return sqlite != nil && sqlite.busy(err)
+}
+
+// ConstraintKind is the kind of constraint a write violated
+type ConstraintKind int
+
+const (
+	// ForeignKey means the row refers to one that doesn't exist
+	ForeignKey ConstraintKind = iota
+	// Check means a value is out of the allowed range
+	Check
+	// Unique means another row already has the value
+	Unique
+	// Referenced means other rows still refer to the one being deleted
+	Referenced
+)
+
+// ConstraintError is a write the database refused because it would break a
+// constraint. Field is the JSON name of the offending field, if known.
+type ConstraintError struct {
+	Kind    ConstraintKind
+	Field   string
+	Message string
+	Err     error
+}
+
+func (e *ConstraintError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + " " + e.Message
+}
+
+func (e *ConstraintError) Unwrap() error { return e.Err }
+
+// ErrCarInUse is returned when deleting a car that still has history or
+// ratings while car deletes are restricted
+var ErrCarInUse = &ConstraintError{Kind: Referenced, Message: "car still has history or ratings, delete them first"}
+
+// constraints describes the constraints of the schema by the name Postgres
+// reports them under. SQLite names unique constraints by their columns.
+var constraints = map[string]struct{ field, message string }{
+	"ratings_stars_check":             {"stars", "must be between 1 and 5"},
+	"car_history_type_check":          {"type", `must be "accident" or "service"`},
+	"car_history_car_id_fkey":         {"car_id", "does not exist"},
+	"ratings_car_id_fkey":             {"car_id", "does not exist"},
+	"ratings_user_id_fkey":            {"user_id", "does not exist"},
+	"car_grants_car_id_fkey":          {"car_id", "does not exist"},
+	"car_grants_user_id_fkey":         {"user_id", "does not exist"},
+	"ratings_car_id_user_id_key":      {"car_id", "is already rated by this user"},
+	"ratings.car_id, ratings.user_id": {"car_id", "is already rated by this user"},
+}
+
+// AsConstraintError returns the constraint err violated, or nil if it isn't
+// a constraint violation
+func AsConstraintError(err error) *ConstraintError {
+	var ce *ConstraintError
+	if errors.As(err, &ce) {
+		return ce
+	}
+
+	var kind ConstraintKind
+	var name string
+	var pqErr *pq.Error
+	var ok bool
+	switch {
+	case errors.As(err, &pqErr):
+		switch pqErr.Code {
+		case "23503": // foreign_key_violation
+			kind = ForeignKey
+		case "23514": // check_violation
+			kind = Check
+		case "23505": // unique_violation
+			kind = Unique
+		default:
+			return nil
+		}
+		name = pqErr.Constraint
+	case sqlite != nil:
+		if kind, name, ok = sqlite.constraint(err); !ok {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	ce = NewConstraintError(kind, name)
+	ce.Err = err
+	return ce
+}
+
+// deleteError classifies an error from a DELETE. A foreign key violation
+// there means other rows still refer to the deleted one, not that a row
+// refers to a missing one, but Postgres and SQLite report both alike.
+func deleteError(err error) error {
+	if ce := AsConstraintError(err); ce != nil && ce.Kind == ForeignKey {
+		ce = NewConstraintError(Referenced, "")
+		ce.Err = err
+		return ce
+	}
+	return err
+}
+
+// NewConstraintError describes a violation of the named constraint, for
+// stores that check the constraints themselves
+func NewConstraintError(kind ConstraintKind, constraint string) *ConstraintError {
+	ce := &ConstraintError{Kind: kind}
+	if c, ok := constraints[constraint]; ok {
+		ce.Field, ce.Message = c.field, c.message
+		return ce
+	}
+	switch kind {
+	case ForeignKey:
+		ce.Message = "refers to a row that does not exist"
+	case Check:
+		ce.Message = "a value is out of range"
+	case Unique:
+		ce.Message = "already exists"
+	case Referenced:
+		ce.Message = "is still referred to by other rows"
+	}
+	return ce
+}
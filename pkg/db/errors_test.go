@@ -0,0 +1,41 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestAsConstraintError(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		kind  ConstraintKind
+		field string
+	}{
+		{"foreign key", &pq.Error{Code: "23503", Constraint: "ratings_car_id_fkey"}, ForeignKey, "car_id"},
+		{"check", &pq.Error{Code: "23514", Constraint: "ratings_stars_check"}, Check, "stars"},
+		{"unique", &pq.Error{Code: "23505", Constraint: "ratings_car_id_user_id_key"}, Unique, "car_id"},
+		{"unknown constraint", &pq.Error{Code: "23505", Constraint: "users_username_key"}, Unique, ""},
+		{"referenced on delete", deleteError(&pq.Error{Code: "23503", Constraint: "ratings_car_id_fkey"}), Referenced, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ce := AsConstraintError(tt.err)
+			if ce == nil || ce.Kind != tt.kind || ce.Field != tt.field {
+				t.Fatalf("AsConstraintError = %+v, want kind %d on field %q", ce, tt.kind, tt.field)
+			}
+		})
+	}
+
+	for _, err := range []error{
+		errors.New("boom"),
+		&pq.Error{Code: "40001"},
+		deleteError(&pq.Error{Code: "40001"}),
+	} {
+		if ce := AsConstraintError(err); ce != nil {
+			t.Errorf("AsConstraintError(%v) = %+v, want nil", err, ce)
+		}
+	}
+}
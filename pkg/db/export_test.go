@@ -0,0 +1,7 @@
+package db
+
+import "testing"
+
+// NewTestSQLStore returns stores on a fresh SQLite database for the tests of
+// package db_test. It is nil without the sqlite build tag.
+var NewTestSQLStore func(t *testing.T, onCarDelete CarDeletePolicy) *SQLStore
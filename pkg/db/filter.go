@@ -0,0 +1,155 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"car_project/pkg/query"
+)
+
+// CarFields are the car fields clients may filter and sort by
+var CarFields = query.Schema{
+	Fields: map[string]query.Field{
+		"id":            {Column: "id", Type: query.Int, Ops: query.Ordered, Sortable: true},
+		"brand":         {Column: "brand", Type: query.String, Ops: query.Text, Sortable: true},
+		"model":         {Column: "model", Type: query.String, Ops: query.Text, Sortable: true},
+		"year":          {Column: "year", Type: query.Int, Ops: query.Ordered, Sortable: true},
+		"color":         {Column: "color", Type: query.String, Ops: query.Text, Sortable: true},
+		"body_style":    {Column: "body_style", Type: query.String, Ops: query.Text, Sortable: true},
+		"engine_size":   {Column: "engine_size", Type: query.Float, Ops: query.Ordered, Sortable: true},
+		"weight":        {Column: "weight", Type: query.Float, Ops: query.Ordered, Sortable: true},
+		"base_price":    {Column: "base_price", Type: query.Int, Ops: query.Ordered, Sortable: true},
+		"fuel_capacity": {Column: "fuel_capacity", Type: query.Int, Ops: query.Ordered, Sortable: true},
+		"horsepower":    {Column: "horsepower", Type: query.Int, Ops: query.Ordered, Sortable: true},
+		"torque":        {Column: "torque", Type: query.Int, Ops: query.Ordered, Sortable: true},
+		"acceleration":  {Column: "acceleration", Type: query.Int, Ops: query.Ordered, Sortable: true},
+		"top_speed":     {Column: "top_speed", Type: query.Int, Ops: query.Ordered, Sortable: true},
+	},
+	Search: []string{"brand", "model"},
+}
+
+// CarHistoryFields are the history fields clients may filter and sort by
+var CarHistoryFields = query.Schema{
+	Fields: map[string]query.Field{
+		"id":            {Column: "id", Type: query.Int, Ops: query.Ordered, Sortable: true},
+		"car_id":        {Column: "car_id", Type: query.Int, Ops: query.Ordered, Sortable: true},
+		"date":          {Column: "date", Type: query.Time, Ops: query.Ordered, Sortable: true},
+		"type":          {Column: "type", Type: query.String, Ops: []query.Op{query.Eq, query.Ne, query.In}, Sortable: true},
+		"details":       {Column: "details", Type: query.String, Ops: []query.Op{query.Contains}},
+		"service_type":  {Column: "service_type", Type: query.String, Ops: query.Text, Sortable: true},
+		"service_cost":  {Column: "service_cost", Type: query.Float, Ops: query.Ordered, Sortable: true},
+		"service_notes": {Column: "service_notes", Type: query.String, Ops: []query.Op{query.Contains}},
+	},
+	Search: []string{"details", "service_type", "service_notes"},
+}
+
+// sqlOps are the comparison operators of the filter operators that have one
+var sqlOps = map[query.Op]string{
+	query.Eq:  "=",
+	query.Ne:  "<>",
+	query.Gt:  ">",
+	query.Gte: ">=",
+	query.Lt:  "<",
+	query.Lte: "<=",
+}
+
+// whereSQL compiles a filter parsed against schema into conditions to AND
+// onto a WHERE clause, appending the values to args as $N parameters.
+// Column names only ever come from the schema.
+func whereSQL(schema query.Schema, filter query.Filter, args *[]interface{}) (string, error) {
+	param := func(v interface{}) string {
+		*args = append(*args, v)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+	contains := func(column string, v interface{}) string {
+		s, _ := v.(string)
+		return "LOWER(" + column + ") LIKE " + param("%"+strings.ToLower(likeEscaper.Replace(s))+"%") + ` ESCAPE '\'`
+	}
+
+	var sql strings.Builder
+	for _, cond := range filter {
+		if cond.Field == "" {
+			var alternatives []string
+			for _, name := range cond.Search {
+				alternatives = append(alternatives, contains(schema.Fields[name].Column, cond.Values[0]))
+			}
+			sql.WriteString(" AND (" + strings.Join(alternatives, " OR ") + ")")
+			continue
+		}
+
+		field, ok := schema.Fields[cond.Field]
+		if !ok {
+			return "", fmt.Errorf("unknown filter field %q", cond.Field)
+		}
+		switch cond.Op {
+		case query.Contains:
+			sql.WriteString(" AND " + contains(field.Column, cond.Values[0]))
+		case query.In:
+			var params []string
+			for _, v := range cond.Values {
+				params = append(params, param(v))
+			}
+			sql.WriteString(" AND " + field.Column + " IN (" + strings.Join(params, ", ") + ")")
+		default:
+			op, ok := sqlOps[cond.Op]
+			if !ok {
+				return "", fmt.Errorf("unknown filter operator %q", cond.Op)
+			}
+			sql.WriteString(" AND " + field.Column + " " + op + " " + param(cond.Values[0]))
+		}
+	}
+	return sql.String(), nil
+}
+
+// orderSQL compiles a sort parsed against schema into an ORDER BY clause
+// over its Keys, so that the ID breaks ties and pages don't overlap
+func orderSQL(schema query.Schema, sort query.Sort) (string, error) {
+	var keys []string
+	for _, key := range sort.Keys() {
+		field, ok := schema.Fields[key.Field]
+		if !ok || !field.Sortable {
+			return "", fmt.Errorf("can't sort by %q", key.Field)
+		}
+		if key.Desc {
+			keys = append(keys, field.Column+" DESC")
+		} else {
+			keys = append(keys, field.Column)
+		}
+	}
+	return " ORDER BY " + strings.Join(keys, ", "), nil
+}
+
+// pageSQL compiles a page into the condition that skips to its cursor, if
+// it has one, and the LIMIT and OFFSET clauses. A cursor (a, b) for keys
+// a ASC, b DESC becomes (a > $1 OR (a = $1 AND b < $2)). The cursor must
+// hold a value for each of sort.Keys.
+func pageSQL(schema query.Schema, sort query.Sort, page query.Page, args *[]interface{}) (where, limit string, err error) {
+	param := func(v interface{}) string {
+		*args = append(*args, v)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	if page.After != nil {
+		if err := sort.CheckCursor(page.After); err != nil {
+			return "", "", err
+		}
+		var alternatives, equal []string
+		for i, key := range sort.Keys() {
+			field, ok := schema.Fields[key.Field]
+			if !ok || !field.Sortable {
+				return "", "", fmt.Errorf("can't sort by %q", key.Field)
+			}
+			column := field.Column
+			value := param(page.After[i])
+			op := " > "
+			if key.Desc {
+				op = " < "
+			}
+			alternatives = append(alternatives, "("+strings.Join(append(equal, column+op+value), " AND ")+")")
+			equal = append(equal, column+" = "+value)
+		}
+		where = " AND (" + strings.Join(alternatives, " OR ") + ")"
+	}
+	limit = " LIMIT " + param(page.Limit) + " OFFSET " + param(page.Offset)
+	return where, limit, nil
+}
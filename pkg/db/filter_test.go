@@ -0,0 +1,215 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"car_project/pkg/query"
+)
+
+// testFields maps API names to columns named differently, to tell them apart
+var testFields = query.Schema{
+	Fields: map[string]query.Field{
+		"id":    {Column: "c.id", Type: query.Int, Ops: query.Ordered, Sortable: true},
+		"brand": {Column: "c.brand_name", Type: query.String, Ops: query.Text, Sortable: true},
+		"price": {Column: "c.base_price", Type: query.Float, Ops: query.Ordered, Sortable: true},
+		"notes": {Column: "c.notes", Type: query.String, Ops: []query.Op{query.Contains}},
+	},
+	Search: []string{"brand", "notes"},
+}
+
+func TestWhereSQL(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		sql    string
+		args   []interface{}
+	}{
+		{name: "empty", filter: "", sql: "", args: []interface{}{"org"}},
+		{
+			name:   "comparisons",
+			filter: "price>=10.5,id!=3,brand=BMW",
+			sql:    " AND c.base_price >= $2 AND c.id <> $3 AND c.brand_name = $4",
+			args:   []interface{}{"org", 10.5, int64(3), "BMW"},
+		},
+		{
+			name:   "in",
+			filter: "id:in:1|2|3",
+			sql:    " AND c.id IN ($2, $3, $4)",
+			args:   []interface{}{"org", int64(1), int64(2), int64(3)},
+		},
+		{
+			name:   "contains escapes LIKE wildcards",
+			filter: `brand:contains:50%_OFF\`,
+			sql:    ` AND LOWER(c.brand_name) LIKE $2 ESCAPE '\'`,
+			args:   []interface{}{"org", `%50\%\_off\\%`},
+		},
+		{
+			name:   "bare word search",
+			filter: "Civic",
+			sql:    ` AND (LOWER(c.brand_name) LIKE $2 ESCAPE '\' OR LOWER(c.notes) LIKE $3 ESCAPE '\')`,
+			args:   []interface{}{"org", "%civic%", "%civic%"},
+		},
+		{
+			name:   "values never reach the SQL",
+			filter: "brand='; DROP TABLE cars; --,notes:contains:x' OR '1'='1",
+			sql:    ` AND c.brand_name = $2 AND LOWER(c.notes) LIKE $3 ESCAPE '\'`,
+			args:   []interface{}{"org", "'; DROP TABLE cars; --", "%x' or '1'='1%"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := testFields.ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Parameters are numbered after those already in args
+			args := []interface{}{"org"}
+			sql, err := whereSQL(testFields, filter, &args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql != tt.sql {
+				t.Errorf("sql\n got %q\nwant %q", sql, tt.sql)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestWhereSQLRejectsFieldsOutsideTheSchema(t *testing.T) {
+	// A filter parsed against another schema names fields this one lacks
+	filters := []query.Filter{
+		{{Field: "owner", Op: query.Eq, Values: []interface{}{"x"}}},
+		{{Field: "c.id", Op: query.Eq, Values: []interface{}{int64(1)}}},
+		{{Field: "id", Op: query.Op("like"), Values: []interface{}{"x"}}},
+	}
+	for _, filter := range filters {
+		var args []interface{}
+		if sql, err := whereSQL(testFields, filter, &args); err == nil {
+			t.Errorf("whereSQL(%v) = %q, want an error", filter, sql)
+		}
+	}
+}
+
+func TestOrderSQL(t *testing.T) {
+	tests := []struct {
+		sort string
+		sql  string
+	}{
+		{"", " ORDER BY c.id"},
+		{"price:desc", " ORDER BY c.base_price DESC, c.id"},
+		{"brand,price:desc", " ORDER BY c.brand_name, c.base_price DESC, c.id"},
+		{"id:desc,brand", " ORDER BY c.id DESC, c.brand_name"},
+	}
+	for _, tt := range tests {
+		sort, err := testFields.ParseSort(tt.sort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sql, err := orderSQL(testFields, sort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sql != tt.sql {
+			t.Errorf("orderSQL(%q) = %q, want %q", tt.sort, sql, tt.sql)
+		}
+	}
+}
+
+func TestOrderSQLRejectsFieldsOutsideTheSchema(t *testing.T) {
+	sorts := []query.Sort{
+		{{Field: "c.brand_name"}},
+		{{Field: "brand; DROP TABLE cars"}},
+		{{Field: "notes"}}, // Not sortable
+	}
+	for _, sort := range sorts {
+		if sql, err := orderSQL(testFields, sort); err == nil || strings.Contains(sql, sort[0].Field) {
+			t.Errorf("orderSQL(%v) = %q, %v, want an error", sort, sql, err)
+		}
+	}
+}
+
+func TestPageSQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		sort  string
+		page  query.Page
+		where string
+		limit string
+		args  []interface{}
+	}{
+		{
+			name:  "offset",
+			sort:  "price:desc",
+			page:  query.Page{Limit: 10, Offset: 20},
+			limit: " LIMIT $2 OFFSET $3",
+			args:  []interface{}{"org", 10, 20},
+		},
+		{
+			name:  "ID breaks ties",
+			sort:  "brand",
+			page:  query.Page{Limit: 5, After: []interface{}{"BMW", int64(7)}},
+			where: " AND ((c.brand_name > $2) OR (c.brand_name = $2 AND c.id > $3))",
+			limit: " LIMIT $4 OFFSET $5",
+			args:  []interface{}{"org", "BMW", int64(7), 5, 0},
+		},
+		{
+			name:  "mixed directions",
+			sort:  "price:desc,brand",
+			page:  query.Page{Limit: 5, After: []interface{}{9.5, "BMW", int64(7)}},
+			where: " AND ((c.base_price < $2) OR (c.base_price = $2 AND c.brand_name > $3) OR (c.base_price = $2 AND c.brand_name = $3 AND c.id > $4))",
+			limit: " LIMIT $5 OFFSET $6",
+			args:  []interface{}{"org", 9.5, "BMW", int64(7), 5, 0},
+		},
+		{
+			name:  "descending ID only",
+			sort:  "id:desc",
+			page:  query.Page{Limit: 5, After: []interface{}{int64(7)}},
+			where: " AND ((c.id < $2))",
+			limit: " LIMIT $3 OFFSET $4",
+			args:  []interface{}{"org", int64(7), 5, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sort, err := testFields.ParseSort(tt.sort)
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := []interface{}{"org"}
+			where, limit, err := pageSQL(testFields, sort, tt.page, &args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if where != tt.where {
+				t.Errorf("where\n got %q\nwant %q", where, tt.where)
+			}
+			if limit != tt.limit {
+				t.Errorf("limit = %q, want %q", limit, tt.limit)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestPageSQLRejectsMismatchedCursors(t *testing.T) {
+	sort := query.Sort{{Field: "price", Desc: true}, {Field: "brand"}}
+	cursors := [][]interface{}{
+		{},
+		{9.5},
+		{9.5, "BMW"},                   // Missing the ID
+		{9.5, "BMW", int64(7), "more"}, // One too many
+	}
+	for _, after := range cursors {
+		var args []interface{}
+		if where, _, err := pageSQL(testFields, sort, query.Page{Limit: 5, After: after}, &args); err == nil {
+			t.Errorf("pageSQL with cursor %v = %q, want an error", after, where)
+		}
+	}
+}
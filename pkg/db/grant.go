@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"car_project/pkg/model"
+)
+
+// CarAccess looks up a car that is either in the given organization or
+// shared with the user. It returns the car's organization and the role the
+// user was granted on it, which is empty if there is no grant. found is
+// false if the user can't see the car at all.
+func (s *SQLStore) CarAccess(ctx context.Context, orgID, userID, carID int) (carOrgID int, grant model.Role, found bool, err error) {
+	var role sql.NullString
+	err = s.conn().QueryRowContext(ctx, `SELECT c.org_id, g.role FROM car c
+		LEFT JOIN car_grants g ON g.car_id = c.id AND g.user_id = $2
+		WHERE c.id = $1 AND (c.org_id = $3 OR g.user_id IS NOT NULL)`, carID, userID, orgID).Scan(&carOrgID, &role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+	return carOrgID, model.Role(role.String), true, nil
+}
+
+// GetCarHistoryCarID returns the car a history record belongs to, whatever
+// its organization
+func (s *SQLStore) GetCarHistoryCarID(ctx context.Context, id int) (int, error) {
+	var carID int
+	err := s.conn().QueryRowContext(ctx, "SELECT car_id FROM car_history WHERE id = $1", id).Scan(&carID)
+	return carID, err
+}
+
+// SaveCarGrant grants a user a role on a car, replacing any earlier grant
+func (s *SQLStore) SaveCarGrant(ctx context.Context, grant model.CarGrant) (*model.CarGrant, error) {
+	err := s.conn().QueryRowContext(ctx, `INSERT INTO car_grants (car_id, user_id, role, granted_by) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (car_id, user_id) DO UPDATE SET role = EXCLUDED.role, granted_by = EXCLUDED.granted_by, created_at = NOW()
+		RETURNING id, created_at`, grant.CarID, grant.UserID, grant.Role, grant.GrantedBy).Scan(&grant.ID, &grant.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// GetCarGrants lists the grants on a car
+func (s *SQLStore) GetCarGrants(ctx context.Context, carID int) ([]model.CarGrant, error) {
+	rows, err := s.conn().QueryContext(ctx, `SELECT g.id, g.car_id, g.user_id, u.username, g.role, g.granted_by, g.created_at
+		FROM car_grants g JOIN users u ON u.id = g.user_id WHERE g.car_id = $1 ORDER BY g.id`, carID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []model.CarGrant{}
+	for rows.Next() {
+		var g model.CarGrant
+		if err := rows.Scan(&g.ID, &g.CarID, &g.UserID, &g.Username, &g.Role, &g.GrantedBy, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// DeleteCarGrant revokes a user's grant on a car. It returns false if there
+// was none.
+func (s *SQLStore) DeleteCarGrant(ctx context.Context, carID, userID int) (bool, error) {
+	res, err := s.conn().ExecContext(ctx, "DELETE FROM car_grants WHERE car_id = $1 AND user_id = $2", carID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetSharedCars lists the cars shared with a user through grants
+func (s *SQLStore) GetSharedCars(ctx context.Context, userID int) ([]model.Car, error) {
+	rows, err := s.conn().QueryContext(ctx, `SELECT c.id, c.brand, c.model, c.year, c.color, c.body_style, c.engine_size, c.weight, c.base_price, c.fuel_capacity, c.horsepower, c.torque, c.acceleration, c.top_speed
+		FROM car c JOIN car_grants g ON g.car_id = c.id WHERE g.user_id = $1 ORDER BY c.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cars := []model.Car{}
+	for rows.Next() {
+		var c model.Car
+		if err := rows.Scan(&c.ID, &c.Brand, &c.Model, &c.Year, &c.Color, &c.BodyStyle, &c.EngineSize, &c.Weight, &c.BasePrice, &c.FuelCapacity, &c.Horsepower, &c.Torque, &c.Acceleration, &c.TopSpeed); err != nil {
+			return nil, err
+		}
+		cars = append(cars, c)
+	}
+	return cars, rows.Err()
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"car_project/pkg/model"
+)
+
+// LoginBlockedUntil returns the latest lock among the given keys, or the
+// zero time if none of them is locked
+func (s *SQLStore) LoginBlockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	if len(keys) == 0 {
+		return time.Time{}, nil
+	}
+	placeholders := make([]string, len(keys))
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+		args[i] = key
+	}
+
+	var until time.Time
+	err := s.conn().QueryRowContext(ctx, "SELECT locked_until FROM login_failures WHERE key IN ("+strings.Join(placeholders, ", ")+") AND locked_until > NOW() ORDER BY locked_until DESC LIMIT 1", args...).Scan(&until)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, err
+	}
+	return until, nil
+}
+
+// RecordLoginFailure counts a failed login against key, starting over once
+// window has passed since the previous one, and locks the key for
+// backoff(failures) unless it is locked for longer already. It returns the
+// number of failures, including this one, and the end of the lock.
+func (s *SQLStore) RecordLoginFailure(ctx context.Context, key string, window time.Duration, backoff func(failures int) time.Duration) (int, time.Time, error) {
+	var failures int
+	var lockedUntil sql.NullTime
+	err := s.inTx(ctx, func(tx *SQLStore) error {
+		now := time.Now()
+		err := tx.conn().QueryRowContext(ctx, `INSERT INTO login_failures (key, failures, last_failure) VALUES ($1, 1, NOW())
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN login_failures.last_failure < $2 THEN 1 ELSE login_failures.failures + 1 END,
+				last_failure = NOW()
+			RETURNING failures, locked_until`, key, now.Add(-window)).Scan(&failures, &lockedUntil)
+		if err != nil {
+			return err
+		}
+
+		wait := backoff(failures)
+		if wait <= 0 || lockedUntil.Valid && !lockedUntil.Time.Before(now.Add(wait)) {
+			return nil
+		}
+		lockedUntil = sql.NullTime{Time: now.Add(wait), Valid: true}
+		_, err = tx.conn().ExecContext(ctx, "UPDATE login_failures SET locked_until = $1 WHERE key = $2", lockedUntil.Time, key)
+		return err
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return failures, lockedUntil.Time, nil
+}
+
+// ClearLoginFailures forgets the failures counted against key
+func (s *SQLStore) ClearLoginFailures(ctx context.Context, key string) error {
+	_, err := s.conn().ExecContext(ctx, "DELETE FROM login_failures WHERE key = $1", key)
+	return err
+}
+
+// ClearLoginFailuresByID forgets a failure record, lifting any lock on it
+func (s *SQLStore) ClearLoginFailuresByID(ctx context.Context, id int) (bool, error) {
+	res, err := s.conn().ExecContext(ctx, "DELETE FROM login_failures WHERE id = $1", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetLoginFailures lists failure records, locked ones first
+func (s *SQLStore) GetLoginFailures(ctx context.Context) ([]model.LoginFailure, error) {
+	rows, err := s.conn().QueryContext(ctx, "SELECT id, key, failures, last_failure, locked_until FROM login_failures ORDER BY locked_until DESC NULLS LAST, last_failure DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []model.LoginFailure{}
+	for rows.Next() {
+		var f model.LoginFailure
+		if err := rows.Scan(&f.ID, &f.Key, &f.Failures, &f.LastFailure, &f.LockedUntil); err != nil {
+			return nil, err
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
@@ -0,0 +1,248 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"car_project/pkg/model"
+)
+
+type recoveryCode struct {
+	userID int
+	hash   string
+}
+
+// LoginBlockedUntil returns the latest lock among the given keys, or the
+// zero time if none of them is locked
+func (s *Store) LoginBlockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var until time.Time
+	for _, key := range keys {
+		f, ok := s.loginFailures[key]
+		if ok && f.LockedUntil != nil && f.LockedUntil.After(until) {
+			until = *f.LockedUntil
+		}
+	}
+	if !until.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+// RecordLoginFailure counts a failed login against key, starting over once
+// window has passed since the previous one, and locks the key for
+// backoff(failures) unless it is locked for longer already. It returns the
+// number of failures, including this one, and the end of the lock.
+func (s *Store) RecordLoginFailure(ctx context.Context, key string, window time.Duration, backoff func(failures int) time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	f, ok := s.loginFailures[key]
+	switch {
+	case !ok:
+		f = model.LoginFailure{ID: s.newID(), Key: key, Failures: 1}
+	case f.LastFailure.Before(now.Add(-window)):
+		f.Failures = 1
+	default:
+		f.Failures++
+	}
+	f.LastFailure = now
+	if wait := backoff(f.Failures); wait > 0 && (f.LockedUntil == nil || f.LockedUntil.Before(now.Add(wait))) {
+		until := now.Add(wait)
+		f.LockedUntil = &until
+	}
+	s.loginFailures[key] = f
+
+	var lockedUntil time.Time
+	if f.LockedUntil != nil {
+		lockedUntil = *f.LockedUntil
+	}
+	return f.Failures, lockedUntil, nil
+}
+
+// ClearLoginFailures forgets the failures counted against key
+func (s *Store) ClearLoginFailures(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.loginFailures, key)
+	return nil
+}
+
+// ClearLoginFailuresByID forgets a failure record, lifting any lock on it
+func (s *Store) ClearLoginFailuresByID(ctx context.Context, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, f := range s.loginFailures {
+		if f.ID == id {
+			delete(s.loginFailures, key)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetLoginFailures lists failure records, locked ones first
+func (s *Store) GetLoginFailures(ctx context.Context) ([]model.LoginFailure, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failures := []model.LoginFailure{}
+	for _, f := range s.loginFailures {
+		failures = append(failures, f)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		a, b := failures[i], failures[j]
+		if (a.LockedUntil == nil) != (b.LockedUntil == nil) {
+			return a.LockedUntil != nil
+		}
+		if a.LockedUntil != nil && !a.LockedUntil.Equal(*b.LockedUntil) {
+			return a.LockedUntil.After(*b.LockedUntil)
+		}
+		return a.LastFailure.After(b.LastFailure)
+	})
+	return failures, nil
+}
+
+// SetTOTPSecret starts enrollment by storing a new secret that is not yet
+// required at login
+func (s *Store) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[userID]; ok {
+		user.TOTPSecret, user.TOTPEnabled = secret, false
+		s.users[userID] = user
+	}
+	delete(s.totpSteps, userID)
+	return nil
+}
+
+// EnableTOTP requires TOTP at login from now on and replaces the user's
+// recovery codes with the given hashes
+func (s *Store) EnableTOTP(ctx context.Context, userID int, codeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[userID]; ok {
+		user.TOTPEnabled = true
+		s.users[userID] = user
+	}
+	s.replaceRecoveryCodes(userID, codeHashes)
+	return nil
+}
+
+// DisableTOTP removes the user's secret and recovery codes
+func (s *Store) DisableTOTP(ctx context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[userID]; ok {
+		user.TOTPSecret, user.TOTPEnabled = "", false
+		s.users[userID] = user
+	}
+	delete(s.totpSteps, userID)
+	s.replaceRecoveryCodes(userID, nil)
+	return nil
+}
+
+// ReplaceRecoveryCodes invalidates the user's recovery codes and stores new hashes
+func (s *Store) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replaceRecoveryCodes(userID, codeHashes)
+	return nil
+}
+
+func (s *Store) replaceRecoveryCodes(userID int, codeHashes []string) {
+	for key := range s.recoveryCodes {
+		if key.userID == userID {
+			delete(s.recoveryCodes, key)
+		}
+	}
+	for _, hash := range codeHashes {
+		s.recoveryCodes[recoveryCode{userID, hash}] = false
+	}
+}
+
+// UseTOTPStep records that a code for the given time step was accepted. It
+// returns false if a code for this or a later step was already used.
+func (s *Store) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[userID]; !ok {
+		return false, nil
+	}
+	if last, ok := s.totpSteps[userID]; ok && last >= step {
+		return false, nil
+	}
+	s.totpSteps[userID] = step
+	return true, nil
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes. It returns
+// false if no unused code has the given hash.
+func (s *Store) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := recoveryCode{userID, codeHash}
+	used, ok := s.recoveryCodes[key]
+	if !ok || used {
+		return false, nil
+	}
+	s.recoveryCodes[key] = true
+	return true, nil
+}
+
+// CreateAPIKey stores a new API key and returns it with its ID and creation time
+func (s *Store) CreateAPIKey(ctx context.Context, key model.APIKey) (*model.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key.ID = s.newID()
+	key.CreatedAt = time.Now()
+	key.Scopes = append([]string(nil), key.Scopes...)
+	s.apiKeys[key.ID] = key
+	return &key, nil
+}
+
+// GetAPIKeysByUser lists a user's API keys, including revoked ones
+func (s *Store) GetAPIKeysByUser(ctx context.Context, userID int) ([]model.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []model.APIKey{}
+	for _, k := range s.apiKeys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+// UseAPIKey looks up an active key by hash and records that it was used
+func (s *Store) UseAPIKey(ctx context.Context, hash string) (*model.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, k := range s.apiKeys {
+		if k.KeyHash == hash && k.RevokedAt == nil {
+			now := time.Now()
+			k.LastUsedAt = &now
+			s.apiKeys[id] = k
+			return &k, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// RevokeAPIKey revokes one of the user's keys. It returns false if the user
+// has no active key with that ID.
+func (s *Store) RevokeAPIKey(ctx context.Context, userID, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.apiKeys[id]
+	if !ok || k.UserID != userID || k.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	k.RevokedAt = &now
+	s.apiKeys[id] = k
+	return true, nil
+}
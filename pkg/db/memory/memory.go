@@ -0,0 +1,746 @@
+// Package memory implements the pkg/db stores in memory, for tests and for
+// running the server without a database
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"car_project/pkg/db"
+	"car_project/pkg/model"
+	"car_project/pkg/query"
+)
+
+// Store implements every store interface of pkg/db. The zero value is not
+// usable; create one with New. Calls never wait on I/O, so contexts are
+// ignored.
+type Store struct {
+	mu sync.Locker
+	*data
+	onCarDelete db.CarDeletePolicy
+}
+
+// data is what a store holds, shared with the stores of its units of work
+type data struct {
+	nextID  int
+	cars    map[int]car
+	history map[int]history
+	ratings []model.Rating
+	users   map[int]model.User
+	grants  map[grantKey]model.CarGrant
+
+	refreshTokens   map[int]model.RefreshToken
+	verifications   map[string]oneTimeToken
+	passwordResets  map[string]oneTimeToken
+	revokedTokens   map[string]revokedToken
+	userRevocations map[int]userRevocation
+
+	loginFailures map[string]model.LoginFailure
+	totpSteps     map[int]int64
+	recoveryCodes map[recoveryCode]bool // Whether the code was used
+	apiKeys       map[int]model.APIKey
+
+	oidcLogins  map[string]oidcLogin
+	identities  map[identityKey]int
+	orgs        map[int]model.Organization
+	members     map[memberKey]string
+	invitations map[int]model.OrgInvitation
+	auditLog    []model.AuditEntry
+}
+
+type car struct {
+	model.Car
+	orgID int
+}
+
+type history struct {
+	model.CarHistory
+	orgID int
+}
+
+type grantKey struct {
+	carID, userID int
+}
+
+var _ db.Store = (*Store)(nil)
+
+// New returns an empty store
+func New(onCarDelete db.CarDeletePolicy) *Store {
+	return &Store{
+		mu:          &sync.Mutex{},
+		onCarDelete: onCarDelete,
+		data: &data{
+			cars:            make(map[int]car),
+			history:         make(map[int]history),
+			users:           make(map[int]model.User),
+			grants:          make(map[grantKey]model.CarGrant),
+			refreshTokens:   make(map[int]model.RefreshToken),
+			verifications:   make(map[string]oneTimeToken),
+			passwordResets:  make(map[string]oneTimeToken),
+			revokedTokens:   make(map[string]revokedToken),
+			userRevocations: make(map[int]userRevocation),
+			loginFailures:   make(map[string]model.LoginFailure),
+			totpSteps:       make(map[int]int64),
+			recoveryCodes:   make(map[recoveryCode]bool),
+			apiKeys:         make(map[int]model.APIKey),
+			oidcLogins:      make(map[string]oidcLogin),
+			identities:      make(map[identityKey]int),
+			orgs:            make(map[int]model.Organization),
+			members:         make(map[memberKey]string),
+			invitations:     make(map[int]model.OrgInvitation),
+		},
+	}
+}
+
+// Transact runs fn with the store to itself and puts back what it held
+// before if fn returns an error or panics. Units of work never conflict, so
+// fn runs once.
+func (s *Store) Transact(ctx context.Context, fn func(tx db.Stores) error) (err error) {
+	tx := &Store{mu: noLock{}, data: s.data, onCarDelete: s.onCarDelete}
+	if _, joined := s.mu.(noLock); joined {
+		return fn(db.Stores{Cars: tx, History: tx, Ratings: tx, Users: tx})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved := s.data.clone()
+	defer func() {
+		if v := recover(); v != nil {
+			*s.data = *saved
+			panic(v)
+		}
+		if err != nil {
+			*s.data = *saved
+		}
+	}()
+	return fn(db.Stores{Cars: tx, History: tx, Ratings: tx, Users: tx})
+}
+
+// noLock is the lock of stores inside a unit of work, which already holds
+// the real one
+type noLock struct{}
+
+func (noLock) Lock()   {}
+func (noLock) Unlock() {}
+
+func (d *data) clone() *data {
+	return &data{
+		nextID:  d.nextID,
+		cars:    copyMap(d.cars),
+		history: copyMap(d.history),
+		ratings: append([]model.Rating(nil), d.ratings...),
+		users:   copyMap(d.users),
+		grants:  copyMap(d.grants),
+
+		refreshTokens:   copyMap(d.refreshTokens),
+		verifications:   copyMap(d.verifications),
+		passwordResets:  copyMap(d.passwordResets),
+		revokedTokens:   copyMap(d.revokedTokens),
+		userRevocations: copyMap(d.userRevocations),
+
+		loginFailures: copyMap(d.loginFailures),
+		totpSteps:     copyMap(d.totpSteps),
+		recoveryCodes: copyMap(d.recoveryCodes),
+		apiKeys:       copyMap(d.apiKeys),
+
+		oidcLogins:  copyMap(d.oidcLogins),
+		identities:  copyMap(d.identities),
+		orgs:        copyMap(d.orgs),
+		members:     copyMap(d.members),
+		invitations: copyMap(d.invitations),
+		auditLog:    append([]model.AuditEntry(nil), d.auditLog...),
+	}
+}
+
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func (s *Store) newID() int {
+	s.nextID++
+	return s.nextID
+}
+
+// CarExists reports whether the organization has a car with the given ID
+func (s *Store) CarExists(ctx context.Context, orgID, carID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cars[carID]
+	return ok && c.orgID == orgID, nil
+}
+
+// CreateCar adds a car to the organization
+func (s *Store) CreateCar(ctx context.Context, orgID int, c model.Car) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.ID = s.newID()
+	s.cars[c.ID] = car{Car: c, orgID: orgID}
+	return nil
+}
+
+// GetAllCars returns every car of the organization
+func (s *Store) GetAllCars(ctx context.Context, orgID int) ([]model.Car, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.orgCars(orgID, func(model.Car) bool { return true }), nil
+}
+
+// GetCarWithPagination pages through the organization's cars that match
+// the filter, in the order of the sort and then by ID
+func (s *Store) GetCarWithPagination(ctx context.Context, orgID int, page query.Page, order query.Sort, filter query.Filter) ([]model.Car, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cars := s.orgCars(orgID, func(c model.Car) bool { return filter.Match(c) })
+	order.SortSlice(cars)
+	return pageOf(cars, page, order)
+}
+
+// CountCars counts the organization's cars that match the filter
+func (s *Store) CountCars(ctx context.Context, orgID int, filter query.Filter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.orgCars(orgID, func(c model.Car) bool { return filter.Match(c) })), nil
+}
+
+// orgCars returns the organization's cars that match, ordered by ID
+func (s *Store) orgCars(orgID int, match func(model.Car) bool) []model.Car {
+	cars := []model.Car{}
+	for _, c := range s.cars {
+		if c.orgID == orgID && match(c.Car) {
+			cars = append(cars, c.Car)
+		}
+	}
+	sort.Slice(cars, func(i, j int) bool { return cars[i].ID < cars[j].ID })
+	return cars
+}
+
+// GetCarByID returns a car of the organization, or nil if there is none
+func (s *Store) GetCarByID(ctx context.Context, orgID, id int) (*model.Car, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cars[id]
+	if !ok || c.orgID != orgID {
+		return nil, nil
+	}
+	return &c.Car, nil
+}
+
+// UpdateCarByID replaces a car of the organization
+func (s *Store) UpdateCarByID(ctx context.Context, orgID, id int, updated model.Car) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cars[id]
+	if !ok || c.orgID != orgID {
+		return false, nil
+	}
+	updated.ID = id
+	s.cars[id] = car{Car: updated, orgID: orgID}
+	return true, nil
+}
+
+// DeleteCarByID removes a car of the organization
+func (s *Store) DeleteCarByID(ctx context.Context, orgID, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cars[id]
+	if !ok || c.orgID != orgID {
+		return false, nil
+	}
+
+	var ratings []model.Rating
+	for _, r := range s.ratings {
+		if r.CarID != id {
+			ratings = append(ratings, r)
+		}
+	}
+	inUse := len(ratings) < len(s.ratings)
+	for _, h := range s.history {
+		inUse = inUse || h.CarID == id
+	}
+	if inUse && s.onCarDelete == db.CarDeleteRestrict {
+		return false, db.ErrCarInUse
+	}
+	for hid, h := range s.history {
+		if h.CarID == id {
+			delete(s.history, hid)
+		}
+	}
+	s.ratings = ratings
+	for key := range s.grants {
+		if key.carID == id {
+			delete(s.grants, key)
+		}
+	}
+	delete(s.cars, id)
+	return true, nil
+}
+
+// CarAccess looks up a car that is in the organization or shared with the user
+func (s *Store) CarAccess(ctx context.Context, orgID, userID, carID int) (int, model.Role, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cars[carID]
+	if !ok {
+		return 0, "", false, nil
+	}
+	grant, granted := s.grants[grantKey{carID, userID}]
+	if c.orgID != orgID && !granted {
+		return 0, "", false, nil
+	}
+	return c.orgID, grant.Role, true, nil
+}
+
+// SaveCarGrant grants a user a role on a car, replacing any earlier grant
+func (s *Store) SaveCarGrant(ctx context.Context, grant model.CarGrant) (*model.CarGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := grantKey{grant.CarID, grant.UserID}
+	if existing, ok := s.grants[key]; ok {
+		grant.ID = existing.ID
+	} else {
+		grant.ID = s.newID()
+	}
+	grant.CreatedAt = time.Now()
+	s.grants[key] = grant
+	return &grant, nil
+}
+
+// GetCarGrants lists the grants on a car
+func (s *Store) GetCarGrants(ctx context.Context, carID int) ([]model.CarGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	grants := []model.CarGrant{}
+	for _, g := range s.grants {
+		if g.CarID == carID {
+			g.Username = s.users[g.UserID].Username
+			grants = append(grants, g)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool { return grants[i].ID < grants[j].ID })
+	return grants, nil
+}
+
+// DeleteCarGrant revokes a user's grant on a car
+func (s *Store) DeleteCarGrant(ctx context.Context, carID, userID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := grantKey{carID, userID}
+	_, ok := s.grants[key]
+	delete(s.grants, key)
+	return ok, nil
+}
+
+// GetSharedCars lists the cars shared with a user
+func (s *Store) GetSharedCars(ctx context.Context, userID int) ([]model.Car, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cars := []model.Car{}
+	for key := range s.grants {
+		if c, ok := s.cars[key.carID]; ok && key.userID == userID {
+			cars = append(cars, c.Car)
+		}
+	}
+	sort.Slice(cars, func(i, j int) bool { return cars[i].ID < cars[j].ID })
+	return cars, nil
+}
+
+// CreateCarHistory adds a history record to the organization
+func (s *Store) CreateCarHistory(ctx context.Context, orgID int, h model.CarHistory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkHistory(h); err != nil {
+		return err
+	}
+	h.ID = s.newID()
+	s.history[h.ID] = history{CarHistory: h, orgID: orgID}
+	return nil
+}
+
+// checkHistory checks the constraints the database puts on history
+func (s *Store) checkHistory(h model.CarHistory) error {
+	if _, ok := s.cars[h.CarID]; !ok {
+		return db.NewConstraintError(db.ForeignKey, "car_history_car_id_fkey")
+	}
+	if h.Type != "accident" && h.Type != "service" {
+		return db.NewConstraintError(db.Check, "car_history_type_check")
+	}
+	return nil
+}
+
+// GetCarAllHistory pages through the organization's history, or that of one
+// car if carID is not 0, filtered and sorted as in GetCarWithPagination
+func (s *Store) GetCarAllHistory(ctx context.Context, orgID, carID int, page query.Page, order query.Sort, filter query.Filter) ([]model.CarHistory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := s.orgHistory(orgID, carID, filter)
+	order.SortSlice(records)
+	return pageOf(records, page, order)
+}
+
+// CountCarHistory counts the records GetCarAllHistory pages through
+func (s *Store) CountCarHistory(ctx context.Context, orgID, carID int, filter query.Filter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.orgHistory(orgID, carID, filter)), nil
+}
+
+// orgHistory returns the history of the organization, or of one of its
+// cars, that matches the filter
+func (s *Store) orgHistory(orgID, carID int, filter query.Filter) []model.CarHistory {
+	records := []model.CarHistory{}
+	for _, h := range s.history {
+		if h.orgID == orgID && (carID == 0 || h.CarID == carID) && filter.Match(h.CarHistory) {
+			records = append(records, h.CarHistory)
+		}
+	}
+	return records
+}
+
+// GetCarHistoryByID returns a history record of the organization
+func (s *Store) GetCarHistoryByID(ctx context.Context, orgID, id int) (model.CarHistory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.history[id]
+	if !ok || h.orgID != orgID {
+		return model.CarHistory{}, sql.ErrNoRows
+	}
+	return h.CarHistory, nil
+}
+
+// GetCarHistoryCarID returns the car a history record belongs to
+func (s *Store) GetCarHistoryCarID(ctx context.Context, id int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.history[id]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return h.CarID, nil
+}
+
+// UpdateCarHistory replaces a history record of the organization. Its car
+// must be in the same organization.
+func (s *Store) UpdateCarHistory(ctx context.Context, orgID int, updated model.CarHistory) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.history[updated.ID]
+	c, carOK := s.cars[updated.CarID]
+	if !ok || h.orgID != orgID || !carOK || c.orgID != orgID {
+		return false, nil
+	}
+	if err := s.checkHistory(updated); err != nil {
+		return false, err
+	}
+	s.history[updated.ID] = history{CarHistory: updated, orgID: orgID}
+	return true, nil
+}
+
+// DeleteCarHistory removes a history record of the organization
+func (s *Store) DeleteCarHistory(ctx context.Context, orgID, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.history[id]
+	if !ok || h.orgID != orgID {
+		return false, nil
+	}
+	delete(s.history, id)
+	return true, nil
+}
+
+// CreateRating adds a rating. Each user may rate a car once.
+func (s *Store) CreateRating(ctx context.Context, rating model.Rating) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cars[rating.CarID]; !ok {
+		return db.NewConstraintError(db.ForeignKey, "ratings_car_id_fkey")
+	}
+	if _, ok := s.users[rating.UserID]; !ok && rating.UserID != 0 {
+		return db.NewConstraintError(db.ForeignKey, "ratings_user_id_fkey")
+	}
+	if err := checkStars(rating.Stars); err != nil {
+		return err
+	}
+	for _, r := range s.ratings {
+		if r.CarID == rating.CarID && r.UserID == rating.UserID && r.UserID != 0 {
+			return db.NewConstraintError(db.Unique, "ratings_car_id_user_id_key")
+		}
+	}
+	s.ratings = append(s.ratings, rating)
+	return nil
+}
+
+// checkStars checks the range the database puts on stars
+func checkStars(stars int) error {
+	if stars < 1 || stars > 5 {
+		return db.NewConstraintError(db.Check, "ratings_stars_check")
+	}
+	return nil
+}
+
+// GetRatingsByCar returns the ratings of a car
+func (s *Store) GetRatingsByCar(ctx context.Context, carID int) ([]model.Rating, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ratings []model.Rating
+	for _, r := range s.ratings {
+		if r.CarID == carID {
+			ratings = append(ratings, r)
+		}
+	}
+	return ratings, nil
+}
+
+// UpdateRating changes a user's rating of one of the organization's cars
+func (s *Store) UpdateRating(ctx context.Context, orgID, carID, userID int, updated model.Rating) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.findRating(orgID, carID, userID)
+	if i < 0 {
+		return false, nil
+	}
+	if err := checkStars(updated.Stars); err != nil {
+		return false, err
+	}
+	s.ratings[i].Stars = updated.Stars
+	s.ratings[i].Comment = updated.Comment
+	return true, nil
+}
+
+// DeleteRating removes a user's rating of one of the organization's cars
+func (s *Store) DeleteRating(ctx context.Context, orgID, carID, userID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.findRating(orgID, carID, userID)
+	if i < 0 {
+		return false, nil
+	}
+	s.ratings = append(s.ratings[:i], s.ratings[i+1:]...)
+	return true, nil
+}
+
+func (s *Store) findRating(orgID, carID, userID int) int {
+	if c, ok := s.cars[carID]; !ok || c.orgID != orgID {
+		return -1
+	}
+	for i, r := range s.ratings {
+		if r.CarID == carID && r.UserID == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+// CreateUser adds a user. Usernames must be unique.
+func (s *Store) CreateUser(ctx context.Context, user model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == user.Username {
+			return fmt.Errorf("username %q is taken", user.Username)
+		}
+	}
+	now := time.Now()
+	user.ID = s.newID()
+	user.CreatedAt = &now
+	s.users[user.ID] = user
+	return nil
+}
+
+// GetUserByID returns a user by ID
+func (s *Store) GetUserByID(ctx context.Context, id int) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return s.userView(user), nil
+}
+
+// userView returns a copy of user as the SQL store reads it: the active
+// organization only counts while the user is still a member of it
+func (s *Store) userView(user model.User) *model.User {
+	if _, member := s.members[memberKey{user.ActiveOrgID, user.ID}]; !member {
+		user.ActiveOrgID = 0
+	}
+	return &user
+}
+
+// GetUserByUsername returns a user by username
+func (s *Store) GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Username == username {
+			return s.userView(user), nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ListUsers pages through users ordered by ID, optionally searching the
+// username, display name and email ignoring case
+func (s *Store) ListUsers(ctx context.Context, page, limit int, search string) ([]model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	search = strings.ToLower(search)
+	users := []model.User{}
+	for _, u := range s.users {
+		if strings.Contains(strings.ToLower(u.Username), search) ||
+			strings.Contains(strings.ToLower(u.DisplayName), search) ||
+			strings.Contains(strings.ToLower(u.Email), search) {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return paginate(users, page, limit), nil
+}
+
+// UpdateUserProfile sets a user's display name
+func (s *Store) UpdateUserProfile(ctx context.Context, userID int, displayName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[userID]; ok {
+		user.DisplayName = displayName
+		s.users[userID] = user
+	}
+	return nil
+}
+
+// SetPendingEmail records an address to switch to once it is verified
+func (s *Store) SetPendingEmail(ctx context.Context, userID int, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[userID]; ok {
+		user.PendingEmail = email
+		s.users[userID] = user
+	}
+	return nil
+}
+
+// UpdateUserPassword sets a user's hashed password
+func (s *Store) UpdateUserPassword(ctx context.Context, userID int, hashedPassword string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[userID]; ok {
+		user.Password = hashedPassword
+		s.users[userID] = user
+	}
+	return nil
+}
+
+// DeleteUser removes a user with their grants, and their ratings unless
+// keepRatings is set, in which case the ratings lose their author
+func (s *Store) DeleteUser(ctx context.Context, userID int, keepRatings bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.releaseOrganizations(userID); err != nil {
+		return err
+	}
+	delete(s.users, userID)
+
+	ratings := s.ratings[:0]
+	for _, r := range s.ratings {
+		if r.UserID == userID {
+			if !keepRatings {
+				continue
+			}
+			r.UserID = 0
+		}
+		ratings = append(ratings, r)
+	}
+	s.ratings = ratings
+
+	for key := range s.grants {
+		if key.userID == userID {
+			delete(s.grants, key)
+		}
+	}
+	for id, rt := range s.refreshTokens {
+		if rt.UserID == userID {
+			delete(s.refreshTokens, id)
+		}
+	}
+	for id, k := range s.apiKeys {
+		if k.UserID == userID {
+			delete(s.apiKeys, id)
+		}
+	}
+	for key := range s.members {
+		if key.userID == userID {
+			delete(s.members, key)
+		}
+	}
+	for key, id := range s.identities {
+		if id == userID {
+			delete(s.identities, key)
+		}
+	}
+	for key := range s.recoveryCodes {
+		if key.userID == userID {
+			delete(s.recoveryCodes, key)
+		}
+	}
+	for jti, t := range s.verifications {
+		if t.userID == userID {
+			delete(s.verifications, jti)
+		}
+	}
+	for hash, t := range s.passwordResets {
+		if t.userID == userID {
+			delete(s.passwordResets, hash)
+		}
+	}
+	delete(s.totpSteps, userID)
+	return nil
+}
+
+// pageOf returns the items of a page of a list sorted by order
+func pageOf[T any](items []T, page query.Page, order query.Sort) ([]T, error) {
+	start := page.Offset
+	if page.After != nil {
+		if err := order.CheckCursor(page.After); err != nil {
+			return nil, err
+		}
+		start = len(items)
+		for i, item := range items {
+			if order.Follows(item, page.After) {
+				start = i
+				break
+			}
+		}
+	}
+	if start >= len(items) {
+		return []T{}, nil
+	}
+	end := start + page.Limit
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[start:end], nil
+}
+
+// paginate returns one page of items; pages start at 1
+func paginate[T any](items []T, page, limit int) []T {
+	start := (page - 1) * limit
+	if start < 0 || start >= len(items) {
+		return []T{}
+	}
+	end := start + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[start:end]
+}
@@ -0,0 +1,326 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"car_project/pkg/db"
+	"car_project/pkg/model"
+)
+
+type oidcLogin struct {
+	nonce, codeVerifier string
+	linkUserID          int
+	expiresAt           time.Time
+}
+
+type identityKey struct {
+	issuer, subject string
+}
+
+type memberKey struct {
+	orgID, userID int
+}
+
+// CreateOIDCLogin records an authorization request until the callback
+// consumes it. A non-zero linkUserID links the provider subject to that
+// user instead of logging in.
+func (s *Store) CreateOIDCLogin(ctx context.Context, state, nonce, codeVerifier string, linkUserID int, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.oidcLogins[state] = oidcLogin{nonce: nonce, codeVerifier: codeVerifier, linkUserID: linkUserID, expiresAt: expiresAt}
+	return nil
+}
+
+// ConsumeOIDCLogin deletes the authorization request for state and returns
+// its nonce, PKCE verifier and the user to link, or db.ErrOIDCLoginUnusable
+func (s *Store) ConsumeOIDCLogin(ctx context.Context, state string) (nonce, codeVerifier string, linkUserID int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	login, ok := s.oidcLogins[state]
+	delete(s.oidcLogins, state)
+	if !ok || !login.expiresAt.After(time.Now()) {
+		return "", "", 0, db.ErrOIDCLoginUnusable
+	}
+	return login.nonce, login.codeVerifier, login.linkUserID, nil
+}
+
+// GetUserByIdentity retrieves the user linked to a provider subject
+func (s *Store) GetUserByIdentity(ctx context.Context, issuer, subject string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[s.identities[identityKey{issuer, subject}]]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return s.userView(user), nil
+}
+
+// LinkIdentity links a provider subject to an existing user
+func (s *Store) LinkIdentity(ctx context.Context, userID int, issuer, subject, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.linkIdentity(userID, issuer, subject)
+}
+
+func (s *Store) linkIdentity(userID int, issuer, subject string) error {
+	if _, ok := s.users[userID]; !ok {
+		return db.NewConstraintError(db.ForeignKey, "user_identities_user_id_fkey")
+	}
+	key := identityKey{issuer, subject}
+	if _, ok := s.identities[key]; ok {
+		return db.NewConstraintError(db.Unique, "user_identities_issuer_subject_key")
+	}
+	s.identities[key] = userID
+	return nil
+}
+
+// CreateUserWithIdentity adds a new user linked to a provider subject and
+// returns it with its ID
+func (s *Store) CreateUserWithIdentity(ctx context.Context, user model.User, issuer, subject, email string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == user.Username {
+			return nil, fmt.Errorf("username %q is taken", user.Username)
+		}
+	}
+	if _, ok := s.identities[identityKey{issuer, subject}]; ok {
+		return nil, db.NewConstraintError(db.Unique, "user_identities_issuer_subject_key")
+	}
+
+	now := time.Now()
+	user.ID = s.newID()
+	user.CreatedAt = &now
+	s.users[user.ID] = user
+	return &user, s.linkIdentity(user.ID, issuer, subject)
+}
+
+// CreateOrganization creates an organization owned by the given user. It
+// becomes the owner's active organization if they had none.
+func (s *Store) CreateOrganization(ctx context.Context, name string, ownerID int) (*model.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	org := model.Organization{ID: s.newID(), Name: name, CreatedAt: time.Now()}
+	s.orgs[org.ID] = org
+	s.addOrgMember(org.ID, ownerID, model.OrgRoleOwner)
+	org.Role = model.OrgRoleOwner
+	return &org, nil
+}
+
+// addOrgMember adds a user to an organization, keeping the role of existing
+// members, and makes it their active organization if they had none
+func (s *Store) addOrgMember(orgID, userID int, role string) {
+	key := memberKey{orgID, userID}
+	if _, ok := s.members[key]; !ok {
+		s.members[key] = role
+	}
+	user, ok := s.users[userID]
+	if !ok {
+		return
+	}
+	if _, member := s.members[memberKey{user.ActiveOrgID, userID}]; !member {
+		user.ActiveOrgID = orgID
+		s.users[userID] = user
+	}
+}
+
+// GetUserOrganizations lists the organizations a user is a member of, with
+// their role in each
+func (s *Store) GetUserOrganizations(ctx context.Context, userID int) ([]model.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	orgs := []model.Organization{}
+	for key, role := range s.members {
+		if key.userID == userID {
+			org := s.orgs[key.orgID]
+			org.Role = role
+			orgs = append(orgs, org)
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].ID < orgs[j].ID })
+	return orgs, nil
+}
+
+// GetOrgRole returns the user's role in the organization, or "" if they are
+// not a member
+func (s *Store) GetOrgRole(ctx context.Context, orgID, userID int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.members[memberKey{orgID, userID}], nil
+}
+
+// SetActiveOrganization switches the organization the user works in. It
+// returns false if the user is not a member of it.
+func (s *Store) SetActiveOrganization(ctx context.Context, userID, orgID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if _, member := s.members[memberKey{orgID, userID}]; !ok || !member {
+		return false, nil
+	}
+	user.ActiveOrgID = orgID
+	s.users[userID] = user
+	return true, nil
+}
+
+// CreateOrgInvitation stores an invitation and returns its ID
+func (s *Store) CreateOrgInvitation(ctx context.Context, inv model.OrgInvitation) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inv.ID = s.newID()
+	inv.CreatedAt = time.Now()
+	s.invitations[inv.ID] = inv
+	return inv.ID, nil
+}
+
+// AcceptOrgInvitation consumes an invitation addressed to email and makes
+// the user a member of its organization, whose ID it returns
+func (s *Store) AcceptOrgInvitation(ctx context.Context, tokenHash string, userID int, email string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, inv := range s.invitations {
+		if inv.TokenHash == tokenHash && inv.AcceptedAt == nil && inv.ExpiresAt.After(now) && strings.EqualFold(inv.Email, email) {
+			inv.AcceptedAt = &now
+			s.invitations[id] = inv
+			s.addOrgMember(inv.OrgID, userID, model.OrgRoleMember)
+			return inv.OrgID, nil
+		}
+	}
+	return 0, db.ErrInvitationUnusable
+}
+
+// recordAdminAction adds an entry to the admin audit log
+func (s *Store) recordAdminAction(adminID int, action string, targetUserID int, details string) {
+	s.auditLog = append(s.auditLog, model.AuditEntry{
+		ID:           s.newID(),
+		AdminID:      adminID,
+		Action:       action,
+		TargetUserID: targetUserID,
+		Details:      details,
+		CreatedAt:    time.Now(),
+	})
+}
+
+// SetUserDisabled disables or enables a user on behalf of an admin.
+// Disabling also revokes the user's refresh tokens. It returns false if
+// there is no such user.
+func (s *Store) SetUserDisabled(ctx context.Context, adminID, userID int, disabled bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return false, nil
+	}
+	user.Disabled = disabled
+	s.users[userID] = user
+
+	action := model.AuditUserEnabled
+	if disabled {
+		action = model.AuditUserDisabled
+		s.revokeRefreshTokens(func(rt model.RefreshToken) bool { return rt.UserID == userID })
+	}
+	s.recordAdminAction(adminID, action, userID, "")
+	return true, nil
+}
+
+// SetUserRole changes a user's role on behalf of an admin. It returns false
+// if there is no such user.
+func (s *Store) SetUserRole(ctx context.Context, adminID, userID int, role model.Role) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return false, nil
+	}
+	previous := user.Role
+	user.Role = role
+	s.users[userID] = user
+	s.recordAdminAction(adminID, model.AuditUserRoleChanged, userID, fmt.Sprintf("%s -> %s", previous, role))
+	return true, nil
+}
+
+// RequirePasswordReset refuses further logins of a user until they reset
+// their password, and revokes their refresh tokens, on behalf of an admin.
+// It returns false if there is no such user.
+func (s *Store) RequirePasswordReset(ctx context.Context, adminID, userID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return false, nil
+	}
+	user.PasswordResetRequired = true
+	s.users[userID] = user
+	s.revokeRefreshTokens(func(rt model.RefreshToken) bool { return rt.UserID == userID })
+	s.recordAdminAction(adminID, model.AuditUserPasswordReset, userID, "")
+	return true, nil
+}
+
+// GetAuditLog pages through the admin audit log, newest first. A non-zero
+// targetUserID limits it to actions on that user.
+func (s *Store) GetAuditLog(ctx context.Context, page, limit, targetUserID int) ([]model.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := []model.AuditEntry{}
+	for i := len(s.auditLog) - 1; i >= 0; i-- {
+		if e := s.auditLog[i]; targetUserID == 0 || e.TargetUserID == targetUserID {
+			entries = append(entries, e)
+		}
+	}
+	return paginate(entries, page, limit), nil
+}
+
+// releaseOrganizations makes way for deleting a user. Each organization they
+// own alone passes to the other member with the lowest ID, or is deleted
+// with its invitations if they are its only member, unless it still has cars.
+func (s *Store) releaseOrganizations(userID int) error {
+	successors := make(map[int]int)
+	for key, role := range s.members {
+		if key.userID != userID || role != model.OrgRoleOwner {
+			continue
+		}
+		successor, coOwned := 0, false
+		for other, role := range s.members {
+			if other.orgID != key.orgID || other.userID == userID {
+				continue
+			}
+			if role == model.OrgRoleOwner {
+				coOwned = true
+			}
+			if successor == 0 || other.userID < successor {
+				successor = other.userID
+			}
+		}
+		if coOwned {
+			continue
+		}
+		if successor == 0 {
+			for _, c := range s.cars {
+				if c.orgID == key.orgID {
+					return db.ErrOwnsOrganization
+				}
+			}
+		}
+		successors[key.orgID] = successor
+	}
+
+	for orgID, successor := range successors {
+		if successor != 0 {
+			s.members[memberKey{orgID, successor}] = model.OrgRoleOwner
+			continue
+		}
+		delete(s.orgs, orgID)
+		for id, inv := range s.invitations {
+			if inv.OrgID == orgID {
+				delete(s.invitations, id)
+			}
+		}
+	}
+	return nil
+}
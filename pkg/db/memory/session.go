@@ -0,0 +1,267 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"car_project/pkg/db"
+	"car_project/pkg/model"
+)
+
+// oneTimeToken is an email verification or password reset token
+type oneTimeToken struct {
+	userID    int
+	email     string // Pending address an email verification confirms
+	expiresAt time.Time
+	used      bool
+}
+
+func (t oneTimeToken) usable(now time.Time) bool {
+	return !t.used && t.expiresAt.After(now)
+}
+
+type revokedToken struct {
+	expiresAt time.Time
+	createdAt time.Time
+}
+
+type userRevocation struct {
+	db.UserRevocation
+	createdAt time.Time
+}
+
+// CreateRefreshToken stores a new refresh token
+func (s *Store) CreateRefreshToken(ctx context.Context, rt model.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.createRefreshToken(rt)
+	return nil
+}
+
+func (s *Store) createRefreshToken(rt model.RefreshToken) {
+	rt.ID = s.newID()
+	rt.CreatedAt = time.Now()
+	rt.RevokedAt = nil
+	s.refreshTokens[rt.ID] = rt
+}
+
+// GetRefreshTokenByHash retrieves a refresh token by the hash of its value
+func (s *Store) GetRefreshTokenByHash(ctx context.Context, hash string) (*model.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rt := range s.refreshTokens {
+		if rt.TokenHash == hash {
+			return &rt, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// RotateRefreshToken revokes the token with oldID and stores next in its
+// place, or returns db.ErrRefreshTokenReused if it was already revoked
+func (s *Store) RotateRefreshToken(ctx context.Context, oldID int, next model.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.refreshTokens[oldID]
+	if !ok || old.RevokedAt != nil {
+		return db.ErrRefreshTokenReused
+	}
+	now := time.Now()
+	old.RevokedAt = &now
+	s.refreshTokens[oldID] = old
+	s.createRefreshToken(next)
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every token in a rotation chain
+func (s *Store) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokeRefreshTokens(func(rt model.RefreshToken) bool { return rt.FamilyID == familyID })
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every outstanding refresh token of a user
+func (s *Store) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokeRefreshTokens(func(rt model.RefreshToken) bool { return rt.UserID == userID })
+	return nil
+}
+
+// RevokeUserRefreshTokensExcept revokes every refresh token of the user
+// outside the given family
+func (s *Store) RevokeUserRefreshTokensExcept(ctx context.Context, userID int, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokeRefreshTokens(func(rt model.RefreshToken) bool { return rt.UserID == userID && rt.FamilyID != familyID })
+	return nil
+}
+
+// revokeRefreshTokens revokes the outstanding tokens that match
+func (s *Store) revokeRefreshTokens(match func(model.RefreshToken) bool) {
+	now := time.Now()
+	for id, rt := range s.refreshTokens {
+		if rt.RevokedAt == nil && match(rt) {
+			rt.RevokedAt = &now
+			s.refreshTokens[id] = rt
+		}
+	}
+}
+
+// CreateEmailVerification records a verification token that may be used
+// once before expiresAt, confirming the account or a pending email
+func (s *Store) CreateEmailVerification(ctx context.Context, jti string, userID int, email string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.verifications[jti] = oneTimeToken{userID: userID, email: email, expiresAt: expiresAt}
+	return nil
+}
+
+// UseEmailVerification consumes the verification token and marks its user
+// as verified, switching them to the address it confirms if it is still
+// their pending one. It returns db.ErrVerificationUnusable otherwise.
+func (s *Store) UseEmailVerification(ctx context.Context, jti string, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.verifications[jti]
+	if !ok || t.userID != userID || !t.usable(time.Now()) {
+		return db.ErrVerificationUnusable
+	}
+	user, ok := s.users[userID]
+	if t.email != "" {
+		if !ok || user.PendingEmail != t.email {
+			return db.ErrVerificationUnusable
+		}
+		user.Email, user.PendingEmail = t.email, ""
+	}
+
+	t.used = true
+	s.verifications[jti] = t
+	if ok {
+		user.Verified = true
+		s.users[userID] = user
+	}
+	return nil
+}
+
+// CreatePasswordReset stores the hash of a reset token that may be used
+// once before expiresAt
+func (s *Store) CreatePasswordReset(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passwordResets[tokenHash] = oneTimeToken{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+// ResetPassword consumes the reset token, sets the user's password, marks
+// them verified, and invalidates their other reset and refresh tokens. It
+// returns the user's ID, or db.ErrPasswordResetUnusable.
+func (s *Store) ResetPassword(ctx context.Context, tokenHash, hashedPassword string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.passwordResets[tokenHash]
+	if !ok || !t.usable(time.Now()) {
+		return 0, db.ErrPasswordResetUnusable
+	}
+
+	if user, ok := s.users[t.userID]; ok {
+		user.Password = hashedPassword
+		user.Verified = true
+		user.PasswordResetRequired = false
+		s.users[t.userID] = user
+	}
+	for hash, other := range s.passwordResets {
+		if other.userID == t.userID {
+			other.used = true
+			s.passwordResets[hash] = other
+		}
+	}
+	s.revokeRefreshTokens(func(rt model.RefreshToken) bool { return rt.UserID == t.userID })
+	return t.userID, nil
+}
+
+// GetPasswordResetUser returns the user a reset token belongs to, or
+// db.ErrPasswordResetUnusable
+func (s *Store) GetPasswordResetUser(ctx context.Context, tokenHash string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.passwordResets[tokenHash]
+	if !ok || !t.usable(time.Now()) {
+		return nil, db.ErrPasswordResetUnusable
+	}
+	user, ok := s.users[t.userID]
+	if !ok {
+		return nil, db.ErrPasswordResetUnusable
+	}
+	return s.userView(user), nil
+}
+
+// RevokeTokenID stores a revoked access token ID until the token expires
+func (s *Store) RevokeTokenID(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.revokedTokens[jti]; !ok {
+		s.revokedTokens[jti] = revokedToken{expiresAt: expiresAt, createdAt: time.Now()}
+	}
+	return nil
+}
+
+// RevokeUserTokensBefore invalidates the user's access tokens issued
+// before the given time, replacing any earlier cutoff
+func (s *Store) RevokeUserTokensBefore(ctx context.Context, userID int, before, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userRevocations[userID] = userRevocation{
+		UserRevocation: db.UserRevocation{RevokedBefore: before, ExpiresAt: expiresAt},
+		createdAt:      time.Now(),
+	}
+	return nil
+}
+
+// GetRevokedTokenIDsSince returns unexpired revoked token IDs recorded after since
+func (s *Store) GetRevokedTokenIDsSince(ctx context.Context, since time.Time) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	revoked := map[string]time.Time{}
+	for jti, t := range s.revokedTokens {
+		if t.createdAt.After(since) && t.expiresAt.After(now) {
+			revoked[jti] = t.expiresAt
+		}
+	}
+	return revoked, nil
+}
+
+// GetUserRevocationsSince returns unexpired per-user revocations recorded after since
+func (s *Store) GetUserRevocationsSince(ctx context.Context, since time.Time) (map[int]db.UserRevocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	revoked := map[int]db.UserRevocation{}
+	for userID, r := range s.userRevocations {
+		if r.createdAt.After(since) && r.ExpiresAt.After(now) {
+			revoked[userID] = r.UserRevocation
+		}
+	}
+	return revoked, nil
+}
+
+// DeleteExpiredRevocations removes revocations of tokens that have expired
+func (s *Store) DeleteExpiredRevocations(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for jti, t := range s.revokedTokens {
+		if !t.expiresAt.After(now) {
+			delete(s.revokedTokens, jti)
+		}
+	}
+	for userID, r := range s.userRevocations {
+		if !r.ExpiresAt.After(now) {
+			delete(s.userRevocations, userID)
+		}
+	}
+	return nil
+}
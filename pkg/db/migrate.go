@@ -0,0 +1,261 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"strconv"
+
+	"car_project/migrations"
+
+	"github.com/golang-migrate/migrate"
+	"github.com/golang-migrate/migrate/database"
+	"github.com/golang-migrate/migrate/database/postgres"
+	"github.com/golang-migrate/migrate/source"
+	bindata "github.com/golang-migrate/migrate/source/go_bindata"
+)
+
+// MigrateUsage describes the migrate subcommand
+const MigrateUsage = `usage: car_project [flags] migrate <command>
+
+commands:
+  up [N]     apply every pending migration, or the next N
+  down [N]   roll back the last N migrations, 1 by default
+  goto V     migrate up or down to version V
+  status     show the current version and the pending migrations
+  force V    record version V as applied and clean without running it, to
+             recover from a failed migration; -1 records no version`
+
+// migrationFiles returns the embedded migrations of the driver
+func migrationFiles(driverName string) fs.FS {
+	if driverName == sqliteDriver {
+		return sqlite.migrations
+	}
+	return migrations.Postgres
+}
+
+// newMigrate returns a migrate instance that applies the migrations embedded
+// in the binary to db
+func newMigrate(db *sql.DB, driverName string, logf func(format string, v ...interface{})) (*migrate.Migrate, error) {
+	var driver database.Driver
+	var err error
+	if driverName == sqliteDriver {
+		driver, err = sqlite.migrationDriver(db)
+	} else {
+		driver, err = postgres.WithInstance(db, &postgres.Config{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// migrate v3 has no source for an fs.FS, that came with iofs in v4. The
+	// go-bindata source only needs the file names and a function returning
+	// their contents, not generated code, so it serves the embedded files
+	// as they are.
+	files := migrationFiles(driverName)
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	src, err := bindata.WithInstance(bindata.Resource(names, func(name string) ([]byte, error) {
+		return fs.ReadFile(files, name)
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("embedded", src, driverName, driver)
+	if err != nil {
+		return nil, err
+	}
+	m.Log = migrateLogger(logf)
+	return m, nil
+}
+
+// migrateLogger reports each migration migrate runs
+type migrateLogger func(format string, v ...interface{})
+
+func (l migrateLogger) Printf(format string, v ...interface{}) { l(format, v...) }
+func (l migrateLogger) Verbose() bool                          { return false }
+
+// runMigrations applies every pending migration
+func runMigrations(db *sql.DB, driverName string) error {
+	m, err := newMigrate(db, driverName, log.Printf)
+	if err != nil {
+		return err
+	}
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return err
+	}
+
+	return nil
+}
+
+// warnPendingMigrations logs if the database is behind the binary, for
+// when migrations don't run at startup
+func warnPendingMigrations(db *sql.DB, driverName string) {
+	m, err := newMigrate(db, driverName, log.Printf)
+	if err != nil {
+		log.Printf("could not check migrations: %v", err)
+		return
+	}
+	current, dirty, err := m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		log.Printf("could not check migrations: %v", err)
+		return
+	}
+	if dirty {
+		log.Printf("warning: migration %d failed halfway, fix it and run migrate force", current)
+		return
+	}
+	if pending := pendingMigrations(driverName, current); len(pending) > 0 {
+		log.Printf("warning: %d migrations are pending, run migrate up", len(pending))
+	}
+}
+
+// pendingMigrations returns the up migrations after version, in order
+func pendingMigrations(driverName string, version uint) []*source.Migration {
+	var pending []*source.Migration
+	for _, m := range upMigrations(driverName) {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// upMigrations returns the embedded up migrations, in order
+func upMigrations(driverName string) []*source.Migration {
+	names, _ := fs.Glob(migrationFiles(driverName), "*.up.sql")
+	var ups []*source.Migration
+	for _, name := range names {
+		if m, err := source.DefaultParse(name); err == nil {
+			ups = append(ups, m)
+		}
+	}
+	return ups
+}
+
+// Migrate runs the migrate subcommand with args against conn, opened with
+// the named driver, reporting to w
+func Migrate(conn *sql.DB, driverName string, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(MigrateUsage)
+	}
+	m, err := newMigrate(conn, driverName, func(format string, v ...interface{}) {
+		fmt.Fprintf(w, format, v...)
+	})
+	if err != nil {
+		return err
+	}
+
+	// number reads the argument of commands that take one
+	number := func(def int) (int, error) {
+		switch len(args) {
+		case 1:
+			if def < 0 {
+				return 0, fmt.Errorf("migrate %s needs an argument\n\n%s", args[0], MigrateUsage)
+			}
+			return def, nil
+		case 2:
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return 0, fmt.Errorf("migrate %s: %q is not a number", args[0], args[1])
+			}
+			return n, nil
+		}
+		return 0, fmt.Errorf("too many arguments\n\n%s", MigrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := number(0)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			err = m.Steps(n)
+		} else {
+			err = m.Up()
+		}
+		return noChange(err, w)
+	case "down":
+		n, err := number(1)
+		if err != nil {
+			return err
+		}
+		if n < 1 {
+			return errors.New("migrate down: N must be at least 1")
+		}
+		return noChange(m.Steps(-n), w)
+	case "goto":
+		v, err := number(-1)
+		if err != nil {
+			return err
+		}
+		if v < 0 {
+			return errors.New("migrate goto: the version can't be negative")
+		}
+		return noChange(m.Migrate(uint(v)), w)
+	case "force":
+		v, err := number(-1)
+		if err != nil {
+			return err
+		}
+		if err := m.Force(v); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "forced version %d\n", v)
+		return nil
+	case "status":
+		if _, err := number(0); err != nil {
+			return err
+		}
+		return migrationStatus(m, driverName, w)
+	}
+	return fmt.Errorf("unknown migrate command %q\n\n%s", args[0], MigrateUsage)
+}
+
+// noChange reports ErrNoChange as a message rather than a failure
+func noChange(err error, w io.Writer) error {
+	if err == migrate.ErrNoChange {
+		fmt.Fprintln(w, "no change")
+		return nil
+	}
+	var short migrate.ErrShortLimit
+	if errors.As(err, &short) {
+		return fmt.Errorf("reached the end of the migrations %d steps short", short.Short)
+	}
+	return err
+}
+
+// migrationStatus writes the current version and lists every migration
+func migrationStatus(m *migrate.Migrate, driverName string, w io.Writer) error {
+	current, dirty, err := m.Version()
+	switch {
+	case err == migrate.ErrNilVersion:
+		fmt.Fprintln(w, "version: none")
+	case err != nil:
+		return err
+	case dirty:
+		fmt.Fprintf(w, "version: %d (dirty: it failed halfway, fix it and run migrate force)\n", current)
+	default:
+		fmt.Fprintf(w, "version: %d\n", current)
+	}
+
+	pending := 0
+	for _, mig := range upMigrations(driverName) {
+		state := "applied"
+		if mig.Version > current || err == migrate.ErrNilVersion {
+			state = "pending"
+			pending++
+		}
+		fmt.Fprintf(w, "  %-8s %d %s\n", state, mig.Version, mig.Identifier)
+	}
+	fmt.Fprintf(w, "%d pending\n", pending)
+	return nil
+}
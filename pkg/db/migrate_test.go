@@ -0,0 +1,63 @@
+package db
+
+import (
+	"io/fs"
+	"testing"
+
+	"car_project/migrations"
+
+	"github.com/golang-migrate/migrate/source"
+)
+
+// migrationPairs returns the name of each migration version in files,
+// failing the test unless every version has exactly one up and one down
+// migration of the same name
+func migrationPairs(t *testing.T, files fs.FS) map[uint]string {
+	t.Helper()
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type pair struct {
+		identifier string
+		up, down   int
+	}
+	pairs := map[uint]*pair{}
+	for _, name := range names {
+		m, err := source.DefaultParse(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		p := pairs[m.Version]
+		if p == nil {
+			p = &pair{identifier: m.Identifier}
+			pairs[m.Version] = p
+		}
+		if p.identifier != m.Identifier {
+			t.Errorf("version %d is named both %q and %q", m.Version, p.identifier, m.Identifier)
+		}
+		switch m.Direction {
+		case source.Up:
+			p.up++
+		case source.Down:
+			p.down++
+		}
+	}
+
+	identifiers := map[uint]string{}
+	for version, p := range pairs {
+		if p.up != 1 || p.down != 1 {
+			t.Errorf("version %d %s has %d up and %d down migrations, want one of each", version, p.identifier, p.up, p.down)
+		}
+		identifiers[version] = p.identifier
+	}
+	return identifiers
+}
+
+func TestPostgresMigrationsArePaired(t *testing.T) {
+	if pairs := migrationPairs(t, migrations.Postgres); len(pairs) == 0 {
+		t.Error("no Postgres migrations are embedded")
+	}
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"car_project/pkg/model"
+)
+
+// ErrOIDCLoginUnusable is returned for callback states that are unknown,
+// expired or already used
+var ErrOIDCLoginUnusable = errors.New("login request is invalid, expired or already used")
+
+// CreateOIDCLogin records an authorization request until the callback
+// consumes it. A non-zero linkUserID links the provider subject to that
+// user instead of logging in.
+func (s *SQLStore) CreateOIDCLogin(ctx context.Context, state, nonce, codeVerifier string, linkUserID int, expiresAt time.Time) error {
+	_, err := s.conn().ExecContext(ctx, "INSERT INTO oidc_logins (state, nonce, code_verifier, link_user_id, expires_at) VALUES ($1, $2, $3, NULLIF($4, 0), $5)", state, nonce, codeVerifier, linkUserID, expiresAt)
+	return err
+}
+
+// ConsumeOIDCLogin deletes the authorization request for state and returns
+// its nonce, PKCE verifier and the user to link, or ErrOIDCLoginUnusable
+func (s *SQLStore) ConsumeOIDCLogin(ctx context.Context, state string) (nonce, codeVerifier string, linkUserID int, err error) {
+	var fresh bool
+	err = s.conn().QueryRowContext(ctx, "DELETE FROM oidc_logins WHERE state = $1 RETURNING nonce, code_verifier, COALESCE(link_user_id, 0), expires_at > NOW()", state).Scan(&nonce, &codeVerifier, &linkUserID, &fresh)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", 0, ErrOIDCLoginUnusable
+		}
+		return "", "", 0, err
+	}
+	if !fresh {
+		return "", "", 0, ErrOIDCLoginUnusable
+	}
+	return nonce, codeVerifier, linkUserID, nil
+}
+
+// GetUserByIdentity retrieves the user linked to a provider subject
+func (s *SQLStore) GetUserByIdentity(ctx context.Context, issuer, subject string) (*model.User, error) {
+	return scanUser(s.conn().QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = (SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2)", issuer, subject))
+}
+
+// LinkIdentity links a provider subject to an existing user
+func (s *SQLStore) LinkIdentity(ctx context.Context, userID int, issuer, subject, email string) error {
+	_, err := s.conn().ExecContext(ctx, "INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4)", userID, issuer, subject, email)
+	return err
+}
+
+// CreateUserWithIdentity inserts a new user linked to a provider subject
+// and returns it with its ID
+func (s *SQLStore) CreateUserWithIdentity(ctx context.Context, user model.User, issuer, subject, email string) (*model.User, error) {
+	err := s.inTx(ctx, func(tx *SQLStore) error {
+		err := tx.conn().QueryRowContext(ctx, "INSERT INTO users (username, password, role, verified, display_name, email) VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, '')) RETURNING id",
+			user.Username, user.Password, user.Role, user.Verified, user.DisplayName, user.Email).Scan(&user.ID)
+		if err != nil {
+			return err
+		}
+		return tx.LinkIdentity(ctx, user.ID, issuer, subject, email)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"car_project/pkg/model"
+)
+
+// ErrInvitationUnusable is returned for invitations that are unknown,
+// expired, already accepted or addressed to someone else
+var ErrInvitationUnusable = errors.New("invitation is invalid, expired, already accepted or meant for another address")
+
+// ErrOwnsOrganization is returned when deleting the only member of an
+// organization that still has cars
+var ErrOwnsOrganization = &ConstraintError{Kind: Referenced, Message: "account is the only member of an organization that still has cars, delete them first"}
+
+// CreateOrganization creates an organization owned by the given user. It
+// becomes the owner's active organization if they had none.
+func (s *SQLStore) CreateOrganization(ctx context.Context, name string, ownerID int) (*model.Organization, error) {
+	org := model.Organization{Name: name, Role: model.OrgRoleOwner}
+	err := s.inTx(ctx, func(tx *SQLStore) error {
+		err := tx.conn().QueryRowContext(ctx, "INSERT INTO organizations (name) VALUES ($1) RETURNING id, created_at", name).Scan(&org.ID, &org.CreatedAt)
+		if err != nil {
+			return err
+		}
+		return addOrgMember(ctx, tx.conn(), org.ID, ownerID, model.OrgRoleOwner)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// addOrgMember adds a user to an organization, keeping the role of existing
+// members, and makes it their active organization if they had none
+func addOrgMember(ctx context.Context, tx execer, orgID, userID int, role string) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO org_memberships (org_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", orgID, userID, role)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE users SET active_org_id = $1 WHERE id = $2 AND (active_org_id IS NULL
+		OR NOT EXISTS (SELECT 1 FROM org_memberships WHERE org_id = users.active_org_id AND user_id = users.id))`, orgID, userID)
+	return err
+}
+
+// GetUserOrganizations lists the organizations a user is a member of, with
+// their role in each
+func (s *SQLStore) GetUserOrganizations(ctx context.Context, userID int) ([]model.Organization, error) {
+	rows, err := s.conn().QueryContext(ctx, `SELECT o.id, o.name, o.created_at, m.role FROM organizations o
+		JOIN org_memberships m ON m.org_id = o.id WHERE m.user_id = $1 ORDER BY o.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []model.Organization{}
+	for rows.Next() {
+		var o model.Organization
+		if err := rows.Scan(&o.ID, &o.Name, &o.CreatedAt, &o.Role); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, o)
+	}
+	return orgs, rows.Err()
+}
+
+// GetOrgRole returns the user's role in the organization, or "" if they are
+// not a member
+func (s *SQLStore) GetOrgRole(ctx context.Context, orgID, userID int) (string, error) {
+	var role string
+	err := s.conn().QueryRowContext(ctx, "SELECT role FROM org_memberships WHERE org_id = $1 AND user_id = $2", orgID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+// SetActiveOrganization switches the organization the user works in. It
+// returns false if the user is not a member of it.
+func (s *SQLStore) SetActiveOrganization(ctx context.Context, userID, orgID int) (bool, error) {
+	res, err := s.conn().ExecContext(ctx, "UPDATE users SET active_org_id = $1 WHERE id = $2 AND EXISTS (SELECT 1 FROM org_memberships WHERE org_id = $1 AND user_id = $2)", orgID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CreateOrgInvitation stores an invitation and returns its ID
+func (s *SQLStore) CreateOrgInvitation(ctx context.Context, inv model.OrgInvitation) (int, error) {
+	var id int
+	err := s.conn().QueryRowContext(ctx, "INSERT INTO org_invitations (org_id, email, token_hash, invited_by, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		inv.OrgID, inv.Email, inv.TokenHash, inv.InvitedBy, inv.ExpiresAt).Scan(&id)
+	return id, err
+}
+
+// AcceptOrgInvitation consumes an invitation addressed to email and makes
+// the user a member of its organization, whose ID it returns
+func (s *SQLStore) AcceptOrgInvitation(ctx context.Context, tokenHash string, userID int, email string) (int, error) {
+	var orgID int
+	err := s.inTx(ctx, func(tx *SQLStore) error {
+		err := tx.conn().QueryRowContext(ctx, `UPDATE org_invitations SET accepted_at = NOW()
+			WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > NOW() AND LOWER(email) = LOWER($2)
+			RETURNING org_id`, tokenHash, email).Scan(&orgID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvitationUnusable
+		}
+		if err != nil {
+			return err
+		}
+		return addOrgMember(ctx, tx.conn(), orgID, userID, model.OrgRoleMember)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return orgID, nil
+}
+
+// releaseOrganizations makes way for deleting a user. Each organization they
+// own alone passes to its longest-standing other member, or is deleted with
+// its invitations if they are its only member, unless it still has cars.
+func (s *SQLStore) releaseOrganizations(ctx context.Context, userID int) error {
+	rows, err := s.conn().QueryContext(ctx, `SELECT m.org_id FROM org_memberships m WHERE m.user_id = $1 AND m.role = $2
+		AND NOT EXISTS (SELECT 1 FROM org_memberships o WHERE o.org_id = m.org_id AND o.user_id <> m.user_id AND o.role = $2)`, userID, model.OrgRoleOwner)
+	if err != nil {
+		return err
+	}
+	var orgIDs []int
+	for rows.Next() {
+		var orgID int
+		if err := rows.Scan(&orgID); err != nil {
+			rows.Close()
+			return err
+		}
+		orgIDs = append(orgIDs, orgID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, orgID := range orgIDs {
+		res, err := s.conn().ExecContext(ctx, `UPDATE org_memberships SET role = $3 WHERE org_id = $1 AND user_id =
+			(SELECT user_id FROM org_memberships WHERE org_id = $1 AND user_id <> $2 ORDER BY created_at, user_id LIMIT 1)`, orgID, userID, model.OrgRoleOwner)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n > 0 {
+			continue
+		}
+
+		var hasCars bool
+		if err := s.conn().QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM car WHERE org_id = $1)", orgID).Scan(&hasCars); err != nil {
+			return err
+		}
+		if hasCars {
+			return ErrOwnsOrganization
+		}
+		for _, query := range []string{
+			"DELETE FROM org_invitations WHERE org_id = $1",
+			"DELETE FROM organizations WHERE id = $1",
+		} {
+			if _, err := s.conn().ExecContext(ctx, query, orgID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
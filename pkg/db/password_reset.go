@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"car_project/pkg/model"
+)
+
+// ErrPasswordResetUnusable is returned for reset tokens that are unknown,
+// expired or already used
+var ErrPasswordResetUnusable = errors.New("password reset token is invalid, expired or already used")
+
+// CreatePasswordReset stores the hash of a reset token that may be used once before expiresAt
+func (s *SQLStore) CreatePasswordReset(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	_, err := s.conn().ExecContext(ctx, "INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES ($1, $2, $3)", userID, tokenHash, expiresAt)
+	return err
+}
+
+// ResetPassword consumes the reset token and sets the user's password to the
+// already hashed value. In the same transaction it invalidates the user's
+// other reset tokens and revokes their refresh tokens, logging out every
+// session. Receiving the email also proves the address, so the account is
+// marked verified. It returns the user's ID, or ErrPasswordResetUnusable.
+func (s *SQLStore) ResetPassword(ctx context.Context, tokenHash, hashedPassword string) (int, error) {
+	var userID int
+	err := s.inTx(ctx, func(tx *SQLStore) error {
+		err := tx.conn().QueryRowContext(ctx, "UPDATE password_resets SET used_at = NOW() WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW() RETURNING user_id", tokenHash).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPasswordResetUnusable
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.conn().ExecContext(ctx, "UPDATE users SET password = $1, verified = TRUE, password_reset_required = FALSE WHERE id = $2", hashedPassword, userID); err != nil {
+			return err
+		}
+		if _, err := tx.conn().ExecContext(ctx, "UPDATE password_resets SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL", userID); err != nil {
+			return err
+		}
+		_, err = tx.conn().ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// GetPasswordResetUser returns the user a reset token belongs to, or
+// ErrPasswordResetUnusable if the token can't be used
+func (s *SQLStore) GetPasswordResetUser(ctx context.Context, tokenHash string) (*model.User, error) {
+	user, err := scanUser(s.conn().QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = (SELECT user_id FROM password_resets WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW())", tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPasswordResetUnusable
+	}
+	return user, err
+}
@@ -0,0 +1,65 @@
+package db
+
+import "context"
+
+// UpdateUserProfile sets the user's display name
+func (s *SQLStore) UpdateUserProfile(ctx context.Context, userID int, displayName string) error {
+	_, err := s.conn().ExecContext(ctx, "UPDATE users SET display_name = NULLIF($1, '') WHERE id = $2", displayName, userID)
+	return err
+}
+
+// SetPendingEmail records the address the user wants to switch to. Email
+// keeps its value until a link mailed to the new address is opened, and
+// only the latest pending address can be confirmed.
+func (s *SQLStore) SetPendingEmail(ctx context.Context, userID int, email string) error {
+	_, err := s.conn().ExecContext(ctx, "UPDATE users SET pending_email = NULLIF($1, '') WHERE id = $2", email, userID)
+	return err
+}
+
+// UpdateUserPassword sets the user's password to the already hashed value
+func (s *SQLStore) UpdateUserPassword(ctx context.Context, userID int, hashedPassword string) error {
+	_, err := s.conn().ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2", hashedPassword, userID)
+	return err
+}
+
+// RevokeUserRefreshTokensExcept revokes every refresh token of the user
+// outside the given family, ending all other sessions
+func (s *SQLStore) RevokeUserRefreshTokensExcept(ctx context.Context, userID int, familyID string) error {
+	_, err := s.conn().ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL", userID, familyID)
+	return err
+}
+
+// DeleteUser removes a user and everything tied to their account. Their
+// ratings are deleted too, unless keepRatings is set, in which case they
+// stay visible without an author. Organizations they own alone pass to
+// another member or are deleted, and ErrOwnsOrganization is returned if
+// one they are the only member of still has cars.
+func (s *SQLStore) DeleteUser(ctx context.Context, userID int, keepRatings bool) error {
+	ratings := "DELETE FROM ratings WHERE user_id = $1"
+	if keepRatings {
+		ratings = "UPDATE ratings SET user_id = NULL WHERE user_id = $1"
+	}
+
+	return s.inTx(ctx, func(tx *SQLStore) error {
+		if err := tx.releaseOrganizations(ctx, userID); err != nil {
+			return err
+		}
+		for _, query := range []string{
+			ratings,
+			"DELETE FROM refresh_tokens WHERE user_id = $1",
+			"DELETE FROM api_keys WHERE user_id = $1",
+			"DELETE FROM org_memberships WHERE user_id = $1",
+			"DELETE FROM car_grants WHERE user_id = $1",
+			"DELETE FROM user_identities WHERE user_id = $1",
+			"DELETE FROM totp_recovery_codes WHERE user_id = $1",
+			"DELETE FROM email_verifications WHERE user_id = $1",
+			"DELETE FROM password_resets WHERE user_id = $1",
+			"DELETE FROM users WHERE id = $1",
+		} {
+			if _, err := tx.conn().ExecContext(ctx, query, userID); err != nil {
+				return deleteError(err)
+			}
+		}
+		return nil
+	})
+}
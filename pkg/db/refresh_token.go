@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"car_project/pkg/model"
+)
+
+// ErrRefreshTokenReused is returned when a token that was already rotated is presented again
+var ErrRefreshTokenReused = errors.New("refresh token already used")
+
+// CreateRefreshToken stores a new refresh token
+func (s *SQLStore) CreateRefreshToken(ctx context.Context, rt model.RefreshToken) error {
+	_, err := s.conn().ExecContext(ctx, "INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at, mfa) VALUES ($1, $2, $3, $4, $5)",
+		rt.UserID, rt.TokenHash, rt.FamilyID, rt.ExpiresAt, rt.MFA)
+	return err
+}
+
+// GetRefreshTokenByHash retrieves a refresh token by the hash of its value
+func (s *SQLStore) GetRefreshTokenByHash(ctx context.Context, hash string) (*model.RefreshToken, error) {
+	var rt model.RefreshToken
+	err := s.conn().QueryRowContext(ctx, "SELECT id, user_id, token_hash, family_id, expires_at, created_at, revoked_at, mfa FROM refresh_tokens WHERE token_hash = $1", hash).
+		Scan(&rt.ID, &rt.UserID, &rt.TokenHash, &rt.FamilyID, &rt.ExpiresAt, &rt.CreatedAt, &rt.RevokedAt, &rt.MFA)
+	if err != nil {
+		return nil, err
+	}
+	return &rt, nil
+}
+
+// RotateRefreshToken revokes the token with oldID and stores next in its place.
+// It returns ErrRefreshTokenReused if the old token was revoked concurrently.
+func (s *SQLStore) RotateRefreshToken(ctx context.Context, oldID int, next model.RefreshToken) error {
+	return s.inTx(ctx, func(tx *SQLStore) error {
+		res, err := tx.conn().ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", oldID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrRefreshTokenReused
+		}
+		return tx.CreateRefreshToken(ctx, next)
+	})
+}
+
+// RevokeRefreshTokenFamily revokes every token in a rotation chain
+func (s *SQLStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	_, err := s.conn().ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL", familyID)
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every outstanding refresh token of a user
+func (s *SQLStore) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	_, err := s.conn().ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// RevokeTokenID stores a revoked access token ID until the token expires
+func (s *SQLStore) RevokeTokenID(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := s.conn().ExecContext(ctx, "INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING", jti, expiresAt)
+	return err
+}
+
+// RevokeUserTokensBefore invalidates the user's access tokens issued before
+// the given time. The record is needed until expiresAt, when all of them
+// have expired anyway.
+func (s *SQLStore) RevokeUserTokensBefore(ctx context.Context, userID int, before, expiresAt time.Time) error {
+	_, err := s.conn().ExecContext(ctx, `INSERT INTO user_revocations (user_id, revoked_before, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET revoked_before = EXCLUDED.revoked_before, expires_at = EXCLUDED.expires_at, created_at = NOW()`,
+		userID, before, expiresAt)
+	return err
+}
+
+// GetRevokedTokenIDsSince returns unexpired revoked token IDs recorded after since
+func (s *SQLStore) GetRevokedTokenIDsSince(ctx context.Context, since time.Time) (map[string]time.Time, error) {
+	rows, err := s.conn().QueryContext(ctx, "SELECT jti, expires_at FROM revoked_tokens WHERE created_at > $1 AND expires_at > NOW()", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := map[string]time.Time{}
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, err
+		}
+		revoked[jti] = expiresAt
+	}
+	return revoked, rows.Err()
+}
+
+// UserRevocation is a row of user_revocations
+type UserRevocation struct {
+	RevokedBefore time.Time
+	ExpiresAt     time.Time
+}
+
+// GetUserRevocationsSince returns unexpired per-user revocations recorded after since
+func (s *SQLStore) GetUserRevocationsSince(ctx context.Context, since time.Time) (map[int]UserRevocation, error) {
+	rows, err := s.conn().QueryContext(ctx, "SELECT user_id, revoked_before, expires_at FROM user_revocations WHERE created_at > $1 AND expires_at > NOW()", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := map[int]UserRevocation{}
+	for rows.Next() {
+		var userID int
+		var r UserRevocation
+		if err := rows.Scan(&userID, &r.RevokedBefore, &r.ExpiresAt); err != nil {
+			return nil, err
+		}
+		revoked[userID] = r
+	}
+	return revoked, rows.Err()
+}
+
+// DeleteExpiredRevocations removes revocations of tokens that have expired
+func (s *SQLStore) DeleteExpiredRevocations(ctx context.Context) error {
+	if _, err := s.conn().ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at <= NOW()"); err != nil {
+		return err
+	}
+	_, err := s.conn().ExecContext(ctx, "DELETE FROM user_revocations WHERE expires_at <= NOW()")
+	return err
+}
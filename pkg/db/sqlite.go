@@ -0,0 +1,168 @@
+//go:build sqlite
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"car_project/migrations"
+
+	"github.com/golang-migrate/migrate/database"
+	migratesqlite "github.com/golang-migrate/migrate/database/sqlite3"
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteTimeFormat is how go-sqlite3 stores time.Time values; NOW() uses it
+// too so that timestamps compare correctly as text
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+func init() {
+	sql.Register(sqliteDriver, &sqliteCompatDriver{&sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("now", func() string {
+				return time.Now().UTC().Format(sqliteTimeFormat)
+			}, false)
+		},
+	}})
+
+	sqlite = &sqliteBackend{
+		migrations: migrations.SQLite,
+		migrationDriver: func(conn *sql.DB) (database.Driver, error) {
+			return migratesqlite.WithInstance(conn, &migratesqlite.Config{})
+		},
+		busy:       sqliteBusy,
+		constraint: sqliteConstraint,
+	}
+}
+
+// sqliteBusy reports whether err means another connection held the lock
+// for longer than the busy timeout
+func sqliteBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// sqliteConstraint classifies a SQLite constraint violation. SQLite names
+// the check or the columns of the unique constraint after the colon, and
+// nothing for foreign keys.
+func sqliteConstraint(err error) (kind ConstraintKind, name string, ok bool) {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return 0, "", false
+	}
+	switch {
+	case sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey:
+		kind = ForeignKey
+	case sqliteErr.ExtendedCode == sqlite3.ErrConstraintTrigger && strings.HasPrefix(sqliteErr.Error(), "FOREIGN KEY"):
+		// ON DELETE RESTRICT is enforced like a trigger
+		kind = ForeignKey
+	case sqliteErr.ExtendedCode == sqlite3.ErrConstraintCheck:
+		kind = Check
+	case sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique, sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey:
+		kind = Unique
+	default:
+		return 0, "", false
+	}
+	if _, after, ok := strings.Cut(sqliteErr.Error(), "constraint failed: "); ok {
+		name = after
+	}
+	return kind, name, true
+}
+
+type sqliteCompatDriver struct {
+	*sqlite3.SQLiteDriver
+}
+
+func (d *sqliteCompatDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteCompatConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+// sqliteCompatConn binds arguments by number, as Postgres does. SQLite
+// reads $N as a named parameter and numbers parameters in the order they
+// first appear, so go-sqlite3 would bind the arguments of queries that
+// reuse or reorder them to the wrong parameters.
+type sqliteCompatConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c *sqliteCompatConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *sqliteCompatConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.SQLiteConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteCompatStmt{stmt.(*sqlite3.SQLiteStmt)}, nil
+}
+
+// QueryContext and ExecContext leave queries with arguments to database/sql,
+// which then prepares them and runs the statement. Only scripts without
+// arguments, like migrations, run on the connection directly.
+func (c *sqliteCompatConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+func (c *sqliteCompatConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+// CheckNamedValue stores every time in UTC, since SQLite compares the
+// stored text rather than the instants
+func (c *sqliteCompatConn) CheckNamedValue(nv *driver.NamedValue) error {
+	v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	if t, ok := v.(time.Time); ok {
+		v = t.UTC()
+	}
+	nv.Value = v
+	return nil
+}
+
+type sqliteCompatStmt struct {
+	*sqlite3.SQLiteStmt
+}
+
+// NumInput reports that the number of arguments isn't known, since a query
+// may not use all of them, as in Postgres
+func (s *sqliteCompatStmt) NumInput() int { return -1 }
+
+func (s *sqliteCompatStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.SQLiteStmt.QueryContext(ctx, byNumber(args))
+}
+
+func (s *sqliteCompatStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.SQLiteStmt.ExecContext(ctx, byNumber(args))
+}
+
+// byNumber names each argument after its position, so that go-sqlite3 binds
+// it to $N only, rather than also to the Nth parameter of the statement
+func byNumber(args []driver.NamedValue) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Name: strconv.Itoa(arg.Ordinal), Value: arg.Value}
+	}
+	return named
+}
@@ -0,0 +1,292 @@
+//go:build sqlite
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"car_project/migrations"
+	"car_project/pkg/model"
+	"car_project/pkg/query"
+)
+
+func TestSQLiteBindsByNumber(t *testing.T) {
+	conn := openTestSQLite(t)
+	tests := []struct {
+		name, query string
+		args        []interface{}
+		want        string
+	}{
+		{"in order", "SELECT $1 || $2", []interface{}{"a", "b"}, "ab"},
+		{"reordered", "SELECT $2 || $1", []interface{}{"a", "b"}, "ba"},
+		{"reused", "SELECT $2 || $1 || $2", []interface{}{"a", "b"}, "bab"},
+		{"unused", "SELECT $3 || $1", []interface{}{"a", "b", "c"}, "ca"},
+		{"many", "SELECT $11 || $1", []interface{}{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"}, "ka"},
+		{"quoted", "SELECT '$1' || $2", []interface{}{"a", "b"}, "$1b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if err := conn.QueryRow(tt.query, tt.args...).Scan(&got); err != nil || got != tt.want {
+				t.Errorf("%s = %q, %v, want %q", tt.query, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteListUsersSearch(t *testing.T) {
+	store := NewSQLStore(openTestSQLite(t), sqliteDriver, CarDeleteRestrict)
+	ctx := context.Background()
+	for _, name := range []string{"Alice", "bob", "al_x", "alfred"} {
+		if err := store.CreateUser(ctx, model.User{Username: name, Password: "x", Role: model.RoleViewer}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{"ALI", []string{"Alice"}},
+		{"al", []string{"Alice", "al_x", "alfred"}},
+		{"l_", []string{"al_x"}}, // The underscore matches itself only
+		{"%", nil},
+	}
+	for _, tt := range tests {
+		users, err := store.ListUsers(ctx, 1, 10, tt.search)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, u := range users {
+			got = append(got, u.Username)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search %q = %v, want %v", tt.search, got, tt.want)
+		}
+	}
+}
+
+func init() {
+	NewTestSQLStore = func(t *testing.T, onCarDelete CarDeletePolicy) *SQLStore {
+		return NewSQLStore(openTestSQLite(t), sqliteDriver, onCarDelete)
+	}
+}
+
+// openTestSQLite returns a fresh in-memory SQLite database with every
+// migration applied
+func openTestSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	// Every connection to a shared in-memory database sees the same data,
+	// and it lasts until the last one is closed
+	conn, err := sql.Open(sqliteDriver, "file:"+t.Name()+"?mode=memory&cache=shared&_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+
+	if err := runMigrations(conn, sqliteDriver); err != nil {
+		t.Fatalf("running migrations: %v", err)
+	}
+	return conn
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	conn := openTestSQLite(t)
+
+	m, err := newMigrate(conn, sqliteDriver, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, dirty, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ups := upMigrations(sqliteDriver)
+	if latest := ups[len(ups)-1].Version; version != latest || dirty {
+		t.Fatalf("version = %d, dirty = %v, want %d, clean", version, dirty, latest)
+	}
+
+	// NOW() is registered for the migrated schema's defaults and the stores
+	var n int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM users WHERE username LIKE $1 AND created_at <= NOW()", "%").Scan(&n); err != nil {
+		t.Errorf("querying users: %v", err)
+	}
+
+	// Every down migration undoes its up migration, back to an empty schema
+	if err := m.Down(); err != nil {
+		t.Fatalf("migrating down: %v", err)
+	}
+	var tables []string
+	rows, err := conn.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations' ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if len(tables) > 0 {
+		t.Errorf("tables left after migrating down: %v", tables)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("migrating up again: %v", err)
+	}
+}
+
+func TestSQLiteMigrationsMatchPostgres(t *testing.T) {
+	pg := migrationPairs(t, migrations.Postgres)
+	lite := migrationPairs(t, migrations.SQLite)
+	if !reflect.DeepEqual(lite, pg) {
+		t.Errorf("SQLite migrations %v\nPostgres migrations %v\nwant the same versions and names", lite, pg)
+	}
+}
+
+func TestSQLiteConstraintsMigrationRefusesBadRatings(t *testing.T) {
+	conn := openTestSQLite(t)
+	m, err := newMigrate(conn, sqliteDriver, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Back to before the constraints, when any stars could be stored
+	if err := m.Migrate(20261018110000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("INSERT INTO car (id, brand, model, year, color, org_id) VALUES (1, 'Kia', 'Rio', 2020, 'red', 1)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("INSERT INTO ratings (car_id, stars) VALUES (1, 7)"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Up()
+	if err == nil || !strings.Contains(err.Error(), "ratings with stars outside 1 to 5 exist") {
+		t.Fatalf("migrating up = %v, want the out of range stars reported", err)
+	}
+	// Nothing was changed, the rating included
+	var stars int
+	if err := conn.QueryRow("SELECT stars FROM ratings WHERE car_id = 1").Scan(&stars); err != nil || stars != 7 {
+		t.Errorf("stars = %d, %v, want 7 left as it was", stars, err)
+	}
+}
+
+func TestCarPagesOnSQLite(t *testing.T) {
+	store := NewSQLStore(openTestSQLite(t), sqliteDriver, CarDeleteRestrict)
+	ctx := context.Background()
+
+	// Prices repeat so that the brand and then the ID have to break ties
+	cars := []struct {
+		brand string
+		price int
+	}{
+		{"Kia", 20},
+		{"Audi", 30},
+		{"BMW", 20},
+		{"Audi", 20},
+		{"Kia", 30},
+		{"Audi", 20},
+		{"BMW", 10},
+	}
+	for _, c := range cars {
+		car := model.Car{Brand: c.brand, Model: "m", Year: 2020, Color: "red", BasePrice: c.price}
+		if err := store.CreateCar(ctx, 1, car); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Another organization's car never shows up
+	if err := store.CreateCar(ctx, 2, model.Car{Brand: "Audi", Model: "m", Year: 2020, Color: "red", BasePrice: 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	sort, err := CarFields.ParseSort("base_price:desc,brand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// IDs in the order base_price DESC, brand ASC, id ASC puts them in
+	want := []int{2, 5, 4, 6, 3, 1, 7}
+
+	var got []int
+	page := query.Page{Limit: 3}
+	for len(got) <= len(want) {
+		items, err := store.GetCarWithPagination(ctx, 1, page, sort, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range items {
+			got = append(got, c.ID)
+		}
+		if len(items) < page.Limit {
+			break
+		}
+		cursor := CarFields.Cursor(sort, items[len(items)-1])
+		if page.After, err = CarFields.ParseCursor(sort, cursor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("paged through IDs %v, want %v", got, want)
+	}
+}
+
+func TestSQLiteBusyIsRetryable(t *testing.T) {
+	_, source, err := driverFor("sqlite://" + filepath.Join(t.TempDir(), "car.db") + "?_busy_timeout=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := sql.Open(sqliteDriver, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := runMigrations(conn, sqliteDriver); err != nil {
+		t.Fatal(err)
+	}
+
+	// One transaction holds the write lock, so the next can't begin
+	ctx := context.Background()
+	holder, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Rollback()
+	_, err = conn.BeginTx(ctx, nil)
+	if !IsSerializationFailure(err) || !IsUnavailable(err) {
+		t.Errorf("beginning while locked = %v, want a retryable and unavailable error", err)
+	}
+}
+
+func TestSQLiteDeleteOfReferencedRow(t *testing.T) {
+	store := NewSQLStore(openTestSQLite(t), sqliteDriver, CarDeleteRestrict)
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, model.User{Username: "alice", Password: "x", Role: model.RoleViewer}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateCar(ctx, 1, model.Car{Brand: "Kia", Model: "Rio", Year: 2020, Color: "red"}); err != nil {
+		t.Fatal(err)
+	}
+	// Inserting a rating of a missing car is a foreign key violation
+	err := store.CreateRating(ctx, model.Rating{CarID: 9, Stars: 3, UserID: 1})
+	if ce := AsConstraintError(err); ce == nil || ce.Kind != ForeignKey {
+		t.Errorf("rating a missing car = %v, want a foreign key violation", err)
+	}
+
+	// Deleting the user a rating refers to is refused as still referenced
+	if err := store.CreateRating(ctx, model.Rating{CarID: 1, Stars: 3, UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.DB.Exec("DELETE FROM users WHERE id = $1", 1)
+	if ce := AsConstraintError(deleteError(err)); ce == nil || ce.Kind != Referenced {
+		t.Errorf("deleting a rated user = %v, want the user still referenced", err)
+	}
+}
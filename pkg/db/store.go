@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"car_project/pkg/model"
+	"car_project/pkg/query"
+)
+
+// CarStore keeps cars and the grants that share them. Queries are scoped to
+// an organization; cars of other organizations are reported as missing.
+type CarStore interface {
+	CarExists(ctx context.Context, orgID, carID int) (bool, error)
+	CreateCar(ctx context.Context, orgID int, c model.Car) error
+	GetAllCars(ctx context.Context, orgID int) ([]model.Car, error)
+	// GetCarWithPagination and CountCars take a sort, cursor and filter
+	// parsed against CarFields
+	GetCarWithPagination(ctx context.Context, orgID int, page query.Page, sort query.Sort, filter query.Filter) ([]model.Car, error)
+	CountCars(ctx context.Context, orgID int, filter query.Filter) (int, error)
+	// GetCarByID returns nil, nil if there is no such car
+	GetCarByID(ctx context.Context, orgID, id int) (*model.Car, error)
+	UpdateCarByID(ctx context.Context, orgID, id int, c model.Car) (bool, error)
+	DeleteCarByID(ctx context.Context, orgID, id int) (bool, error)
+
+	CarAccess(ctx context.Context, orgID, userID, carID int) (carOrgID int, grant model.Role, found bool, err error)
+	SaveCarGrant(ctx context.Context, grant model.CarGrant) (*model.CarGrant, error)
+	GetCarGrants(ctx context.Context, carID int) ([]model.CarGrant, error)
+	DeleteCarGrant(ctx context.Context, carID, userID int) (bool, error)
+	GetSharedCars(ctx context.Context, userID int) ([]model.Car, error)
+}
+
+// CarHistoryStore keeps the accident and service history of cars
+type CarHistoryStore interface {
+	CreateCarHistory(ctx context.Context, orgID int, carHistory model.CarHistory) error
+	// GetCarAllHistory and CountCarHistory take a sort, cursor and filter
+	// parsed against CarHistoryFields
+	GetCarAllHistory(ctx context.Context, orgID, carID int, page query.Page, sort query.Sort, filter query.Filter) ([]model.CarHistory, error)
+	CountCarHistory(ctx context.Context, orgID, carID int, filter query.Filter) (int, error)
+	// GetCarHistoryByID returns sql.ErrNoRows if there is no such record
+	GetCarHistoryByID(ctx context.Context, orgID, id int) (model.CarHistory, error)
+	// GetCarHistoryCarID returns sql.ErrNoRows if there is no such record
+	GetCarHistoryCarID(ctx context.Context, id int) (int, error)
+	UpdateCarHistory(ctx context.Context, orgID int, carHistory model.CarHistory) (bool, error)
+	DeleteCarHistory(ctx context.Context, orgID, id int) (bool, error)
+}
+
+// RatingStore keeps the ratings users give cars
+type RatingStore interface {
+	CreateRating(ctx context.Context, rating model.Rating) error
+	GetRatingsByCar(ctx context.Context, carID int) ([]model.Rating, error)
+	UpdateRating(ctx context.Context, orgID, carID, userID int, updatedRating model.Rating) (bool, error)
+	DeleteRating(ctx context.Context, orgID, carID, userID int) (bool, error)
+}
+
+// UserStore keeps user accounts. Lookups return sql.ErrNoRows for unknown users.
+type UserStore interface {
+	CreateUser(ctx context.Context, user model.User) error
+	GetUserByID(ctx context.Context, id int) (*model.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*model.User, error)
+	ListUsers(ctx context.Context, page, limit int, search string) ([]model.User, error)
+	UpdateUserProfile(ctx context.Context, userID int, displayName string) error
+	// SetPendingEmail records an address to switch to once it is verified
+	SetPendingEmail(ctx context.Context, userID int, email string) error
+	UpdateUserPassword(ctx context.Context, userID int, hashedPassword string) error
+	DeleteUser(ctx context.Context, userID int, keepRatings bool) error
+}
+
+// SessionStore keeps refresh tokens and the single-use tokens mailed to
+// users to verify their address or reset their password
+type SessionStore interface {
+	CreateRefreshToken(ctx context.Context, rt model.RefreshToken) error
+	// GetRefreshTokenByHash returns sql.ErrNoRows for unknown tokens
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*model.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldID int, next model.RefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int) error
+	RevokeUserRefreshTokensExcept(ctx context.Context, userID int, familyID string) error
+
+	CreateEmailVerification(ctx context.Context, jti string, userID int, email string, expiresAt time.Time) error
+	UseEmailVerification(ctx context.Context, jti string, userID int) error
+	CreatePasswordReset(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error
+	ResetPassword(ctx context.Context, tokenHash, hashedPassword string) (int, error)
+	GetPasswordResetUser(ctx context.Context, tokenHash string) (*model.User, error)
+}
+
+// RevocationStore keeps revoked access tokens for pkg/revocation, which
+// caches them in memory
+type RevocationStore interface {
+	RevokeTokenID(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserTokensBefore(ctx context.Context, userID int, before, expiresAt time.Time) error
+	GetRevokedTokenIDsSince(ctx context.Context, since time.Time) (map[string]time.Time, error)
+	GetUserRevocationsSince(ctx context.Context, since time.Time) (map[int]UserRevocation, error)
+	DeleteExpiredRevocations(ctx context.Context) error
+}
+
+// LoginFailureStore counts failed logins for the lockout policy
+type LoginFailureStore interface {
+	LoginBlockedUntil(ctx context.Context, keys ...string) (time.Time, error)
+	RecordLoginFailure(ctx context.Context, key string, window time.Duration, backoff func(failures int) time.Duration) (int, time.Time, error)
+	ClearLoginFailures(ctx context.Context, key string) error
+	ClearLoginFailuresByID(ctx context.Context, id int) (bool, error)
+	GetLoginFailures(ctx context.Context) ([]model.LoginFailure, error)
+}
+
+// MFAStore keeps TOTP secrets and recovery codes
+type MFAStore interface {
+	SetTOTPSecret(ctx context.Context, userID int, secret string) error
+	EnableTOTP(ctx context.Context, userID int, codeHashes []string) error
+	DisableTOTP(ctx context.Context, userID int) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error)
+}
+
+// APIKeyStore keeps the API keys of machine clients
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key model.APIKey) (*model.APIKey, error)
+	GetAPIKeysByUser(ctx context.Context, userID int) ([]model.APIKey, error)
+	// UseAPIKey returns sql.ErrNoRows for unknown or revoked keys
+	UseAPIKey(ctx context.Context, hash string) (*model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id int) (bool, error)
+}
+
+// IdentityStore keeps single sign-on logins in progress and the provider
+// subjects linked to users
+type IdentityStore interface {
+	CreateOIDCLogin(ctx context.Context, state, nonce, codeVerifier string, linkUserID int, expiresAt time.Time) error
+	ConsumeOIDCLogin(ctx context.Context, state string) (nonce, codeVerifier string, linkUserID int, err error)
+	// GetUserByIdentity returns sql.ErrNoRows for unlinked subjects
+	GetUserByIdentity(ctx context.Context, issuer, subject string) (*model.User, error)
+	LinkIdentity(ctx context.Context, userID int, issuer, subject, email string) error
+	CreateUserWithIdentity(ctx context.Context, user model.User, issuer, subject, email string) (*model.User, error)
+}
+
+// OrgStore keeps organizations, their members and invitations
+type OrgStore interface {
+	CreateOrganization(ctx context.Context, name string, ownerID int) (*model.Organization, error)
+	GetUserOrganizations(ctx context.Context, userID int) ([]model.Organization, error)
+	GetOrgRole(ctx context.Context, orgID, userID int) (string, error)
+	SetActiveOrganization(ctx context.Context, userID, orgID int) (bool, error)
+	CreateOrgInvitation(ctx context.Context, inv model.OrgInvitation) (int, error)
+	AcceptOrgInvitation(ctx context.Context, tokenHash string, userID int, email string) (int, error)
+}
+
+// AdminStore changes accounts on behalf of admins, recording each change
+// in the audit log
+type AdminStore interface {
+	SetUserDisabled(ctx context.Context, adminID, userID int, disabled bool) (bool, error)
+	SetUserRole(ctx context.Context, adminID, userID int, role model.Role) (bool, error)
+	RequirePasswordReset(ctx context.Context, adminID, userID int) (bool, error)
+	GetAuditLog(ctx context.Context, page, limit, targetUserID int) ([]model.AuditEntry, error)
+}
+
+// Store is every store at once, as SQLStore and the in-memory store
+// implement them
+type Store interface {
+	CarStore
+	CarHistoryStore
+	RatingStore
+	UserStore
+	SessionStore
+	RevocationStore
+	LoginFailureStore
+	MFAStore
+	APIKeyStore
+	IdentityStore
+	OrgStore
+	AdminStore
+	Transactor
+}
+
+// SQLStore implements the stores on a PostgreSQL database, or on SQLite
+// in builds with the sqlite tag
+type SQLStore struct {
+	DB          *sql.DB
+	dialect     dialect
+	tx          *sql.Tx // Set on the stores of a unit of work
+	onCarDelete CarDeletePolicy
+}
+
+// conn returns what queries run on: the unit of work's transaction if
+// there is one, or else the pool
+func (s *SQLStore) conn() Conn {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.DB
+}
+
+// CarDeletePolicy is what deleting a car does to its history and ratings.
+// It is a setting rather than part of the schema, so the stores apply it
+// and the foreign keys only ever restrict.
+type CarDeletePolicy int
+
+const (
+	CarDeleteCascade  CarDeletePolicy = iota // Delete them with the car
+	CarDeleteRestrict                        // Fail with ErrCarInUse while it has any
+)
+
+// NewSQLStore returns stores backed by the given database, opened with the
+// named driver
+func NewSQLStore(conn *sql.DB, driverName string, onCarDelete CarDeletePolicy) *SQLStore {
+	return &SQLStore{DB: conn, dialect: dialectFor(driverName), onCarDelete: onCarDelete}
+}
+
+var _ Store = (*SQLStore)(nil)
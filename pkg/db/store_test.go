@@ -0,0 +1,460 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"car_project/pkg/db"
+	"car_project/pkg/db/memory"
+	"car_project/pkg/model"
+	"car_project/pkg/query"
+)
+
+// eachStore runs test against every implementation of the stores, each
+// starting empty, so that the memory store can stand in for the database
+func eachStore(t *testing.T, onCarDelete db.CarDeletePolicy, test func(t *testing.T, store db.Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, memory.New(onCarDelete))
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		if db.NewTestSQLStore == nil {
+			t.Skip("built without the sqlite tag")
+		}
+		test(t, db.NewTestSQLStore(t, onCarDelete))
+	})
+}
+
+// addCar creates a car of the organization and returns its ID. Stores
+// number IDs differently, so cars are told apart by their model.
+func addCar(t *testing.T, store db.Store, orgID int, car model.Car) int {
+	t.Helper()
+	if car.Color == "" {
+		car.Color = "red"
+	}
+	ctx := context.Background()
+	if err := store.CreateCar(ctx, orgID, car); err != nil {
+		t.Fatalf("creating car: %v", err)
+	}
+	cars, err := store.GetAllCars(ctx, orgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cars {
+		if c.Model == car.Model {
+			return c.ID
+		}
+	}
+	t.Fatalf("car %q was not created", car.Model)
+	return 0
+}
+
+func addUser(t *testing.T, store db.Store, username string) int {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, model.User{Username: username, Password: "x", Role: model.RoleViewer}); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	user, err := store.GetUserByUsername(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+// constraint describes a constraint error the way handlers report it
+type constraint struct {
+	Kind  db.ConstraintKind
+	Field string
+}
+
+func constraintOf(err error) *constraint {
+	ce := db.AsConstraintError(err)
+	if ce == nil {
+		return nil
+	}
+	return &constraint{ce.Kind, ce.Field}
+}
+
+func TestStoreConstraints(t *testing.T) {
+	eachStore(t, db.CarDeleteRestrict, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+		carID := addCar(t, store, 1, model.Car{Brand: "Kia", Model: "Rio", Year: 2020})
+		userID := addUser(t, store, "a@example.com")
+		missing := carID + userID + 1000
+
+		tests := []struct {
+			name string
+			err  error
+			want *constraint
+		}{
+			{"rating", store.CreateRating(ctx, model.Rating{CarID: carID, UserID: userID, Stars: 4}), nil},
+			{"second rating by the user", store.CreateRating(ctx, model.Rating{CarID: carID, UserID: userID, Stars: 2}), &constraint{db.Unique, "car_id"}},
+			{"rating of a missing car", store.CreateRating(ctx, model.Rating{CarID: missing, UserID: userID, Stars: 4}), &constraint{db.ForeignKey, ""}},
+			{"too few stars", store.CreateRating(ctx, model.Rating{CarID: carID, Stars: 0}), &constraint{db.Check, "stars"}},
+			{"too many stars", store.CreateRating(ctx, model.Rating{CarID: carID, Stars: 6}), &constraint{db.Check, "stars"}},
+			{"history", store.CreateCarHistory(ctx, 1, model.CarHistory{CarID: carID, Type: "service", Date: time.Now()}), nil},
+			{"history of another type", store.CreateCarHistory(ctx, 1, model.CarHistory{CarID: carID, Type: "crash", Date: time.Now()}), &constraint{db.Check, "type"}},
+			{"history of a missing car", store.CreateCarHistory(ctx, 1, model.CarHistory{CarID: missing, Type: "service", Date: time.Now()}), &constraint{db.ForeignKey, ""}},
+		}
+		for _, tt := range tests {
+			got := constraintOf(tt.err)
+			if tt.err != nil && got == nil {
+				t.Errorf("%s: %v, want a constraint error", tt.name, tt.err)
+				continue
+			}
+			// SQLite doesn't name the foreign key a row breaks
+			if got != nil && tt.want != nil && tt.want.Kind == db.ForeignKey {
+				got.Field = ""
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: got %+v (%v), want %+v", tt.name, got, tt.err, tt.want)
+			}
+		}
+
+		// Failed writes left nothing behind
+		ratings, err := store.GetRatingsByCar(ctx, carID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ratings) != 1 || ratings[0].Stars != 4 {
+			t.Errorf("ratings = %+v, want the first one only", ratings)
+		}
+	})
+}
+
+func TestStoreCarDelete(t *testing.T) {
+	tests := []struct {
+		name        string
+		onCarDelete db.CarDeletePolicy
+		wantErr     error
+	}{
+		{"cascade", db.CarDeleteCascade, nil},
+		{"restrict", db.CarDeleteRestrict, db.ErrCarInUse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eachStore(t, tt.onCarDelete, func(t *testing.T, store db.Store) {
+				ctx := context.Background()
+				carID := addCar(t, store, 1, model.Car{Brand: "Kia", Model: "Rio", Year: 2020})
+				otherID := addCar(t, store, 1, model.Car{Brand: "Kia", Model: "Ceed", Year: 2020})
+				userID := addUser(t, store, "a@example.com")
+				for _, id := range []int{carID, otherID} {
+					if err := store.CreateRating(ctx, model.Rating{CarID: id, UserID: userID, Stars: 4}); err != nil {
+						t.Fatal(err)
+					}
+					if err := store.CreateCarHistory(ctx, 1, model.CarHistory{CarID: id, Type: "service", Date: time.Now()}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if _, err := store.SaveCarGrant(ctx, model.CarGrant{CarID: carID, UserID: userID, Role: model.RoleViewer, GrantedBy: userID}); err != nil {
+					t.Fatal(err)
+				}
+
+				// Another organization can't delete the car
+				if found, err := store.DeleteCarByID(ctx, 2, carID); found || err != nil {
+					t.Fatalf("deleting from another organization = %v, %v, want false, nil", found, err)
+				}
+
+				found, err := store.DeleteCarByID(ctx, 1, carID)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("deleting = %v, want %v", err, tt.wantErr)
+				}
+				exists, err := store.CarExists(ctx, 1, carID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ratings, err := store.GetRatingsByCar(ctx, carID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				history, err := store.GetCarAllHistory(ctx, 1, carID, query.Page{Limit: 10}, nil, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				grants, err := store.GetCarGrants(ctx, carID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.wantErr == nil {
+					if !found || exists || len(ratings) != 0 || len(history) != 0 || len(grants) != 0 {
+						t.Errorf("after a cascading delete: found %v, exists %v, %d ratings, %d history, %d grants, want the car and all of them gone",
+							found, exists, len(ratings), len(history), len(grants))
+					}
+				} else if !exists || len(ratings) != 1 || len(history) != 1 || len(grants) != 1 {
+					t.Errorf("after a restricted delete: exists %v, %d ratings, %d history, %d grants, want everything kept",
+						exists, len(ratings), len(history), len(grants))
+				}
+
+				// The other car is untouched either way
+				ratings, err = store.GetRatingsByCar(ctx, otherID)
+				if err != nil || len(ratings) != 1 {
+					t.Errorf("other car's ratings = %v, %v, want 1", ratings, err)
+				}
+			})
+		})
+	}
+}
+
+func TestStorePaginationOrder(t *testing.T) {
+	cars := []model.Car{
+		{Brand: "Kia", Model: "a", Year: 2020, EngineSize: 1.6},
+		{Brand: "Audi", Model: "b", Year: 2018, EngineSize: 2.0},
+		{Brand: "BMW", Model: "c", Year: 2020, EngineSize: 2.0},
+		{Brand: "Audi", Model: "d", Year: 2020, EngineSize: 1.6},
+		{Brand: "Kia", Model: "e", Year: 2018, EngineSize: 1.0},
+		{Brand: "Audi", Model: "f", Year: 2020, EngineSize: 2.0},
+		{Brand: "BMW", Model: "g", Year: 2019, EngineSize: 3.0},
+	}
+	tests := []struct {
+		sort, filter string
+		want         string
+	}{
+		{"", "", "abcdefg"},
+		{"year:desc,brand", "", "dfcagbe"},
+		{"brand:desc,engine_size", "", "eacgdbf"},
+		{"engine_size:desc", "year>=2019", "gcfad"},
+		{"model:desc", "brand:in:Audi|BMW", "gfdcb"},
+	}
+
+	results := map[string][]string{}
+	eachStore(t, db.CarDeleteRestrict, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+		for _, c := range cars {
+			addCar(t, store, 1, c)
+		}
+		addCar(t, store, 2, model.Car{Brand: "Audi", Model: "other org", Year: 2020})
+
+		for _, tt := range tests {
+			order, err := db.CarFields.ParseSort(tt.sort)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter, err := db.CarFields.ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Paging by offset and by cursor give the same order
+			byOffset, byCursor := "", ""
+			for offset := 0; offset < len(cars); offset += 2 {
+				items, err := store.GetCarWithPagination(ctx, 1, query.Page{Limit: 2, Offset: offset}, order, filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, c := range items {
+					byOffset += c.Model
+				}
+			}
+			page := query.Page{Limit: 2}
+			for {
+				items, err := store.GetCarWithPagination(ctx, 1, page, order, filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, c := range items {
+					byCursor += c.Model
+				}
+				if len(items) < page.Limit {
+					break
+				}
+				cursor := db.CarFields.Cursor(order, items[len(items)-1])
+				if page.After, err = db.CarFields.ParseCursor(order, cursor); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if byOffset != tt.want || byCursor != tt.want {
+				t.Errorf("sort %q, filter %q: by offset %q, by cursor %q, want %q", tt.sort, tt.filter, byOffset, byCursor, tt.want)
+			}
+			count, err := store.CountCars(ctx, 1, filter)
+			if err != nil || count != len(tt.want) {
+				t.Errorf("filter %q: count = %d, %v, want %d", tt.filter, count, err, len(tt.want))
+			}
+			results[t.Name()] = append(results[t.Name()], byCursor)
+		}
+	})
+
+	// The stores agree with each other, whatever they make of the cases
+	var first []string
+	for _, r := range results {
+		if first == nil {
+			first = r
+		} else if !reflect.DeepEqual(r, first) {
+			t.Errorf("stores disagree: %v", results)
+		}
+	}
+}
+
+func TestStoreSecondFactorsAreSingleUse(t *testing.T) {
+	eachStore(t, db.CarDeleteRestrict, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+		userID := addUser(t, store, "alice")
+		if err := store.SetTOTPSecret(ctx, userID, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.EnableTOTP(ctx, userID, []string{"hash-a", "hash-b"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// A step is accepted once, and none before the last one accepted
+		for _, tt := range []struct {
+			step int64
+			want bool
+		}{
+			{100, true},
+			{100, false},
+			{99, false},
+			{101, true},
+		} {
+			if ok, err := store.UseTOTPStep(ctx, userID, tt.step); err != nil || ok != tt.want {
+				t.Errorf("UseTOTPStep(%d) = %v, %v, want %v", tt.step, ok, err, tt.want)
+			}
+		}
+
+		// Each recovery code works once
+		for _, tt := range []struct {
+			hash string
+			want bool
+		}{
+			{"hash-a", true},
+			{"hash-a", false},
+			{"unknown", false},
+			{"hash-b", true},
+		} {
+			if ok, err := store.UseRecoveryCode(ctx, userID, tt.hash); err != nil || ok != tt.want {
+				t.Errorf("UseRecoveryCode(%q) = %v, %v, want %v", tt.hash, ok, err, tt.want)
+			}
+		}
+
+		// New codes replace the old ones, used or not
+		if err := store.ReplaceRecoveryCodes(ctx, userID, []string{"hash-c"}); err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := store.UseRecoveryCode(ctx, userID, "hash-b"); ok {
+			t.Error("a replaced recovery code was accepted")
+		}
+		if ok, err := store.UseRecoveryCode(ctx, userID, "hash-c"); err != nil || !ok {
+			t.Errorf("UseRecoveryCode of a new code = %v, %v, want true", ok, err)
+		}
+	})
+}
+
+func TestStoreDeleteUserReleasesOrganizations(t *testing.T) {
+	eachStore(t, db.CarDeleteRestrict, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+		alice := addUser(t, store, "alice")
+		bob := addUser(t, store, "bob")
+		carol := addUser(t, store, "carol")
+		invite := func(orgID int, hash string) {
+			t.Helper()
+			inv := model.OrgInvitation{OrgID: orgID, Email: hash + "@example.com", TokenHash: hash, InvitedBy: alice, ExpiresAt: time.Now().Add(time.Hour)}
+			if _, err := store.CreateOrgInvitation(ctx, inv); err != nil {
+				t.Fatal(err)
+			}
+		}
+		newOrg := func(name string) int {
+			t.Helper()
+			org, err := store.CreateOrganization(ctx, name, alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return org.ID
+		}
+
+		// Alice owns a team with two members, an organization of her own
+		// with an invitation out, and one with a car
+		team, solo, fleet := newOrg("team"), newOrg("solo"), newOrg("fleet")
+		for _, member := range []int{bob, carol} {
+			invite(team, "team-"+strconv.Itoa(member))
+			if _, err := store.AcceptOrgInvitation(ctx, "team-"+strconv.Itoa(member), member, "team-"+strconv.Itoa(member)+"@example.com"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		invite(solo, "solo")
+		carID := addCar(t, store, fleet, model.Car{Brand: "Kia", Model: "Rio", Year: 2020})
+
+		// Deleting the car would be up to her, so her account stays
+		if err := store.DeleteUser(ctx, alice, true); !errors.Is(err, db.ErrOwnsOrganization) {
+			t.Fatalf("DeleteUser = %v, want ErrOwnsOrganization", err)
+		}
+		if _, err := store.GetUserByID(ctx, alice); err != nil {
+			t.Fatalf("refused delete removed the user: %v", err)
+		}
+		if role, _ := store.GetOrgRole(ctx, team, bob); role != model.OrgRoleMember {
+			t.Errorf("refused delete made bob %q", role)
+		}
+
+		if _, err := store.DeleteCarByID(ctx, fleet, carID); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.DeleteUser(ctx, alice, true); err != nil {
+			t.Fatal(err)
+		}
+
+		// The team passes to the member who joined first, and the empty
+		// organizations go with their invitations
+		for member, want := range map[int]string{bob: model.OrgRoleOwner, carol: model.OrgRoleMember} {
+			if role, err := store.GetOrgRole(ctx, team, member); err != nil || role != want {
+				t.Errorf("role of %d in the team = %q, %v, want %q", member, role, err, want)
+			}
+		}
+		if _, err := store.AcceptOrgInvitation(ctx, "solo", carol, "solo@example.com"); !errors.Is(err, db.ErrInvitationUnusable) {
+			t.Errorf("accepting an invitation to a deleted organization = %v, want ErrInvitationUnusable", err)
+		}
+	})
+}
+
+func TestStoreLoginFailures(t *testing.T) {
+	eachStore(t, db.CarDeleteRestrict, func(t *testing.T, store db.Store) {
+		ctx := context.Background()
+		// Two free failures, then a lock of 50ms per failure
+		backoff := func(failures int) time.Duration {
+			if failures <= 2 {
+				return 0
+			}
+			return time.Duration(failures) * 50 * time.Millisecond
+		}
+
+		for want := 1; want <= 2; want++ {
+			failures, until, err := store.RecordLoginFailure(ctx, "account:a", time.Hour, backoff)
+			if err != nil || failures != want || !until.IsZero() {
+				t.Fatalf("RecordLoginFailure = %d, %v, %v, want %d failures and no lock", failures, until, err, want)
+			}
+		}
+		if until, err := store.LoginBlockedUntil(ctx, "account:a"); err != nil || !until.IsZero() {
+			t.Fatalf("LoginBlockedUntil before the lock = %v, %v", until, err)
+		}
+
+		failures, until, err := store.RecordLoginFailure(ctx, "account:a", time.Hour, backoff)
+		if err != nil || failures != 3 || time.Until(until) <= 100*time.Millisecond {
+			t.Fatalf("RecordLoginFailure = %d, %v, %v, want 3 failures and a 150ms lock", failures, until, err)
+		}
+		blocked, err := store.LoginBlockedUntil(ctx, "ip:1", "account:a")
+		if err != nil || blocked.Sub(until).Abs() > time.Millisecond {
+			t.Errorf("LoginBlockedUntil = %v, %v, want %v", blocked, err, until)
+		}
+
+		// A shorter backoff never cuts a lock short
+		if _, shorter, err := store.RecordLoginFailure(ctx, "account:a", time.Hour, func(int) time.Duration { return time.Millisecond }); err != nil || shorter.Before(until) {
+			t.Errorf("RecordLoginFailure with a shorter backoff = %v, %v, want the lock to stay until %v", shorter, err, until)
+		}
+
+		// Locks expire on their own, and counters start over after the window
+		time.Sleep(time.Until(until) + 10*time.Millisecond)
+		if blocked, err := store.LoginBlockedUntil(ctx, "account:a"); err != nil || !blocked.IsZero() {
+			t.Errorf("LoginBlockedUntil after the lock = %v, %v, want none", blocked, err)
+		}
+		if failures, _, err := store.RecordLoginFailure(ctx, "account:a", time.Nanosecond, backoff); err != nil || failures != 1 {
+			t.Errorf("RecordLoginFailure after the window = %d, %v, want 1", failures, err)
+		}
+
+		// Other keys are counted separately
+		if failures, _, err := store.RecordLoginFailure(ctx, "ip:1", time.Hour, backoff); err != nil || failures != 1 {
+			t.Errorf("RecordLoginFailure of another key = %d, %v, want 1", failures, err)
+		}
+	})
+}
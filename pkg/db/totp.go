@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// SetTOTPSecret starts enrollment by storing a new secret that is not yet
+// required at login
+func (s *SQLStore) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	_, err := s.conn().ExecContext(ctx, "UPDATE users SET totp_secret = $1, totp_enabled = FALSE, totp_last_step = NULL WHERE id = $2", secret, userID)
+	return err
+}
+
+// EnableTOTP requires TOTP at login from now on and replaces the user's
+// recovery codes with the given hashes
+func (s *SQLStore) EnableTOTP(ctx context.Context, userID int, codeHashes []string) error {
+	return s.inTx(ctx, func(tx *SQLStore) error {
+		if _, err := tx.conn().ExecContext(ctx, "UPDATE users SET totp_enabled = TRUE WHERE id = $1", userID); err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(ctx, tx.conn(), userID, codeHashes)
+	})
+}
+
+// DisableTOTP removes the user's secret and recovery codes
+func (s *SQLStore) DisableTOTP(ctx context.Context, userID int) error {
+	return s.inTx(ctx, func(tx *SQLStore) error {
+		if _, err := tx.conn().ExecContext(ctx, "UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = NULL WHERE id = $1", userID); err != nil {
+			return err
+		}
+		_, err := tx.conn().ExecContext(ctx, "DELETE FROM totp_recovery_codes WHERE user_id = $1", userID)
+		return err
+	})
+}
+
+// ReplaceRecoveryCodes invalidates the user's recovery codes and stores new hashes
+func (s *SQLStore) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	return s.inTx(ctx, func(tx *SQLStore) error {
+		return replaceRecoveryCodes(ctx, tx.conn(), userID, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx execer, userID int, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM totp_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseTOTPStep records that a code for the given time step was accepted. It
+// returns false if a code for this or a later step was already used.
+func (s *SQLStore) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	res, err := s.conn().ExecContext(ctx, "UPDATE users SET totp_last_step = $1 WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)", step, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes. It returns
+// false if no unused code has the given hash.
+func (s *SQLStore) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	res, err := s.conn().ExecContext(ctx, "UPDATE totp_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL", userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"time"
+)
+
+// Conn runs queries, either on the connection pool or in a transaction
+type Conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Stores are the stores a unit of work reads and writes through
+type Stores struct {
+	Cars    CarStore
+	History CarHistoryStore
+	Ratings RatingStore
+	Users   UserStore
+}
+
+// Transactor runs units of work atomically
+type Transactor interface {
+	// Transact calls fn with stores whose calls all happen in one
+	// transaction. It commits if fn returns nil and rolls back if fn returns
+	// an error or panics. When the transaction can't be serialized with
+	// concurrent ones fn runs again, so it must have no other side effects.
+	// Calling Transact on the stores of a unit of work joins that unit.
+	Transact(ctx context.Context, fn func(tx Stores) error) error
+}
+
+// maxTxAttempts is how often a unit of work runs before a serialization
+// failure is returned to the caller
+const maxTxAttempts = 3
+
+// Transact implements Transactor with serializable transactions
+func (s *SQLStore) Transact(ctx context.Context, fn func(tx Stores) error) error {
+	return s.inTx(ctx, func(tx *SQLStore) error {
+		return fn(Stores{Cars: tx, History: tx, Ratings: tx, Users: tx})
+	})
+}
+
+// inTx runs fn with stores bound to a transaction, joining the current one
+// if s already belongs to a unit of work
+func (s *SQLStore) inTx(ctx context.Context, fn func(tx *SQLStore) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := s.runTx(ctx, fn)
+		if err == nil || !IsSerializationFailure(err) || attempt == maxTxAttempts {
+			return err
+		}
+
+		// Back off a little, with jitter so the transactions that collided
+		// don't collide again
+		backoff := time.Duration(attempt)*10*time.Millisecond + time.Duration(rand.Int63n(int64(10*time.Millisecond)))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// runTx runs fn once in a new transaction
+func (s *SQLStore) runTx(ctx context.Context, fn func(tx *SQLStore) error) (err error) {
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			tx.Rollback()
+			panic(v)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	store := *s
+	store.tx = tx
+	if err := fn(&store); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
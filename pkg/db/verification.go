@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrVerificationUnusable is returned for verification tokens that are
+// unknown, expired or already used
+var ErrVerificationUnusable = errors.New("verification token is invalid, expired or already used")
+
+// CreateEmailVerification records a verification token that may be used
+// once before expiresAt. A non-empty email makes it confirm that pending
+// address rather than the account.
+func (s *SQLStore) CreateEmailVerification(ctx context.Context, jti string, userID int, email string, expiresAt time.Time) error {
+	_, err := s.conn().ExecContext(ctx, "INSERT INTO email_verifications (jti, user_id, email, expires_at) VALUES ($1, $2, NULLIF($3, ''), $4)", jti, userID, email, expiresAt)
+	return err
+}
+
+// UseEmailVerification consumes the verification token and marks its user
+// as verified, switching them to the address it confirms if it is still
+// their pending one. It returns ErrVerificationUnusable if the token cannot
+// be used.
+func (s *SQLStore) UseEmailVerification(ctx context.Context, jti string, userID int) error {
+	return s.inTx(ctx, func(tx *SQLStore) error {
+		var email string
+		err := tx.conn().QueryRowContext(ctx, "UPDATE email_verifications SET used_at = NOW() WHERE jti = $1 AND user_id = $2 AND used_at IS NULL AND expires_at > NOW() RETURNING COALESCE(email, '')", jti, userID).Scan(&email)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrVerificationUnusable
+		}
+		if err != nil {
+			return err
+		}
+
+		if email == "" {
+			_, err = tx.conn().ExecContext(ctx, "UPDATE users SET verified = TRUE WHERE id = $1", userID)
+			return err
+		}
+		res, err := tx.conn().ExecContext(ctx, "UPDATE users SET email = pending_email, pending_email = NULL, verified = TRUE WHERE id = $1 AND pending_email = $2", userID, email)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// The user asked for another address since this link was sent
+			return ErrVerificationUnusable
+		}
+		return nil
+	})
+}
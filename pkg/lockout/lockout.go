@@ -0,0 +1,104 @@
+package lockout
+
+import (
+	"strings"
+	"time"
+)
+
+// Policy decides how long logins are refused after repeated failures.
+// The first FreeAttempts failures cost nothing, then every failure doubles
+// the wait before the next attempt, starting at BaseDelay and capped at
+// MaxDelay. From LockAfter failures on, the key is locked for LockDuration.
+// Counters start over once Window has passed without a failure.
+type Policy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int
+	LockDuration time.Duration
+	Window       time.Duration
+}
+
+// AccountPolicy applies to a single username
+var AccountPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockAfter:    10,
+	LockDuration: 15 * time.Minute,
+	Window:       time.Hour,
+}
+
+// IPPolicy applies to a client address, which may be shared behind NAT,
+// so it tolerates more failures than AccountPolicy
+var IPPolicy = Policy{
+	FreeAttempts: 10,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockAfter:    50,
+	LockDuration: 15 * time.Minute,
+	Window:       time.Hour,
+}
+
+// ResetAccountPolicy limits password reset emails to a single username, so
+// that its inbox can't be flooded
+var ResetAccountPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Minute,
+	MaxDelay:     15 * time.Minute,
+	LockAfter:    10,
+	LockDuration: time.Hour,
+	Window:       time.Hour,
+}
+
+// ResetIPPolicy limits password reset requests from a client address
+var ResetIPPolicy = Policy{
+	FreeAttempts: 10,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockAfter:    30,
+	LockDuration: time.Hour,
+	Window:       time.Hour,
+}
+
+// Backoff returns how long to refuse logins after the given number of failures
+func (p Policy) Backoff(failures int) time.Duration {
+	if failures >= p.LockAfter {
+		return p.LockDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return delay
+}
+
+// AccountKey is the key failures against a username are counted under.
+// Unknown usernames are counted too, so lockouts reveal nothing.
+func AccountKey(username string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// IPKey is the key failures from a client address are counted under
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// ResetAccountKey is the key password reset requests for a username are
+// counted under
+func ResetAccountKey(username string) string {
+	return "reset:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// ResetIPKey is the key password reset requests from a client address are
+// counted under
+func ResetIPKey(ip string) string {
+	return "reset-ip:" + ip
+}
@@ -0,0 +1,80 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     10 * time.Second,
+		LockAfter:    8,
+		LockDuration: time.Hour,
+		Window:       time.Hour,
+	}
+	for _, tt := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, time.Hour},
+		{100, time.Hour},
+	} {
+		if got := p.Backoff(tt.failures); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	// Delays stop doubling at MaxDelay until the lock
+	p.LockAfter = 20
+	for failures := 8; failures < 20; failures++ {
+		if got := p.Backoff(failures); got != p.MaxDelay {
+			t.Errorf("Backoff(%d) = %v, want the cap %v", failures, got, p.MaxDelay)
+		}
+	}
+}
+
+func TestPoliciesEscalate(t *testing.T) {
+	for name, p := range map[string]Policy{
+		"account":       AccountPolicy,
+		"ip":            IPPolicy,
+		"reset account": ResetAccountPolicy,
+		"reset ip":      ResetIPPolicy,
+	} {
+		// Waits never shrink as failures add up
+		var last time.Duration
+		for failures := 1; failures <= p.LockAfter+1; failures++ {
+			wait := p.Backoff(failures)
+			if wait < last {
+				t.Errorf("%s: Backoff(%d) = %v, shorter than %v before it", name, failures, wait, last)
+			}
+			last = wait
+		}
+		if p.Backoff(p.FreeAttempts) != 0 || p.Backoff(p.LockAfter) != p.LockDuration {
+			t.Errorf("%s: free attempts or lock misplaced", name)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	// Usernames differing in case or surrounding space share a counter, and
+	// the kinds of keys never collide
+	if AccountKey(" Alice ") != AccountKey("alice") {
+		t.Error("account keys depend on case or spacing")
+	}
+	keys := map[string]bool{}
+	for _, key := range []string{AccountKey("x"), IPKey("x"), ResetAccountKey("x"), ResetIPKey("x")} {
+		if keys[key] {
+			t.Errorf("key %q is used for two kinds of counters", key)
+		}
+		keys[key] = true
+	}
+}
@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// LogMailer writes messages to the standard logger, for local development
+type LogMailer struct{}
+
+// Send logs msg
+func (LogMailer) Send(msg Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer writes each message to its own .eml file in Dir, so that
+// tests and local setups can read the links they contain
+type FileMailer struct {
+	Dir  string
+	From string
+	seq  uint64
+}
+
+// Send writes msg to a new file in Dir
+func (m *FileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	n := atomic.AddUint64(&m.seq, 1)
+	name := fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), n)
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0o644)
+}
@@ -0,0 +1,51 @@
+package mail
+
+import "log"
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Default is the mailer used by the application, set by InitMailer
+var Default Mailer = LogMailer{}
+
+// Config picks the mailer: SMTPMailer if SMTPAddr is set, FileMailer if Dir
+// is, and otherwise LogMailer
+type Config struct {
+	From         string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	Dir          string
+}
+
+// InitMailer sets Default to the mailer cfg picks
+func InitMailer(cfg Config) {
+	switch {
+	case cfg.SMTPAddr != "":
+		Default = &SMTPMailer{
+			Addr:     cfg.SMTPAddr,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}
+	case cfg.Dir != "":
+		Default = &FileMailer{Dir: cfg.Dir, From: cfg.From}
+	default:
+		log.Println("no mailer configured, emails will be written to the log")
+		Default = LogMailer{}
+	}
+}
+
+// Send delivers msg through the Default mailer
+func Send(msg Message) error {
+	return Default.Send(msg)
+}
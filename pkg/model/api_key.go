@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+)
+
+// Scopes an API key can be limited to
+const (
+	ScopeCarsRead     = "cars:read"
+	ScopeCarsWrite    = "cars:write"
+	ScopeHistoryRead  = "history:read"
+	ScopeHistoryWrite = "history:write"
+	ScopeRatingsRead  = "ratings:read"
+	ScopeRatingsWrite = "ratings:write"
+)
+
+// AllScopes lists every scope that can be granted to an API key
+var AllScopes = []string{
+	ScopeCarsRead,
+	ScopeCarsWrite,
+	ScopeHistoryRead,
+	ScopeHistoryWrite,
+	ScopeRatingsRead,
+	ScopeRatingsWrite,
+}
+
+// APIKey lets a machine client call /api as the user who created it,
+// limited to its scopes
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	OrgID      int        `json:"org_id"` // Organization whose data the key works on
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // First characters of the key, to tell keys apart
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
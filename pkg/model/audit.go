@@ -0,0 +1,23 @@
+package model
+
+import (
+	"time"
+)
+
+// Actions recorded in the admin audit log
+const (
+	AuditUserDisabled      = "user.disabled"
+	AuditUserEnabled       = "user.enabled"
+	AuditUserRoleChanged   = "user.role_changed"
+	AuditUserPasswordReset = "user.password_reset_forced"
+)
+
+// AuditEntry records a change an admin made to a user account
+type AuditEntry struct {
+	ID           int       `json:"id"`
+	AdminID      int       `json:"admin_id"`
+	Action       string    `json:"action"`
+	TargetUserID int       `json:"target_user_id"`
+	Details      string    `json:"details,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
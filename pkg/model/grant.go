@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+)
+
+// CarGrant gives one user viewer or editor access to a single car and its
+// history, on top of whatever their role allows in their own organization
+type CarGrant struct {
+	ID        int       `json:"id"`
+	CarID     int       `json:"car_id"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      Role      `json:"role"`
+	GrantedBy int       `json:"granted_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package model
+
+import (
+	"time"
+)
+
+// LoginFailure counts recent failed logins for an account or a client IP
+type LoginFailure struct {
+	ID          int        `json:"id"`
+	Key         string     `json:"key"` // "account:<username>", "ip:<address>", "mfa:<user ID>", "reset:<username>" or "reset-ip:<address>"
+	Failures    int        `json:"failures"`
+	LastFailure time.Time  `json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"` // No login attempts are accepted before this time
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+)
+
+// Roles a user can hold within an organization
+const (
+	OrgRoleMember = "member"
+	OrgRoleOwner  = "owner"
+)
+
+// Organization is a tenant, such as a dealership. Cars and their history
+// belong to exactly one organization.
+type Organization struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Role      string    `json:"role,omitempty"` // The caller's role, when listing their organizations
+}
+
+// OrgInvitation lets the holder of an email address join an organization
+type OrgInvitation struct {
+	ID         int        `json:"id"`
+	OrgID      int        `json:"org_id"`
+	Email      string     `json:"email"`
+	TokenHash  string     `json:"-"`
+	InvitedBy  int        `json:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
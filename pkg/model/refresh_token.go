@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+)
+
+// RefreshToken is a stored, hashed refresh token. Tokens issued by rotating
+// one another share a FamilyID so that the whole chain can be revoked at once.
+type RefreshToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"`         // SHA-256 of the opaque token handed to the client
+	FamilyID  string     `json:"family_id"` // Shared by every token in a rotation chain
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"` // Set once the token is rotated or revoked
+	MFA       bool       `json:"mfa"`                  // Whether the session was opened with a second factor
+}
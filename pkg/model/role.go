@@ -0,0 +1,35 @@
+package model
+
+// Role grants a user a level of access to the /api routes
+type Role string
+
+const (
+	RoleViewer Role = "viewer" // Can read cars, history and ratings, and rate cars
+	RoleEditor Role = "editor" // Can also create, update and delete cars and history
+	RoleAdmin  Role = "admin"  // Can do everything
+)
+
+var roleRank = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// AtLeast reports whether r grants at least the access of min
+func (r Role) AtLeast(min Role) bool {
+	return r.Valid() && roleRank[r] >= roleRank[min]
+}
+
+// Max returns the higher of two roles. Unknown roles rank lowest.
+func (r Role) Max(other Role) Role {
+	if roleRank[other] > roleRank[r] {
+		return other
+	}
+	return r
+}
@@ -1,7 +1,9 @@
 package model
 
 import (
-	"golang.org/x/crypto/bcrypt"
+	"time"
+
+	"car_project/pkg/password"
 )
 
 // User struct to represent a user in the system
@@ -9,25 +11,49 @@ type User struct {
 	ID       int    `json:"id"`       // Unique identifier for the user
 	Username string `json:"username"` // User's username, must be unique
 	Password string `json:"password"` // User's hashed password
+	Role     Role   `json:"role"`     // Access level on the /api routes
+	Verified bool   `json:"verified"` // Whether the user has confirmed their email address
+
+	DisplayName  string `json:"display_name"` // Name shown to other users
+	Email        string `json:"email"`        // Address account emails are sent to
+	PendingEmail string `json:"-"`            // New address waiting to be verified before it replaces Email
+
+	TOTPSecret  string `json:"-"`            // Base32 TOTP secret, set once enrollment starts
+	TOTPEnabled bool   `json:"totp_enabled"` // Whether login asks for a TOTP code
+
+	ActiveOrgID int `json:"active_org_id"` // Organization the user's tokens are scoped to; 0 if none
+
+	Disabled              bool       `json:"disabled"`                // Disabled accounts cannot log in or use the API
+	PasswordResetRequired bool       `json:"password_reset_required"` // Login is refused until the password is reset
+	CreatedAt             *time.Time `json:"created_at,omitempty"`    // Unknown for accounts older than the admin API
 }
 
 // CreateUser hashes the password and creates a new user instance
-func (u *User) CreateUser(password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func (u *User) CreateUser(plain string) error {
+	hashedPassword, err := password.Hash(plain)
 	if err != nil {
 		return err
 	}
-	u.Password = string(hashedPassword)
+	u.Password = hashedPassword
 	return nil
 }
 
 // Authenticate checks if the provided password is correct
-func (u *User) Authenticate(password string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
-	if err != nil {
-		// If there is an error (passwords don't match), return false and the error
-		return false, err
+func (u *User) Authenticate(plain string) (bool, error) {
+	return password.Verify(plain, u.Password)
+}
+
+// NeedsRehash reports whether the stored hash predates the current hashing
+// scheme and should be replaced the next time the password is known
+func (u *User) NeedsRehash() bool {
+	return password.NeedsRehash(u.Password)
+}
+
+// ContactEmail returns the address to send account emails to. Accounts
+// created before profiles existed only have their username.
+func (u *User) ContactEmail() string {
+	if u.Email != "" {
+		return u.Email
 	}
-	// If no error, authentication is successful
-	return true, nil
+	return u.Username
 }
@@ -0,0 +1,209 @@
+// Package mock is a minimal OpenID Connect provider for local development
+// and tests. It approves every authorization request without asking
+// anything and issues ID tokens for a fixed, configurable user.
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"car_project/pkg/oidc"
+	"car_project/pkg/token"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// User is the identity the provider logs everyone in as. A login_hint
+// parameter on the authorization request overrides Email.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	AMR           []string
+}
+
+// Provider implements discovery, authorization, token and JWKS endpoints
+type Provider struct {
+	Issuer       string // Base URL the provider is reachable at
+	ClientID     string
+	ClientSecret string // Leave empty to accept public clients
+	User         User
+
+	keys *token.KeySet
+
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+type grant struct {
+	user          User
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	expiresAt     time.Time
+}
+
+// New returns a provider with a fresh signing key
+func New(issuer, clientID, clientSecret string, user User) (*Provider, error) {
+	key, err := token.GenerateEdDSAKey("mock")
+	if err != nil {
+		return nil, err
+	}
+	keys, err := token.NewKeySet(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		User:         user,
+		keys:         keys,
+		codes:        map[string]grant{},
+	}, nil
+}
+
+// ServeHTTP routes the provider's endpoints
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                                p.Issuer,
+			"authorization_endpoint":                p.Issuer + "/authorize",
+			"token_endpoint":                        p.Issuer + "/token",
+			"jwks_uri":                              p.Issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"EdDSA"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	case "/jwks":
+		writeJSON(w, http.StatusOK, p.keys.JWKS())
+	case "/authorize":
+		p.authorize(w, r)
+	case "/token":
+		p.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != p.ClientID {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	user := p.User
+	if hint := q.Get("login_hint"); hint != "" {
+		user.Email = hint
+		user.Subject = "mock|" + hint
+	}
+
+	code, err := oidc.RandomString(16)
+	if err != nil {
+		http.Error(w, "could not issue code", http.StatusInternalServerError)
+		return
+	}
+	p.mu.Lock()
+	p.codes[code] = grant{
+		user:          user,
+		clientID:      q.Get("client_id"),
+		redirectURI:   q.Get("redirect_uri"),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	v := redirectURI.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirectURI.RawQuery = v.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || (p.ClientSecret != "" && secret != p.ClientSecret) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	// Codes are single-use
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	if !ok || time.Now().After(g.expiresAt) || g.clientID != clientID || g.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	if oidc.S256Challenge(r.PostForm.Get("code_verifier")) != g.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer,
+		"sub":            g.user.Subject,
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+		"name":           g.user.Name,
+	}
+	if len(g.user.AMR) > 0 {
+		claims["amr"] = g.user.AMR
+	}
+	idToken, err := p.keys.Sign(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	accessToken, _ := oidc.RandomString(16)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
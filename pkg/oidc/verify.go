@@ -0,0 +1,137 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"car_project/pkg/token"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// keyCache holds the provider's signing keys, refetched when a token names
+// an unknown kid so that provider key rotation is picked up
+type keyCache struct {
+	url string
+
+	mu        sync.Mutex
+	keys      map[string]token.JWK
+	fetchedAt time.Time
+}
+
+// minRefetchInterval keeps tokens with bogus kids from hammering the provider
+const minRefetchInterval = time.Minute
+
+func (p *Provider) verifyIDToken(ctx context.Context, idToken string) (*Claims, error) {
+	t, err := jwt.Parse(idToken, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		jwk, err := p.keyFor(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		key, method, err := jwk.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+
+	mc, ok := t.Claims.(jwt.MapClaims)
+	if !ok || !t.Valid {
+		return nil, errors.New("invalid ID token")
+	}
+	if _, ok := mc["exp"]; !ok {
+		return nil, errors.New("ID token has no expiry")
+	}
+
+	claims := &Claims{}
+	claims.Issuer, _ = mc["iss"].(string)
+	claims.Subject, _ = mc["sub"].(string)
+	claims.Email, _ = mc["email"].(string)
+	claims.EmailVerified, _ = mc["email_verified"].(bool)
+	claims.Name, _ = mc["name"].(string)
+	claims.Nonce, _ = mc["nonce"].(string)
+	if amr, ok := mc["amr"].([]interface{}); ok {
+		for _, m := range amr {
+			if s, ok := m.(string); ok {
+				claims.AMR = append(claims.AMR, s)
+			}
+		}
+	}
+
+	if claims.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("ID token issued by %q, expected %q", claims.Issuer, p.cfg.Issuer)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	if !hasAudience(mc["aud"], p.cfg.ClientID) {
+		return nil, errors.New("ID token is not meant for this client")
+	}
+	return claims, nil
+}
+
+// hasAudience accepts the aud claim as a string or a list of strings,
+// which jwt-go's own check does not
+func hasAudience(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *Provider) keyFor(ctx context.Context, kid string) (token.JWK, error) {
+	c := p.keys
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.lookup(kid); ok {
+		return key, nil
+	}
+	if time.Since(c.fetchedAt) < minRefetchInterval && c.keys != nil {
+		return token.JWK{}, fmt.Errorf("unknown kid %q", kid)
+	}
+
+	var set token.JWKSet
+	if err := p.getJSON(ctx, c.url, &set); err != nil {
+		return token.JWK{}, fmt.Errorf("fetching provider keys: %v", err)
+	}
+	c.keys = map[string]token.JWK{}
+	for _, k := range set.Keys {
+		c.keys[k.Kid] = k
+	}
+	c.fetchedAt = time.Now()
+
+	if key, ok := c.lookup(kid); ok {
+		return key, nil
+	}
+	return token.JWK{}, fmt.Errorf("unknown kid %q", kid)
+}
+
+// lookup finds a key by kid; a token without kid is accepted only when the
+// provider publishes a single key. c.mu must be held.
+func (c *keyCache) lookup(kid string) (token.JWK, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, k := range c.keys {
+			return k, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
@@ -0,0 +1,87 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	argon, err := Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(argon, "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Errorf("Hash = %q, want an argon2id hash with the default parameters", argon)
+	}
+	if again, _ := Hash("correct horse"); again == argon {
+		t.Error("two hashes of the same password are equal, the salt is not random")
+	}
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, hash, plain string
+		ok, err           bool
+	}{
+		{"argon2id", argon, "correct horse", true, false},
+		{"argon2id wrong password", argon, "correct horsE", false, false},
+		{"bcrypt", string(legacy), "correct horse", true, false},
+		{"bcrypt wrong password", string(legacy), "battery staple", false, false},
+		{"plain text", "correct horse", "correct horse", false, true},
+		{"truncated argon2id", argon[:strings.LastIndex(argon, "$")], "correct horse", false, true},
+		{"other argon2 version", strings.Replace(argon, "v=19", "v=16", 1), "correct horse", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := Verify(tt.plain, tt.hash)
+			if ok != tt.ok || (err != nil) != tt.err {
+				t.Errorf("Verify = %v, %v, want %v with error %v", ok, err, tt.ok, tt.err)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	current, err := Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A hash made before the parameters were raised
+	saved := DefaultParams
+	DefaultParams.Memory /= 2
+	old, err := Hash("correct horse")
+	DefaultParams = saved
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, hash string
+		want       bool
+	}{
+		{"current parameters", current, false},
+		{"bcrypt", string(legacy), true},
+		{"other parameters", old, true},
+		{"unknown format", "correct horse", true},
+	}
+	for _, tt := range tests {
+		if got := NeedsRehash(tt.hash); got != tt.want {
+			t.Errorf("%s: NeedsRehash = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Hashes made with old parameters still verify
+	if ok, err := Verify("correct horse", old); !ok || err != nil {
+		t.Errorf("Verify of a hash with other parameters = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,101 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+//go:embed common.txt
+var commonPasswords string
+
+// Policy decides which passwords users may choose
+type Policy struct {
+	MinLength int                 // In characters
+	MaxLength int                 // Caps the work spent hashing one request
+	Blocklist map[string]struct{} // Lowercased passwords that may never be used
+}
+
+// Default is the policy applied by Check
+var Default = Policy{
+	MinLength: 8,
+	MaxLength: 128,
+	Blocklist: readBlocklist(strings.NewReader(commonPasswords), nil),
+}
+
+// InitPolicy sets the minimum length of Default and adds the passwords
+// listed in blocklistFile, if given, to its blocklist
+func InitPolicy(minLength int, blocklistFile string) error {
+	if minLength < 1 || minLength > Default.MaxLength {
+		return fmt.Errorf("password minimum length must be between 1 and %d", Default.MaxLength)
+	}
+	Default.MinLength = minLength
+
+	if blocklistFile != "" {
+		f, err := os.Open(blocklistFile)
+		if err != nil {
+			return fmt.Errorf("could not read password blocklist: %w", err)
+		}
+		defer f.Close()
+		Default.Blocklist = readBlocklist(f, Default.Blocklist)
+	}
+	return nil
+}
+
+// readBlocklist adds one password per line to list, skipping blank lines
+// and # comments
+func readBlocklist(r io.Reader, list map[string]struct{}) map[string]struct{} {
+	if list == nil {
+		list = make(map[string]struct{})
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list[strings.ToLower(line)] = struct{}{}
+	}
+	return list
+}
+
+// Check returns an error describing why the password may not be used by
+// the given user, or nil if it is acceptable
+func (p Policy) Check(plain, username string) error {
+	length := utf8.RuneCountInString(plain)
+	if length < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if length > p.MaxLength {
+		return fmt.Errorf("password must be at most %d characters long", p.MaxLength)
+	}
+
+	lower := strings.ToLower(plain)
+	if _, ok := p.Blocklist[lower]; ok {
+		return errors.New("password is too common, choose another one")
+	}
+
+	// Both the whole username and, for email addresses, the mailbox name
+	username = strings.ToLower(username)
+	names := []string{username}
+	if at := strings.LastIndex(username, "@"); at > 0 {
+		names = append(names, username[:at])
+	}
+	for _, name := range names {
+		if utf8.RuneCountInString(name) >= 3 && strings.Contains(lower, name) {
+			return errors.New("password must not contain the username")
+		}
+	}
+
+	return nil
+}
+
+// Check applies the Default policy
+func Check(plain, username string) error {
+	return Default.Check(plain, username)
+}
@@ -0,0 +1,148 @@
+package query
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Match reports whether item, a struct whose JSON names are the field names
+// of the filter, meets every condition. It is the in-memory counterpart of
+// the SQL a store compiles the filter to.
+func (f Filter) Match(item interface{}) bool {
+	v := reflect.ValueOf(item)
+	for _, cond := range f {
+		if !cond.match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) match(v reflect.Value) bool {
+	if c.Field == "" {
+		for _, name := range c.Search {
+			if contains(fieldByJSON(v, name), c.Values[0]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	field := fieldByJSON(v, c.Field)
+	switch c.Op {
+	case Contains:
+		return contains(field, c.Values[0])
+	case In:
+		for _, want := range c.Values {
+			if cmp, ok := compare(field, want); ok && cmp == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	cmp, ok := compare(field, c.Values[0])
+	if !ok {
+		return false
+	}
+	switch c.Op {
+	case Eq:
+		return cmp == 0
+	case Ne:
+		return cmp != 0
+	case Gt:
+		return cmp > 0
+	case Gte:
+		return cmp >= 0
+	case Lt:
+		return cmp < 0
+	case Lte:
+		return cmp <= 0
+	}
+	return false
+}
+
+// SortSlice orders items, a slice of structs, by the keys of Keys in turn
+func (s Sort) SortSlice(items interface{}) {
+	keys := s.Keys()
+	v := reflect.ValueOf(items)
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := v.Index(i), v.Index(j)
+		for _, key := range keys {
+			cmp, _ := compare(fieldByJSON(a, key.Field), fieldByJSON(b, key.Field).Interface())
+			if cmp == 0 {
+				continue
+			}
+			return (cmp < 0) != key.Desc
+		}
+		return false
+	})
+}
+
+// fieldByJSON returns the field of struct v with the given JSON name
+func fieldByJSON(v reflect.Value, name string) reflect.Value {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("json"), ",")[0] == name {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// compare orders a field against a value parsed for it, returning false if
+// they can't be compared
+func compare(field reflect.Value, value interface{}) (int, bool) {
+	if !field.IsValid() {
+		return 0, false
+	}
+	switch field.Kind() {
+	case reflect.Int, reflect.Int64:
+		switch w := value.(type) {
+		case int64:
+			return sign(float64(field.Int()) - float64(w)), true
+		case int:
+			return sign(float64(field.Int()) - float64(w)), true
+		case float64:
+			return sign(float64(field.Int()) - w), true
+		}
+	case reflect.Float64:
+		switch w := value.(type) {
+		case float64:
+			return sign(field.Float() - w), true
+		case int64:
+			return sign(field.Float() - float64(w)), true
+		}
+	case reflect.String:
+		if w, ok := value.(string); ok {
+			return strings.Compare(field.String(), w), true
+		}
+	default:
+		if t, ok := field.Interface().(time.Time); ok {
+			if w, ok := value.(time.Time); ok {
+				return t.Compare(w), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func contains(field reflect.Value, value interface{}) bool {
+	w, ok := value.(string)
+	if !ok || !field.IsValid() || field.Kind() != reflect.String {
+		return false
+	}
+	return strings.Contains(strings.ToLower(field.String()), strings.ToLower(w))
+}
+
+func sign(d float64) int {
+	switch {
+	case d < 0:
+		return -1
+	case d > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,138 @@
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tiebreaker is the field every list is finally ordered by, so that the
+// order is total and a cursor names exactly one position
+const tiebreaker = "id"
+
+// Page selects part of a sorted list: the items after a cursor, or else the
+// items at an offset
+type Page struct {
+	Limit  int
+	Offset int
+	// After holds the values of Sort.Keys of the last item already seen,
+	// as returned by Schema.ParseCursor
+	After []interface{}
+}
+
+// Keys returns the sort followed by the ID, unless it already orders by it
+func (s Sort) Keys() Sort {
+	for _, key := range s {
+		if key.Field == tiebreaker {
+			return s
+		}
+	}
+	return append(s[:len(s):len(s)], SortKey{Field: tiebreaker})
+}
+
+// CheckCursor checks that values, such as Page.After, hold one value for
+// each of Keys, as a cursor for this sort does
+func (s Sort) CheckCursor(values []interface{}) error {
+	if keys := s.Keys(); len(values) != len(keys) {
+		return fmt.Errorf("the cursor has %d values, the sort %d keys", len(values), len(keys))
+	}
+	return nil
+}
+
+// String formats the sort the way ParseSort reads it
+func (s Sort) String() string {
+	parts := make([]string, len(s))
+	for i, key := range s {
+		parts[i] = key.Field
+		if key.Desc {
+			parts[i] += ":desc"
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Follows reports whether item comes after the position given by values,
+// the values of Keys of another item
+func (s Sort) Follows(item interface{}, values []interface{}) bool {
+	v := reflect.ValueOf(item)
+	for i, key := range s.Keys() {
+		cmp, _ := compare(fieldByJSON(v, key.Field), values[i])
+		if cmp == 0 {
+			continue
+		}
+		return (cmp > 0) != key.Desc
+	}
+	return false
+}
+
+// cursor is what an opaque cursor encodes
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// Cursor returns an opaque cursor for the position just after item in the
+// sort, for Page.After through ParseCursor
+func (s Schema) Cursor(sort Sort, item interface{}) string {
+	v := reflect.ValueOf(item)
+	c := cursor{Sort: sort.String()}
+	for _, key := range sort.Keys() {
+		c.Values = append(c.Values, formatValue(fieldByJSON(v, key.Field)))
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCursor decodes a cursor that Cursor made for the same sort
+func (s Schema) ParseCursor(sort Sort, text string) ([]interface{}, error) {
+	invalid := errors.New("invalid cursor")
+
+	data, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return nil, invalid
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, invalid
+	}
+	if c.Sort != sort.String() {
+		return nil, errors.New("the cursor was made for a different sortBy")
+	}
+
+	keys := sort.Keys()
+	if len(c.Values) != len(keys) {
+		return nil, invalid
+	}
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		field, ok := s.Fields[key.Field]
+		if !ok {
+			return nil, invalid
+		}
+		if values[i], err = parseValue(field.Type, c.Values[i]); err != nil {
+			return nil, invalid
+		}
+	}
+	return values, nil
+}
+
+// formatValue writes a field value so that parseValue reads it back
+func formatValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case reflect.String:
+		return v.String()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,262 @@
+// Package query parses the filter and sort parameters of list endpoints.
+//
+// A filter is a comma separated list of conditions that must all hold:
+//
+//	year>=2015,brand:in:BMW|Audi,horsepower<300
+//
+// A condition is either field OP value, where OP is one of = != > >= < <=,
+// or field:op:value, where op is one of eq ne gt gte lt lte in contains.
+// in takes several values separated by |. A condition that is just a word
+// searches the resource's text fields, like the old filterBy did.
+//
+// A sort is a comma separated list of fields, each optionally followed by
+// :asc or :desc, for example year:desc,brand. "year DESC" is accepted too.
+//
+// Only the fields and operators listed in a resource's Schema are accepted,
+// and values are parsed to the field's type before they reach a query.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type is the type values of a field are parsed to
+type Type int
+
+const (
+	String Type = iota // string
+	Int                // int64
+	Float              // float64
+	Time               // time.Time, given as RFC 3339 or YYYY-MM-DD
+)
+
+// Op is a filter operator
+type Op string
+
+const (
+	Eq       Op = "eq"
+	Ne       Op = "ne"
+	Gt       Op = "gt"
+	Gte      Op = "gte"
+	Lt       Op = "lt"
+	Lte      Op = "lte"
+	In       Op = "in"
+	Contains Op = "contains" // Case-insensitive substring match
+)
+
+// Operator sets for the usual kinds of field
+var (
+	Ordered = []Op{Eq, Ne, Gt, Gte, Lt, Lte, In}
+	Text    = []Op{Eq, Ne, In, Contains}
+)
+
+// symbols maps the short form of operators, longest first
+var symbols = []struct {
+	symbol string
+	op     Op
+}{{">=", Gte}, {"<=", Lte}, {"!=", Ne}, {"=", Eq}, {">", Gt}, {"<", Lt}}
+
+const (
+	maxConditions = 20
+	maxValues     = 50
+	maxSortKeys   = 5
+)
+
+// Field is something clients may filter or sort by
+type Field struct {
+	Column   string // SQL column
+	Type     Type
+	Ops      []Op // Operators allowed in filters, none if it can't be filtered
+	Sortable bool
+}
+
+// Schema lists the fields of a resource by their API name, which is the
+// JSON name of the matching struct field
+type Schema struct {
+	Fields map[string]Field
+	Search []string // String fields a bare word is searched in
+}
+
+// Condition is one parsed filter condition
+type Condition struct {
+	Field  string
+	Op     Op
+	Values []interface{} // One, or several for In
+
+	// Search lists the fields of a bare word search, which holds if any of
+	// them contains the value; Field is empty then
+	Search []string
+}
+
+// Filter holds conditions that must all hold
+type Filter []Condition
+
+// SortKey is one level of a sort
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// Sort orders by each key in turn
+type Sort []SortKey
+
+// ParseFilter parses a filter against the schema. An empty text is an
+// empty filter.
+func (s Schema) ParseFilter(text string) (Filter, error) {
+	var filter Filter
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if len(filter) == maxConditions {
+			return nil, fmt.Errorf("a filter may have at most %d conditions", maxConditions)
+		}
+		cond, err := s.parseCondition(part)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, cond)
+	}
+	return filter, nil
+}
+
+func (s Schema) parseCondition(part string) (Condition, error) {
+	name, op, raw, ok := splitCondition(part)
+	if !ok {
+		if len(s.Search) == 0 {
+			return Condition{}, fmt.Errorf("invalid condition %q, expected field, operator and value", part)
+		}
+		return Condition{Op: Contains, Values: []interface{}{part}, Search: s.Search}, nil
+	}
+
+	field, known := s.Fields[name]
+	if !known {
+		return Condition{}, fmt.Errorf("unknown filter field %q", name)
+	}
+	if !allows(field.Ops, op) {
+		return Condition{}, fmt.Errorf("field %q can't be filtered with %s", name, op)
+	}
+
+	raws := []string{raw}
+	if op == In {
+		raws = strings.Split(raw, "|")
+		if len(raws) > maxValues {
+			return Condition{}, fmt.Errorf("in takes at most %d values", maxValues)
+		}
+	}
+	cond := Condition{Field: name, Op: op}
+	for _, r := range raws {
+		v, err := parseValue(field.Type, strings.TrimSpace(r))
+		if err != nil {
+			return Condition{}, fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+		cond.Values = append(cond.Values, v)
+	}
+	return cond, nil
+}
+
+// splitCondition splits field OP value or field:op:value, whichever form
+// comes first in part
+func splitCondition(part string) (field string, op Op, value string, ok bool) {
+	symbolAt := strings.IndexAny(part, "=!<>")
+	colonAt := strings.Index(part, ":")
+
+	if colonAt >= 0 && (symbolAt < 0 || colonAt < symbolAt) {
+		rest := part[colonAt+1:]
+		name, value, found := strings.Cut(rest, ":")
+		if !found {
+			return "", "", "", false
+		}
+		return strings.TrimSpace(part[:colonAt]), Op(strings.ToLower(name)), value, true
+	}
+	if symbolAt < 0 {
+		return "", "", "", false
+	}
+	for _, s := range symbols {
+		if strings.HasPrefix(part[symbolAt:], s.symbol) {
+			return strings.TrimSpace(part[:symbolAt]), s.op, part[symbolAt+len(s.symbol):], true
+		}
+	}
+	return "", "", "", false
+}
+
+func allows(ops []Op, op Op) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func parseValue(t Type, raw string) (interface{}, error) {
+	switch t {
+	case Int:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a whole number", raw)
+		}
+		return v, nil
+	case Float:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", raw)
+		}
+		return v, nil
+	case Time:
+		if v, err := time.Parse(time.RFC3339, raw); err == nil {
+			return v, nil
+		}
+		v, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a date like 2024-05-17 or 2024-05-17T10:00:00Z", raw)
+		}
+		return v, nil
+	default:
+		return raw, nil
+	}
+}
+
+// ParseSort parses a sort against the schema. An empty text is an empty sort.
+func (s Schema) ParseSort(text string) (Sort, error) {
+	var sort Sort
+	seen := map[string]bool{}
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if len(sort) == maxSortKeys {
+			return nil, fmt.Errorf("a sort may have at most %d fields", maxSortKeys)
+		}
+
+		name, dir, _ := strings.Cut(part, ":")
+		if fields := strings.Fields(part); len(fields) == 2 && !strings.Contains(part, ":") {
+			name, dir = fields[0], fields[1]
+		}
+		name = strings.TrimSpace(name)
+
+		key := SortKey{Field: name}
+		switch strings.ToLower(strings.TrimSpace(dir)) {
+		case "", "asc":
+		case "desc":
+			key.Desc = true
+		default:
+			return nil, fmt.Errorf("invalid sort direction %q, expected asc or desc", dir)
+		}
+
+		if field, ok := s.Fields[name]; !ok || !field.Sortable {
+			return nil, fmt.Errorf("can't sort by %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%q appears twice in the sort", name)
+		}
+		seen[name] = true
+		sort = append(sort, key)
+	}
+	return sort, nil
+}
@@ -0,0 +1,210 @@
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSchema = Schema{
+	Fields: map[string]Field{
+		"id":      {Column: "id", Type: Int, Ops: Ordered, Sortable: true},
+		"brand":   {Column: "brand", Type: String, Ops: Text, Sortable: true},
+		"price":   {Column: "base_price", Type: Float, Ops: Ordered, Sortable: true},
+		"date":    {Column: "date", Type: Time, Ops: Ordered, Sortable: true},
+		"year":    {Column: "year", Type: Int, Ops: Ordered, Sortable: true},
+		"color":   {Column: "color", Type: String, Ops: Text, Sortable: true},
+		"type":    {Column: "type", Type: String, Ops: []Op{Eq, In}},
+		"details": {Column: "details", Type: String},
+	},
+	Search: []string{"brand", "details"},
+}
+
+func TestParseFilter(t *testing.T) {
+	day := time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)
+	instant := time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		text string
+		want Filter
+		err  string
+	}{
+		{name: "empty", text: "", want: nil},
+		{name: "only commas", text: " , ,", want: nil},
+		{
+			name: "symbol operators",
+			text: "id=1,id!=2,id>3,id>=4,id<5,id<=6",
+			want: Filter{
+				{Field: "id", Op: Eq, Values: []interface{}{int64(1)}},
+				{Field: "id", Op: Ne, Values: []interface{}{int64(2)}},
+				{Field: "id", Op: Gt, Values: []interface{}{int64(3)}},
+				{Field: "id", Op: Gte, Values: []interface{}{int64(4)}},
+				{Field: "id", Op: Lt, Values: []interface{}{int64(5)}},
+				{Field: "id", Op: Lte, Values: []interface{}{int64(6)}},
+			},
+		},
+		{
+			name: "word operators",
+			text: "id:gte:4,brand:contains:mw,brand:NE:Audi",
+			want: Filter{
+				{Field: "id", Op: Gte, Values: []interface{}{int64(4)}},
+				{Field: "brand", Op: Contains, Values: []interface{}{"mw"}},
+				{Field: "brand", Op: Ne, Values: []interface{}{"Audi"}},
+			},
+		},
+		{
+			name: "spaces around parts",
+			text: " price < 9.5 , brand = BMW ",
+			want: Filter{
+				{Field: "price", Op: Lt, Values: []interface{}{9.5}},
+				{Field: "brand", Op: Eq, Values: []interface{}{"BMW"}},
+			},
+		},
+		{
+			name: "value holding an operator",
+			text: "brand:eq:a=b",
+			want: Filter{{Field: "brand", Op: Eq, Values: []interface{}{"a=b"}}},
+		},
+		{
+			name: "in with pipes",
+			text: "brand:in:BMW| Audi |Kia,id:in:1|2",
+			want: Filter{
+				{Field: "brand", Op: In, Values: []interface{}{"BMW", "Audi", "Kia"}},
+				{Field: "id", Op: In, Values: []interface{}{int64(1), int64(2)}},
+			},
+		},
+		{
+			name: "dates",
+			text: "date>=2024-05-17,date<2024-05-17T10:30:00Z",
+			want: Filter{
+				{Field: "date", Op: Gte, Values: []interface{}{day}},
+				{Field: "date", Op: Lt, Values: []interface{}{instant}},
+			},
+		},
+		{
+			name: "bare word search",
+			text: "civic,id>1",
+			want: Filter{
+				{Op: Contains, Values: []interface{}{"civic"}, Search: []string{"brand", "details"}},
+				{Field: "id", Op: Gt, Values: []interface{}{int64(1)}},
+			},
+		},
+		{
+			name: "bare word with one colon",
+			text: "a:b",
+			want: Filter{{Op: Contains, Values: []interface{}{"a:b"}, Search: []string{"brand", "details"}}},
+		},
+		{name: "unknown field", text: "owner=1", err: `unknown filter field "owner"`},
+		{name: "unknown field in word form", text: "owner:eq:1", err: `unknown filter field "owner"`},
+		{name: "unknown operator", text: "id:like:1", err: `field "id" can't be filtered with like`},
+		{name: "disallowed operator", text: "brand>B", err: `field "brand" can't be filtered with gt`},
+		{name: "field without operators", text: "details=x", err: `field "details" can't be filtered with eq`},
+		{name: "contains on a number", text: "id:contains:1", err: `field "id" can't be filtered with contains`},
+		{name: "bad int", text: "id=abc", err: `invalid value for "id": "abc" is not a whole number`},
+		{name: "bad float", text: "price>cheap", err: `invalid value for "price": "cheap" is not a number`},
+		{name: "bad date", text: "date=17/05/2024", err: `invalid value for "date"`},
+		{name: "bad value in list", text: "id:in:1|x", err: `"x" is not a whole number`},
+		{name: "too many conditions", text: strings.Repeat("id=1,", maxConditions+1), err: "at most 20 conditions"},
+		{name: "as many conditions as allowed", text: strings.Repeat("id=1,", maxConditions), want: repeatCondition(Condition{Field: "id", Op: Eq, Values: []interface{}{int64(1)}}, maxConditions)},
+		{name: "too many values", text: "id:in:" + strings.Repeat("1|", maxValues) + "1", err: "at most 50 values"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testSchema.ParseFilter(tt.text)
+			checkErr(t, err, tt.err)
+			if tt.err == "" && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFilter(%q)\n got %#v\nwant %#v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterWithoutSearch(t *testing.T) {
+	schema := Schema{Fields: testSchema.Fields}
+	_, err := schema.ParseFilter("civic")
+	checkErr(t, err, `invalid condition "civic"`)
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want Sort
+		err  string
+	}{
+		{name: "empty", text: "", want: nil},
+		{name: "one field", text: "brand", want: Sort{{Field: "brand"}}},
+		{
+			name: "directions",
+			text: "price:desc,brand:asc,date:DESC",
+			want: Sort{{Field: "price", Desc: true}, {Field: "brand"}, {Field: "date", Desc: true}},
+		},
+		{
+			name: "SQL-like form",
+			text: "price DESC, brand asc",
+			want: Sort{{Field: "price", Desc: true}, {Field: "brand"}},
+		},
+		{name: "unknown field", text: "owner", err: `can't sort by "owner"`},
+		{name: "unsortable field", text: "type:asc", err: `can't sort by "type"`},
+		{name: "column name is not a field", text: "base_price", err: `can't sort by "base_price"`},
+		{name: "bad direction", text: "brand:up", err: `invalid sort direction "up"`},
+		{name: "injection", text: "brand; DROP TABLE cars", err: `can't sort by`},
+		{name: "repeated field", text: "brand,brand:desc", err: `"brand" appears twice`},
+		{name: "too many fields", text: "id,brand,price,date,year,color", err: "at most 5 fields"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testSchema.ParseSort(tt.text)
+			checkErr(t, err, tt.err)
+			if tt.err == "" && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSort(%q) = %#v, want %#v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortKeys(t *testing.T) {
+	tests := []struct {
+		sort, want Sort
+	}{
+		{nil, Sort{{Field: "id"}}},
+		{Sort{{Field: "brand", Desc: true}}, Sort{{Field: "brand", Desc: true}, {Field: "id"}}},
+		{Sort{{Field: "id", Desc: true}, {Field: "brand"}}, Sort{{Field: "id", Desc: true}, {Field: "brand"}}},
+	}
+	for _, tt := range tests {
+		if got := tt.sort.Keys(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v.Keys() = %v, want %v", tt.sort, got, tt.want)
+		}
+	}
+
+	// Keys must not write into the sort's spare capacity
+	sort := make(Sort, 1, 4)
+	sort[0] = SortKey{Field: "brand"}
+	a, b := sort.Keys(), append(sort, SortKey{Field: "price"})
+	if a[1].Field != "id" || b[1].Field != "price" {
+		t.Errorf("Keys shares its backing array with the sort: %v, %v", a, b)
+	}
+}
+
+func repeatCondition(cond Condition, n int) Filter {
+	filter := make(Filter, n)
+	for i := range filter {
+		filter[i] = cond
+	}
+	return filter
+}
+
+// checkErr fails the test unless err contains want, or is nil if want is empty
+func checkErr(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case want != "" && err == nil:
+		t.Fatalf("no error, want one containing %q", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Fatalf("error %q, want one containing %q", err, want)
+	}
+}
@@ -0,0 +1,153 @@
+package revocation
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"car_project/pkg/db"
+)
+
+// SyncInterval is how often revocations made by other instances are picked
+// up from the database and expired entries are dropped
+const SyncInterval = 15 * time.Second
+
+// syncOverlap re-reads a little of the previous window to absorb clock
+// differences between the application and the database
+const syncOverlap = 5 * time.Second
+
+// Store answers whether an access token has been revoked. Every revocation
+// is written to the database and kept in memory until the tokens it covers
+// have expired, so checks on the request path never touch the database.
+type Store struct {
+	db       db.RevocationStore
+	mu       sync.RWMutex
+	tokens   map[string]time.Time      // jti -> token expiry
+	users    map[int]db.UserRevocation // user ID -> cutoff
+	lastSync time.Time
+}
+
+// New returns an empty store that records revocations in store. It only
+// learns of revocations made elsewhere once Run or Sync is called.
+func New(store db.RevocationStore) *Store {
+	return &Store{
+		db:     store,
+		tokens: map[string]time.Time{},
+		users:  map[int]db.UserRevocation{},
+	}
+}
+
+// Run loads the current revocations and keeps s in sync with the database
+// from then on, for as long as the process runs
+func (s *Store) Run() {
+	if err := s.syncWithTimeout(); err != nil {
+		log.Fatalf("could not load token revocations: %v", err)
+	}
+	go func() {
+		for range time.Tick(SyncInterval) {
+			if err := s.syncWithTimeout(); err != nil {
+				log.Printf("could not sync token revocations: %v", err)
+			}
+		}
+	}()
+}
+
+// syncWithTimeout syncs s, giving up before the next sync is due
+func (s *Store) syncWithTimeout() error {
+	ctx, cancel := context.WithTimeout(context.Background(), SyncInterval)
+	defer cancel()
+	return s.Sync(ctx)
+}
+
+// RevokeToken revokes a single access token until it expires
+func (s *Store) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := s.db.RevokeTokenID(ctx, jti, expiresAt); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.tokens[jti] = expiresAt
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeUser revokes every access token of the user issued until now.
+// ttl is the lifetime of access tokens, after which the record is useless.
+func (s *Store) RevokeUser(ctx context.Context, userID int, ttl time.Duration) error {
+	now := time.Now()
+	// Issue times carry microseconds, so rounding up covers every token
+	// issued until now and none issued once this call returns
+	cutoff := now.Truncate(time.Microsecond).Add(time.Microsecond)
+	r := db.UserRevocation{RevokedBefore: cutoff, ExpiresAt: now.Add(ttl)}
+	if err := s.db.RevokeUserTokensBefore(ctx, userID, r.RevokedBefore, r.ExpiresAt); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.users[userID] = r
+	s.mu.Unlock()
+	return nil
+}
+
+// IsRevoked reports whether a token with the given ID, owner and issue time
+// has been revoked. A token issued at a user's cutoff counts as revoked.
+func (s *Store) IsRevoked(jti string, userID int, issuedAt time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.tokens[jti]; ok {
+		return true
+	}
+	if r, ok := s.users[userID]; ok && !issuedAt.After(r.RevokedBefore) {
+		return true
+	}
+	return false
+}
+
+// Sync merges revocations recorded since the last sync, including those of
+// other instances, and drops entries whose tokens have expired
+func (s *Store) Sync(ctx context.Context) error {
+	s.mu.RLock()
+	since := s.lastSync.Add(-syncOverlap)
+	s.mu.RUnlock()
+	started := time.Now()
+
+	tokens, err := s.db.GetRevokedTokenIDsSince(ctx, since)
+	if err != nil {
+		return err
+	}
+	users, err := s.db.GetUserRevocationsSince(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for jti, expiresAt := range tokens {
+		s.tokens[jti] = expiresAt
+	}
+	for userID, r := range users {
+		if current, ok := s.users[userID]; !ok || r.RevokedBefore.After(current.RevokedBefore) {
+			s.users[userID] = r
+		}
+	}
+	s.expire(started)
+	s.lastSync = started
+	s.mu.Unlock()
+
+	return s.db.DeleteExpiredRevocations(ctx)
+}
+
+// expire drops entries that no longer match any valid token; s.mu must be held
+func (s *Store) expire(now time.Time) {
+	for jti, expiresAt := range s.tokens {
+		if !expiresAt.After(now) {
+			delete(s.tokens, jti)
+		}
+	}
+	for userID, r := range s.users {
+		if !r.ExpiresAt.After(now) {
+			delete(s.users, userID)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// JWK is the public part of a key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set. Shared HMAC secrets are never
+// published, so services verifying HS256 tokens still need the secret.
+func (ks *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, kid := range ks.order {
+		key := ks.keys[kid]
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return set
+}
+
+// PublicKey decodes the key and returns it with the signing method it is
+// used with, for verifying tokens issued by other services
+func (k JWK) PublicKey() (interface{}, jwt.SigningMethod, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, nil, err
+		}
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if k.Alg != "" && k.Alg != "RS256" {
+			return nil, nil, fmt.Errorf("unsupported RSA algorithm %q", k.Alg)
+		}
+		return public, jwt.SigningMethodRS256, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, nil, errors.New("invalid Ed25519 key length")
+		}
+		return ed25519.PublicKey(x), SigningMethodEdDSA, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,297 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Key is a single signing or verification key identified by its kid
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// private is nil for keys that may only verify tokens, e.g. a retired
+	// RSA key of which only the public half is still configured
+	private interface{}
+	public  interface{}
+}
+
+// CanSign reports whether the key holds private material
+func (k *Key) CanSign() bool {
+	return k.private != nil
+}
+
+// KeySet holds every key that is accepted for verification and the one
+// currently used for signing. Several keys stay active during rotation.
+type KeySet struct {
+	keys    map[string]*Key
+	order   []string
+	signing *Key
+}
+
+// Keys is the key set used by the application, set by InitKeys
+var Keys *KeySet
+
+// InitKeys loads the application key set from the configuration:
+//
+//	spec        comma separated kid:alg:source entries, where source is
+//	            file:/path/to/key.pem or env:VARIABLE_NAME (JWT_KEYS)
+//	signingKID  kid of the key used to sign new tokens, default: first entry
+//	            (JWT_SIGNING_KEY)
+//	secret      shorthand for a single HS256 key with kid "default" (JWT_SECRET)
+//
+// HS256 sources hold the raw secret, RS256 and EdDSA sources hold a PEM
+// encoded private key, or a public key for verify-only entries.
+func InitKeys(spec, signingKID, secret string) {
+	ks, err := LoadKeySet(spec, signingKID, secret)
+	if err != nil {
+		log.Fatalf("could not load signing keys: %v", err)
+	}
+	Keys = ks
+}
+
+// LoadKeySet builds a KeySet from the specification described in InitKeys.
+// With nothing configured it falls back to an ephemeral Ed25519 key.
+func LoadKeySet(spec, signingKID, secret string) (*KeySet, error) {
+	ks := &KeySet{keys: map[string]*Key{}}
+
+	if secret != "" {
+		if err := ks.Add(&Key{ID: "default", Method: jwt.SigningMethodHS256, private: []byte(secret), public: []byte(secret)}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, err := parseKeyEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if err := ks.Add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ks.keys) == 0 {
+		log.Println("no JWT keys configured, using an ephemeral Ed25519 key; tokens will not survive a restart")
+		key, err := GenerateEdDSAKey("ephemeral")
+		if err != nil {
+			return nil, err
+		}
+		ks.Add(key)
+	}
+
+	if signingKID == "" {
+		for _, kid := range ks.order {
+			if ks.keys[kid].CanSign() {
+				signingKID = kid
+				break
+			}
+		}
+	}
+	signing, ok := ks.keys[signingKID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is not configured", signingKID)
+	}
+	if !signing.CanSign() {
+		return nil, fmt.Errorf("signing key %q has no private key", signingKID)
+	}
+	ks.signing = signing
+
+	return ks, nil
+}
+
+// GenerateEdDSAKey returns a new random Ed25519 signing key
+func GenerateEdDSAKey(id string) (*Key, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{ID: id, Method: SigningMethodEdDSA, private: private, public: public}, nil
+}
+
+// NewKeySet returns a set that signs with the given key
+func NewKeySet(key *Key) (*KeySet, error) {
+	if !key.CanSign() {
+		return nil, fmt.Errorf("signing key %q has no private key", key.ID)
+	}
+	ks := &KeySet{keys: map[string]*Key{}}
+	if err := ks.Add(key); err != nil {
+		return nil, err
+	}
+	ks.signing = key
+	return ks, nil
+}
+
+// Add registers a key in the set
+func (ks *KeySet) Add(key *Key) error {
+	if _, exists := ks.keys[key.ID]; exists {
+		return fmt.Errorf("duplicate key id %q", key.ID)
+	}
+	ks.keys[key.ID] = key
+	ks.order = append(ks.order, key.ID)
+	return nil
+}
+
+// Sign signs the claims with the current signing key and sets the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	t := jwt.NewWithClaims(ks.signing.Method, claims)
+	t.Header["kid"] = ks.signing.ID
+	return t.SignedString(ks.signing.private)
+}
+
+// Parse verifies a token against the key named by its kid header and
+// returns its claims. The token's alg must match the key's algorithm.
+func (ks *KeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	t, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		key, err := ks.lookup(t.Header["kid"])
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return key.public, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok || !t.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// lookup finds the verification key for a kid header. Tokens issued before
+// kids were introduced carry none and are accepted only by a single-key set.
+func (ks *KeySet) lookup(kid interface{}) (*Key, error) {
+	if kid == nil {
+		if len(ks.keys) == 1 {
+			return ks.keys[ks.order[0]], nil
+		}
+		return nil, errors.New("token has no kid")
+	}
+	id, ok := kid.(string)
+	if !ok {
+		return nil, errors.New("invalid kid")
+	}
+	key, ok := ks.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", id)
+	}
+	return key, nil
+}
+
+func parseKeyEntry(entry string) (*Key, error) {
+	parts := strings.SplitN(entry, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid key entry %q, expected kid:alg:source", entry)
+	}
+	kid, alg, source := parts[0], parts[1], parts[2]
+
+	material, err := readKeySource(source)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %v", kid, err)
+	}
+
+	key := &Key{ID: kid}
+	switch alg {
+	case "HS256":
+		key.Method = jwt.SigningMethodHS256
+		key.private, key.public = material, material
+	case "RS256":
+		key.Method = jwt.SigningMethodRS256
+		err = parseAsymmetricPEM(key, material)
+	case "EdDSA":
+		key.Method = SigningMethodEdDSA
+		err = parseAsymmetricPEM(key, material)
+	default:
+		return nil, fmt.Errorf("key %q: unsupported algorithm %q", kid, alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %v", kid, err)
+	}
+	return key, nil
+}
+
+func readKeySource(source string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(source, "file:"):
+		return os.ReadFile(strings.TrimPrefix(source, "file:"))
+	case strings.HasPrefix(source, "env:"):
+		name := strings.TrimPrefix(source, "env:")
+		value := os.Getenv(name)
+		if value == "" {
+			return nil, fmt.Errorf("environment variable %s is empty", name)
+		}
+		return []byte(value), nil
+	default:
+		return nil, fmt.Errorf("invalid key source %q, expected file: or env:", source)
+	}
+}
+
+// parseAsymmetricPEM fills in the key from a PEM private or public key and
+// checks that its type matches the key's algorithm
+func parseAsymmetricPEM(key *Key, material []byte) error {
+	block, _ := pem.Decode(material)
+	if block == nil {
+		return errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.private, key.public = k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.public = k
+	case ed25519.PrivateKey:
+		key.private, key.public = k, k.Public()
+	case ed25519.PublicKey:
+		key.public = k
+	default:
+		return fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		if key.Method != jwt.SigningMethodRS256 {
+			return errors.New("RSA key used with a non-RS256 algorithm")
+		}
+	case ed25519.PublicKey:
+		if key.Method != SigningMethodEdDSA {
+			return errors.New("Ed25519 key used with a non-EdDSA algorithm")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// pemEnv puts a PEM encoding of key in the environment variable name, for
+// a key entry with source env:name
+func pemEnv(t *testing.T, name string, key interface{}, public bool) {
+	t.Helper()
+	var der []byte
+	var err error
+	blockType := "PRIVATE KEY"
+	if public {
+		der, err = x509.MarshalPKIXPublicKey(key)
+		blockType = "PUBLIC KEY"
+	} else {
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(name, string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})))
+}
+
+func TestKeyRotation(t *testing.T) {
+	_, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, newKey, _ := ed25519.GenerateKey(rand.Reader)
+	pemEnv(t, "TEST_OLD_KEY", oldKey, false)
+	pemEnv(t, "TEST_NEW_KEY", newKey, false)
+
+	before, err := LoadKeySet("old:EdDSA:env:TEST_OLD_KEY", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := before.Sign(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// During rotation new tokens are signed with the new key and tokens
+	// from the previous one still verify
+	during, err := LoadKeySet("old:EdDSA:env:TEST_OLD_KEY,new:EdDSA:env:TEST_NEW_KEY", "new", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := during.Sign(jwt.MapClaims{"sub": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := strings.Split(newToken, ".")[0]; !strings.Contains(decodeSegment(t, header), `"kid":"new"`) {
+		t.Errorf("new token header %s, want kid new", decodeSegment(t, header))
+	}
+	for _, tok := range []string{oldToken, newToken} {
+		if _, err := during.Parse(tok); err != nil {
+			t.Errorf("Parse during rotation: %v", err)
+		}
+	}
+
+	// Once the old key is retired its tokens are refused
+	after, err := LoadKeySet("new:EdDSA:env:TEST_NEW_KEY", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := after.Parse(oldToken); err == nil || !strings.Contains(err.Error(), `unknown kid "old"`) {
+		t.Errorf("Parse of a token from a retired key = %v, want unknown kid", err)
+	}
+	if _, err := after.Parse(newToken); err != nil {
+		t.Errorf("Parse after rotation: %v", err)
+	}
+}
+
+func TestParseRefusesForgedTokens(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	ks, err := NewKeySet(&Key{ID: "k", Method: SigningMethodEdDSA, private: private, public: public})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Add(&Key{ID: "hmac", Method: jwt.SigningMethodHS256, private: []byte("secret"), public: []byte("secret")}); err != nil {
+		t.Fatal(err)
+	}
+	valid, err := ks.Sign(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, ".")
+
+	// Another key under the same kid
+	_, otherPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	impostor := jwt.NewWithClaims(SigningMethodEdDSA, jwt.MapClaims{"sub": "1"})
+	impostor.Header["kid"] = "k"
+	forged, _ := impostor.SignedString(otherPrivate)
+
+	// The alg of another key than the one named by kid
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1"})
+	confused.Header["kid"] = "k"
+	wrongAlg, _ := confused.SignedString([]byte("secret"))
+
+	// No kid, with several keys configured
+	bare := jwt.NewWithClaims(SigningMethodEdDSA, jwt.MapClaims{"sub": "1"})
+	noKid, _ := bare.SignedString(private)
+
+	for name, tok := range map[string]string{
+		"tampered payload": parts[0] + "." + jwt.EncodeSegment([]byte(`{"sub":"2"}`)) + "." + parts[2],
+		"other key":        forged,
+		"wrong alg":        wrongAlg,
+		"no kid":           noKid,
+		"expired":          signWith(t, ks, jwt.MapClaims{"sub": "1", "exp": 1}),
+	} {
+		if _, err := ks.Parse(tok); err == nil {
+			t.Errorf("Parse accepted a token with %s", name)
+		}
+	}
+}
+
+func signWith(t *testing.T, ks *KeySet, claims jwt.MapClaims) string {
+	t.Helper()
+	tok, err := ks.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func decodeSegment(t *testing.T, seg string) string {
+	t.Helper()
+	b, err := jwt.DecodeSegment(seg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestVerifyOnlyKeys(t *testing.T) {
+	public, _, _ := ed25519.GenerateKey(rand.Reader)
+	pemEnv(t, "TEST_PUBLIC_KEY", public, true)
+
+	if _, err := LoadKeySet("retired:EdDSA:env:TEST_PUBLIC_KEY", "retired", ""); err == nil {
+		t.Error("a public key was accepted as the signing key")
+	}
+	ks, err := LoadKeySet("retired:EdDSA:env:TEST_PUBLIC_KEY", "", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks.signing.ID != "default" {
+		t.Errorf("signing with %q, want the only key that can sign", ks.signing.ID)
+	}
+
+	// Keys must be used with their own algorithm
+	if _, err := LoadKeySet("k:RS256:env:TEST_PUBLIC_KEY", "", ""); err == nil {
+		t.Error("an Ed25519 key was accepted for RS256")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	edPublic, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemEnv(t, "TEST_ED_KEY", edPrivate, false)
+	pemEnv(t, "TEST_RSA_KEY", rsaKey, false)
+	ks, err := LoadKeySet("ed:EdDSA:env:TEST_ED_KEY,rsa:RS256:env:TEST_RSA_KEY", "", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The HMAC secret is never published
+	set := ks.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want the Ed25519 and RSA ones: %+v", len(set.Keys), set.Keys)
+	}
+	ed, rs := set.Keys[0], set.Keys[1]
+	if ed.Kid != "ed" || ed.Kty != "OKP" || ed.Crv != "Ed25519" || ed.Alg != "EdDSA" || ed.Use != "sig" {
+		t.Errorf("Ed25519 JWK = %+v", ed)
+	}
+	if rs.Kid != "rsa" || rs.Kty != "RSA" || rs.Alg != "RS256" || rs.E != "AQAB" {
+		t.Errorf("RSA JWK = %+v", rs)
+	}
+
+	// Other services verify our tokens with the published keys
+	for _, jwk := range set.Keys {
+		public, method, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("%s: %v", jwk.Kid, err)
+		}
+		signer, err := LoadKeySet("ed:EdDSA:env:TEST_ED_KEY,rsa:RS256:env:TEST_RSA_KEY", jwk.Kid, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tok := signWith(t, signer, jwt.MapClaims{"sub": "1"})
+		parsed, err := jwt.Parse(tok, func(*jwt.Token) (interface{}, error) { return public, nil })
+		if err != nil || !parsed.Valid || parsed.Method != method {
+			t.Errorf("%s: verifying with the published key = %v", jwk.Kid, err)
+		}
+	}
+	if public, _, _ := ed.PublicKey(); !edPublic.Equal(public) {
+		t.Error("the published Ed25519 key differs from the configured one")
+	}
+}
+
+func TestEdDSA(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	sig, err := SigningMethodEdDSA.Sign("header.payload", private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SigningMethodEdDSA.Verify("header.payload", sig, public); err != nil {
+		t.Errorf("Verify of a valid signature: %v", err)
+	}
+	if err := SigningMethodEdDSA.Verify("header.payloaD", sig, public); err == nil {
+		t.Error("Verify accepted a signature of other data")
+	}
+	otherPublic, _, _ := ed25519.GenerateKey(rand.Reader)
+	if err := SigningMethodEdDSA.Verify("header.payload", sig, otherPublic); err == nil {
+		t.Error("Verify accepted another key")
+	}
+
+	// Keys of other types are refused rather than misused
+	if _, err := SigningMethodEdDSA.Sign("header.payload", []byte("secret")); err != jwt.ErrInvalidKeyType {
+		t.Errorf("Sign with an HMAC secret = %v, want ErrInvalidKeyType", err)
+	}
+	if err := SigningMethodEdDSA.Verify("header.payload", sig, []byte("secret")); err != jwt.ErrInvalidKeyType {
+		t.Errorf("Verify with an HMAC secret = %v, want ErrInvalidKeyType", err)
+	}
+
+	// The method is registered, so EdDSA tokens parse by their alg header
+	if jwt.GetSigningMethod("EdDSA") != SigningMethodEdDSA {
+		t.Error("EdDSA is not registered with jwt-go")
+	}
+}
@@ -0,0 +1,85 @@
+package token
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// EmailVerificationTTL is how long a verification link stays valid
+	EmailVerificationTTL = 24 * time.Hour
+	// MFAChallengeTTL is how long a user has to enter their TOTP code after the password
+	MFAChallengeTTL = 5 * time.Minute
+)
+
+// Purpose tokens are signed with the same keys as access tokens. Each
+// carries its purpose as its audience, so that no kind of token passes for
+// another.
+const (
+	purposeVerifyEmail  = "verify_email"
+	purposeMFAChallenge = "mfa_challenge"
+)
+
+// NewEmailVerificationToken signs a token for an email verification link.
+// The returned jti must be recorded so that the token can be used only once.
+func NewEmailVerificationToken(userID int) (tokenString, jti string, expiresAt time.Time, err error) {
+	return newPurposeToken(userID, purposeVerifyEmail, EmailVerificationTTL)
+}
+
+// ParseEmailVerificationToken verifies a token from NewEmailVerificationToken
+func ParseEmailVerificationToken(tokenString string) (userID int, jti string, err error) {
+	return parsePurposeToken(tokenString, purposeVerifyEmail)
+}
+
+// NewMFAChallengeToken signs the token that LoginUser hands out after a
+// correct password when the user still has to present a TOTP code
+func NewMFAChallengeToken(userID int) (string, error) {
+	tokenString, _, _, err := newPurposeToken(userID, purposeMFAChallenge, MFAChallengeTTL)
+	return tokenString, err
+}
+
+// ParseMFAChallengeToken verifies a token from NewMFAChallengeToken
+func ParseMFAChallengeToken(tokenString string) (int, error) {
+	userID, _, err := parsePurposeToken(tokenString, purposeMFAChallenge)
+	return userID, err
+}
+
+func newPurposeToken(userID int, purpose string, ttl time.Duration) (tokenString, jti string, expiresAt time.Time, err error) {
+	jti, err = NewFamilyID()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	expiresAt = time.Now().Add(ttl)
+
+	tokenString, err = Keys.Sign(jwt.MapClaims{
+		"sub": strconv.Itoa(userID),
+		"aud": purpose,
+		"jti": jti,
+		"exp": expiresAt.Unix(),
+	})
+	return tokenString, jti, expiresAt, err
+}
+
+func parsePurposeToken(tokenString, purpose string) (userID int, jti string, err error) {
+	claims, err := Keys.Parse(tokenString)
+	if err != nil {
+		return 0, "", err
+	}
+	if !claims.VerifyAudience(purpose, true) {
+		return 0, "", errors.New("token has the wrong purpose")
+	}
+
+	sub, _ := claims["sub"].(string)
+	userID, err = strconv.Atoi(sub)
+	if err != nil {
+		return 0, "", errors.New("invalid subject")
+	}
+	jti, _ = claims["jti"].(string)
+	if jti == "" {
+		return 0, "", errors.New("missing jti")
+	}
+	return userID, jti, nil
+}
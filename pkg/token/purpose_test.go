@@ -0,0 +1,70 @@
+package token
+
+import (
+	"testing"
+
+	"car_project/pkg/model"
+)
+
+// useTestKeys signs and verifies tokens with a fresh key for the rest of
+// the test
+func useTestKeys(t *testing.T) {
+	t.Helper()
+	key, err := GenerateEdDSAKey("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := Keys
+	if Keys, err = NewKeySet(key); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Keys = saved })
+}
+
+func TestTokenKindsAreNotInterchangeable(t *testing.T) {
+	useTestKeys(t)
+
+	access, err := NewAccessToken(&model.User{ID: 7, Username: "a", Role: model.RoleAdmin}, "session", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify, _, _, err := NewEmailVerificationToken(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := NewMFAChallengeToken(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims, err := ParseAccessToken(access); err != nil || claims["userID"] != float64(7) {
+		t.Errorf("ParseAccessToken of an access token = %v, %v", claims, err)
+	}
+	if id, _, err := ParseEmailVerificationToken(verify); err != nil || id != 7 {
+		t.Errorf("ParseEmailVerificationToken of its own token = %d, %v", id, err)
+	}
+	if id, err := ParseMFAChallengeToken(challenge); err != nil || id != 7 {
+		t.Errorf("ParseMFAChallengeToken of its own token = %d, %v", id, err)
+	}
+
+	// Purpose tokens are not access tokens
+	for name, tok := range map[string]string{"verification": verify, "challenge": challenge} {
+		if _, err := ParseAccessToken(tok); err == nil {
+			t.Errorf("ParseAccessToken accepted a %s token", name)
+		}
+	}
+
+	// Access tokens serve no purpose, and no purpose serves another
+	if _, _, err := ParseEmailVerificationToken(access); err == nil {
+		t.Error("ParseEmailVerificationToken accepted an access token")
+	}
+	if _, err := ParseMFAChallengeToken(access); err == nil {
+		t.Error("ParseMFAChallengeToken accepted an access token")
+	}
+	if _, _, err := ParseEmailVerificationToken(challenge); err == nil {
+		t.Error("ParseEmailVerificationToken accepted a challenge token")
+	}
+	if _, err := ParseMFAChallengeToken(verify); err == nil {
+		t.Error("ParseMFAChallengeToken accepted a verification token")
+	}
+}
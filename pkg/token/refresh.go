@@ -0,0 +1,118 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math"
+	"time"
+
+	"car_project/pkg/model"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// accessAudience is the aud claim of access tokens, telling them apart from
+// purpose tokens
+const accessAudience = "access"
+
+// Token lifetimes, overridden from the configuration at startup
+var (
+	// AccessTokenTTL is how long a JWT issued at login or refresh stays valid
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is how long an opaque refresh token can be exchanged
+	RefreshTokenTTL = 30 * 24 * time.Hour
+	// PasswordResetTTL is how long a password reset link stays valid
+	PasswordResetTTL = time.Hour
+	// OrgInvitationTTL is how long an invitation to an organization stays valid
+	OrgInvitationTTL = 7 * 24 * time.Hour
+)
+
+// NewAccessToken signs a short-lived JWT for the given user. sessionID is the
+// refresh token family the token belongs to, and mfa records whether the
+// session was opened with a second factor.
+func NewAccessToken(user *model.User, sessionID string, mfa bool) (string, error) {
+	jti, err := NewFamilyID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return Keys.Sign(jwt.MapClaims{
+		"aud":      accessAudience,
+		"username": user.Username,
+		"userID":   user.ID,
+		"role":     string(user.Role),
+		"org":      user.ActiveOrgID,
+		"mfa":      mfa,
+		"sid":      sessionID,
+		"jti":      jti,
+		"iat":      float64(now.UnixMicro()) / 1e6,
+		"exp":      now.Add(AccessTokenTTL).Unix(),
+	})
+}
+
+// ParseAccessToken verifies a token from NewAccessToken and returns its
+// claims. Purpose tokens, signed with the same keys, are refused.
+func ParseAccessToken(tokenString string) (jwt.MapClaims, error) {
+	claims, err := Keys.Parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyAudience(accessAudience, true) {
+		return nil, errors.New("not an access token")
+	}
+	return claims, nil
+}
+
+// IssuedAt returns the issue time recorded in an access token's iat claim.
+// NewAccessToken writes it with microseconds, so that a revocation can tell
+// tokens issued just before it from those issued just after.
+func IssuedAt(iat float64) time.Time {
+	return time.UnixMicro(int64(math.Round(iat * 1e6)))
+}
+
+// NewRefreshToken returns a random opaque token for the client and the hash to store
+func NewRefreshToken() (string, string, error) {
+	return NewOpaqueToken()
+}
+
+// HashRefreshToken returns the value stored in the database for a refresh token
+func HashRefreshToken(plain string) string {
+	return HashOpaqueToken(plain)
+}
+
+// NewOpaqueToken returns a random token for the client and its hash to store.
+// Only the hash is persisted, so a database leak exposes no usable tokens.
+func NewOpaqueToken() (string, string, error) {
+	plain, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return plain, HashOpaqueToken(plain), nil
+}
+
+// HashOpaqueToken returns the stored form of a token from NewOpaqueToken
+func HashOpaqueToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewFamilyID returns an identifier for a new chain of refresh tokens
+func NewFamilyID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
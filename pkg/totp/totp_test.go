@@ -0,0 +1,80 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, base32 encoded
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	// RFC 6238 Appendix B gives 8 digit codes; ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil || got != tt.want {
+			t.Errorf("Code at %d = %q, %v, want %q", tt.unix, got, err, tt.want)
+		}
+	}
+}
+
+func TestCodeAcceptsLowercaseSecret(t *testing.T) {
+	upper, _ := Code(rfcSecret, 1)
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil || lower != upper {
+		t.Errorf("Code with a lowercase secret = %q, %v, want %q", lower, err, upper)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code with an invalid secret succeeded")
+	}
+}
+
+func TestValidateDrift(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	for _, tt := range []struct {
+		drift int64
+		ok    bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	} {
+		code, err := Code(rfcSecret, current+tt.drift)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, now)
+		if ok != tt.ok || ok && step != current+tt.drift {
+			t.Errorf("code %d steps off = step %d, %v, want %v", tt.drift, step, ok, tt.ok)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	code, _ := Code(rfcSecret, Step(now))
+	if _, ok := Validate(rfcSecret, " "+code+"\n", now); !ok {
+		t.Error("surrounding whitespace was not ignored")
+	}
+	for _, bad := range []string{"", "28708", "2870822", "abcdef", "94287082"} {
+		if _, ok := Validate(rfcSecret, bad, now); ok {
+			t.Errorf("Validate(%q) accepted", bad)
+		}
+	}
+}